@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/GESkunkworks/dustcollector"
+)
+
+// runApply executes the deletion plan of the saved state after asking
+// for confirmation, saves the updated state, and prints the status of
+// every step.
+func runApply(args []string) (err error) {
+	o := newOptions("apply", "apply [flags]")
+	yes := o.fs.Bool("yes", false, "apply without asking for confirmation")
+	progress := o.fs.Duration("progress", 0, "write progress with an ETA to stderr at this interval, e.g. 5s")
+	err = o.parse(args)
+	if err != nil {
+		return err
+	}
+	if *progress > 0 {
+		reporter := dustcollector.NewProgressReporter(os.Stderr, *progress)
+		o.input.Hooks = reporter.Hooks(o.input.Hooks)
+	}
+	exp, err := o.results(false)
+	if err != nil {
+		return err
+	}
+	// refuse to delete anything in an account or region other than
+	// the one that was scanned
+	err = exp.VerifyIdentity(context.Background())
+	if err != nil {
+		return err
+	}
+	var pending int
+	for _, step := range exp.Plan {
+		if step.Status != dustcollector.StepDeleted {
+			pending++
+		}
+	}
+	if pending == 0 {
+		fmt.Println("nothing to apply")
+		return nil
+	}
+	if !*yes {
+		fmt.Printf(
+			"%d resources in account %s will be deleted. Type yes to continue: ",
+			pending, exp.GetSummary().Account,
+		)
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if strings.TrimSpace(answer) != "yes" {
+			return errors.New("apply cancelled")
+		}
+	}
+	// stop applying on the first interrupt, leaving the remaining
+	// steps pending in the journal for a later run
+	ctx, cancel := interruptContext()
+	defer cancel()
+	err = exp.ApplyWithContext(ctx)
+	// save the step statuses even when some steps failed
+	if serr := exp.ExportState(); serr != nil && err == nil {
+		err = serr
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, step := range exp.Plan {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", step.Status, step.ResourceType, step.ResourceId, step.Reason)
+	}
+	tw.Flush()
+	return err
+}
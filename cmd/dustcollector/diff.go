@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+// runDiff compares the deletion plans of two saved states.
+func runDiff(args []string) (err error) {
+	o := newOptions("diff", "diff [flags] OLD-STATE NEW-STATE")
+	output := o.fs.String("o", "text", "output format: text or json")
+	err = o.parse(args)
+	if err != nil {
+		return err
+	}
+	if o.fs.NArg() != 2 {
+		o.fs.Usage()
+		return errors.New("two state files are required")
+	}
+	previous, err := o.expedition()
+	if err != nil {
+		return err
+	}
+	err = previous.LoadState(o.fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	exp, err := o.expedition()
+	if err != nil {
+		return err
+	}
+	err = exp.LoadState(o.fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	d := exp.Diff(previous)
+	switch *output {
+	case "text":
+		return d.WriteDiff(os.Stdout)
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(d)
+	}
+	return errors.New("unknown output format " + *output)
+}
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/GESkunkworks/dustcollector"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/inconshreveable/log15"
+)
+
+// envPrefix is prepended to the upper-cased flag name (with dashes
+// replaced by underscores) to get the environment variable that can be
+// used instead of the flag, e.g. DUSTCOLLECTOR_DATE_FILTER.
+const envPrefix = "DUSTCOLLECTOR_"
+
+// stringPtr, intPtr, and floatPtr are flag values that only set the
+// ExpeditionInput field they point to when the flag is given so that
+// the package defaults apply otherwise.
+type stringPtr struct{ p **string }
+
+func (v stringPtr) String() string {
+	if v.p == nil || *v.p == nil {
+		return ""
+	}
+	return **v.p
+}
+
+func (v stringPtr) Set(s string) error {
+	*v.p = &s
+	return nil
+}
+
+type intPtr struct{ p **int }
+
+func (v intPtr) String() string {
+	if v.p == nil || *v.p == nil {
+		return ""
+	}
+	return strconv.Itoa(**v.p)
+}
+
+func (v intPtr) Set(s string) error {
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	*v.p = &i
+	return nil
+}
+
+// rateLimits is a flag value of comma separated family=rate pairs.
+type rateLimits struct{ p *map[string]float64 }
+
+func (v rateLimits) String() string {
+	if v.p == nil {
+		return ""
+	}
+	var pairs []string
+	for family, rate := range *v.p {
+		pairs = append(pairs, family+"="+strconv.FormatFloat(rate, 'f', -1, 64))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (v rateLimits) Set(s string) error {
+	limits := make(map[string]float64)
+	for _, pair := range splitList(s) {
+		i := strings.LastIndex(pair, "=")
+		if i < 0 {
+			return fmt.Errorf("%q is not family=rate", pair)
+		}
+		rate, err := strconv.ParseFloat(pair[i+1:], 64)
+		if err != nil {
+			return err
+		}
+		limits[pair[:i]] = rate
+	}
+	*v.p = limits
+	return nil
+}
+
+// stringList is a flag value of comma separated strings.
+type stringList struct{ p *[]string }
+
+func (v stringList) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return strings.Join(*v.p, ",")
+}
+
+func (v stringList) Set(s string) error {
+	*v.p = splitList(s)
+	return nil
+}
+
+// tagMap is a flag value of comma separated key=value pairs. A key
+// without "=" has an empty value.
+type tagMap struct{ p *map[string]string }
+
+func (v tagMap) String() string {
+	if v.p == nil {
+		return ""
+	}
+	var pairs []string
+	for key, value := range *v.p {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (v tagMap) Set(s string) error {
+	tags := make(map[string]string)
+	for _, pair := range splitList(s) {
+		key, value := pair, ""
+		if i := strings.Index(pair, "="); i >= 0 {
+			key, value = pair[:i], pair[i+1:]
+		}
+		if key == "" {
+			return fmt.Errorf("%q has no tag key", pair)
+		}
+		tags[key] = value
+	}
+	*v.p = tags
+	return nil
+}
+
+type floatPtr struct{ p **float64 }
+
+func (v floatPtr) String() string {
+	if v.p == nil || *v.p == nil {
+		return ""
+	}
+	return strconv.FormatFloat(**v.p, 'f', -1, 64)
+}
+
+func (v floatPtr) Set(s string) error {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+	*v.p = &f
+	return nil
+}
+
+// options holds the flags shared by every command that runs an
+// Expedition. Every ExpeditionInput field has a flag except the
+// Session, Logger, and Hooks, which are set in code.
+type options struct {
+	fs       *flag.FlagSet
+	input    dustcollector.ExpeditionInput
+	config   string
+	account  string
+	profile  string
+	region   string
+	logLevel string
+
+	// flags that need more work than a plain assignment
+	messages        string
+	markdownTmpl    string
+	recommendations string
+	ownerTags       string
+	ownerPattern    string
+	terraformState  string
+}
+
+// newOptions returns the options for a command along with its flag set
+// so the command can add its own flags before calling parse.
+func newOptions(name, usage string) *options {
+	o := options{fs: flag.NewFlagSet(name, flag.ExitOnError)}
+	fs := o.fs
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: dustcollector %s\n\n", usage)
+		fmt.Fprintln(fs.Output(), "flags:")
+		fs.PrintDefaults()
+		fmt.Fprintf(
+			fs.Output(), "\nevery flag can also be set with an environment variable, e.g. "+
+				"-date-filter with %sDATE_FILTER\n", envPrefix,
+		)
+	}
+	fs.StringVar(&o.config, "config", "", "JSON, YAML, or TOML config file with the accounts, regions, and policy to use")
+	fs.StringVar(&o.account, "account", "", "only use this account of the -config")
+	fs.StringVar(&o.profile, "profile", "", "AWS shared config profile")
+	fs.StringVar(&o.region, "region", "", "AWS region, or with -config only use this region (default from the AWS config)")
+	fs.StringVar(&o.logLevel, "log-level", "info", "one of debug, info, warn, error, or crit")
+
+	in := &o.input
+	fs.Var(stringPtr{&in.DateFilter}, "date-filter", "ignore snapshots created after this date, YYYY-MM-DD (default 2019-01-01)")
+	fs.Var(intPtr{&in.MaxPages}, "max-pages", "maximum pages to fetch from each listing, results are flagged as truncated if reached (default no limit)")
+	fs.Var(intPtr{&in.MaxSnapshots}, "max-snapshots", "maximum snapshots to fetch, results are flagged as truncated if reached (default no limit)")
+	fs.Var(intPtr{&in.PageSize}, "page-size", "maximum snapshots per page (default 500)")
+	fs.Var(intPtr{&in.VolumeBatchSize}, "volume-batch-size", "volume IDs per DescribeVolumes call (default 200)")
+	fs.Var(intPtr{&in.VolumeWorkers}, "volume-workers", "goroutines describing volumes at the same time (default 10)")
+	fs.Var(stringList{&in.ExemptSnapshots}, "exempt-snapshots", "comma separated snapshot IDs that are never deleted")
+	fs.Var(tagMap{&in.ExemptTags}, "exempt-tags", "comma separated key=value tags of snapshots that are never deleted, a key alone matches any value")
+	fs.Var(intPtr{&in.SpareLaunchedWithinDays}, "spare-launched-days", "keep snapshots of AMIs launched within this many days (default disabled)")
+	fs.Var(rateLimits{&in.RateLimits}, "rate-limits", "requests per second by API family, e.g. ec2:read=10,autoscaling:read=5")
+	fs.Var(intPtr{&in.MaxRetries}, "max-retries", "retries of failed or throttled AWS requests (default 8)")
+	fs.Var(floatPtr{&in.EbsSnapRate}, "ebs-snap-rate", "EBS snapshot rate per GB-month (default 0.05)")
+	fs.Var(stringPtr{&in.Language}, "language", "language of the reports (default en)")
+	fs.StringVar(&o.messages, "messages", "", "json file of message catalog overrides by key")
+	fs.StringVar(&o.markdownTmpl, "markdown-template", "", "file with a text/template for the Markdown summary")
+	fs.StringVar(&o.recommendations, "recommendations-template", "", "file with a text/template for the recommendations")
+	fs.StringVar(&o.ownerTags, "owner-tags", "", "comma separated tag keys identifying snapshot owners (default Owner,Team,CostCenter)")
+	fs.StringVar(&o.ownerPattern, "owner-pattern", "", "regular expression finding the owner in the snapshot description")
+	fs.StringVar(&o.terraformState, "terraform-state", "", "comma separated Terraform state files for the IaC report")
+	fs.Var(stringPtr{&in.OutputDirectory}, "output-dir", "directory for the output files that are not set (default the current directory)")
+	fs.Var(stringPtr{&in.ApplyJournal}, "apply-journal", "apply journal file (default out-apply-journal.jsonl)")
+	fs.Var(stringPtr{&in.OutfileRecommendations}, "out-recommendations", "recommendations file (default out-summary.txt)")
+	fs.Var(stringPtr{&in.OutfileNuggets}, "out-nuggets", "nuggets csv file (default out-nuggets.csv)")
+	fs.Var(stringPtr{&in.OutfileBars}, "out-bars", "bars csv file (default out-bars.csv)")
+	fs.Var(stringPtr{&in.OutfileScript}, "out-script", "deletion script file (default out-plan.sh)")
+	fs.Var(stringPtr{&in.OutfileIaCReport}, "out-iac-report", "IaC report csv file (default out-iac-report.csv)")
+	fs.Var(stringPtr{&in.OutfileGraphDot}, "out-graph-dot", "Graphviz dependency graph file (default out-graph.dot)")
+	fs.Var(stringPtr{&in.OutfileGraphMermaid}, "out-graph-mermaid", "Mermaid dependency graph file (default out-graph.mmd)")
+	fs.Var(stringPtr{&in.OutfileState}, "out-state", "saved state file (default out-state.json)")
+	fs.Var(stringPtr{&in.OutfileHTML}, "out-html", "HTML report file (default out-report.html)")
+	fs.Var(stringPtr{&in.OutfileMarkdown}, "out-markdown", "Markdown summary file (default out-summary.md)")
+	fs.Var(stringPtr{&in.OutfileWorkbook}, "out-workbook", "xlsx workbook file (default out-workbook.xlsx)")
+	fs.Var(stringPtr{&in.OutfileOwnerReports}, "out-owners", "per-owner report directory (default out-owners)")
+	return &o
+}
+
+// envName returns the environment variable for a flag.
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// parse sets the flags from their environment variables and then from
+// args so that flags take precedence.
+func (o *options) parse(args []string) (err error) {
+	o.fs.VisitAll(func(f *flag.Flag) {
+		v, ok := os.LookupEnv(envName(f.Name))
+		if !ok || err != nil {
+			return
+		}
+		if serr := o.fs.Set(f.Name, v); serr != nil {
+			err = fmt.Errorf("invalid %s: %s", envName(f.Name), serr)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return o.fs.Parse(args)
+}
+
+// logger returns a logger writing to stderr at the chosen level so
+// that stdout only carries command output.
+func (o *options) logger() (logger log15.Logger, err error) {
+	lvl, err := log15.LvlFromString(o.logLevel)
+	if err != nil {
+		return nil, err
+	}
+	logger = log15.New()
+	logger.SetHandler(log15.LvlFilterHandler(
+		lvl, log15.StreamHandler(os.Stderr, log15.LogfmtFormat()),
+	))
+	return logger, nil
+}
+
+// session returns an AWS session for the chosen profile and region.
+func (o *options) session() (sess *session.Session, err error) {
+	opts := session.Options{
+		Profile:           o.profile,
+		SharedConfigState: session.SharedConfigEnable,
+	}
+	if o.region != "" {
+		opts.Config.Region = aws.String(o.region)
+	}
+	return session.NewSessionWithOptions(opts)
+}
+
+// flagInput completes the ExpeditionInput from the flags that need
+// more than an assignment.
+func (o *options) flagInput() (err error) {
+	input := &o.input
+	if o.messages != "" {
+		data, err := ioutil.ReadFile(o.messages)
+		if err != nil {
+			return err
+		}
+		err = json.Unmarshal(data, &input.Messages)
+		if err != nil {
+			return fmt.Errorf("parsing %s: %s", o.messages, err)
+		}
+	}
+	for _, tmpl := range []struct {
+		file   string
+		target **string
+	}{
+		{o.markdownTmpl, &input.MarkdownTemplate},
+		{o.recommendations, &input.RecommendationsTemplate},
+	} {
+		if tmpl.file == "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(tmpl.file)
+		if err != nil {
+			return err
+		}
+		text := string(data)
+		*tmpl.target = &text
+	}
+	if o.ownerTags != "" || o.ownerPattern != "" {
+		keys := dustcollector.DefaultOwnerTagKeys
+		if o.ownerTags != "" {
+			keys = splitList(o.ownerTags)
+		}
+		input.OwnerRules = dustcollector.TagOwnerRules(keys, o.ownerPattern)
+	}
+	input.TerraformStateFiles = splitList(o.terraformState)
+	return nil
+}
+
+// overlay copies the fields set by flags onto input so flags take
+// precedence over the config file.
+func (o *options) overlay(input *dustcollector.ExpeditionInput) {
+	from := reflect.ValueOf(&o.input).Elem()
+	to := reflect.ValueOf(input).Elem()
+	for i := 0; i < from.NumField(); i++ {
+		if !from.Field(i).IsZero() {
+			to.Field(i).Set(from.Field(i))
+		}
+	}
+}
+
+// loadConfig returns the -config file, or nil if there is none.
+func (o *options) loadConfig() (cfg *dustcollector.Config, err error) {
+	if o.config == "" {
+		return nil, nil
+	}
+	return dustcollector.LoadConfig(o.config)
+}
+
+// targets returns the accounts and regions to use. Without -config
+// there is one target for the -profile and -region. With -config there
+// is one for every account and region of the config, limited by
+// -account and -region.
+func (o *options) targets() (targets []*dustcollector.ConfigTarget, err error) {
+	err = o.flagInput()
+	if err != nil {
+		return nil, err
+	}
+	logger, err := o.logger()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := o.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		input := &o.input
+		input.Session, err = o.session()
+		if err != nil {
+			return nil, err
+		}
+		input.Logger = &logger
+		return []*dustcollector.ConfigTarget{{
+			Account: o.profile,
+			Region:  aws.StringValue(input.Session.Config.Region),
+			Input:   input,
+		}}, nil
+	}
+	if o.profile != "" {
+		return nil, fmt.Errorf("-profile can't be used with -config, select an account with -account")
+	}
+	all, err := cfg.Targets(&logger)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range all {
+		if (o.account == "" || t.Account == o.account) && (o.region == "" || t.Region == o.region) {
+			o.overlay(t.Input)
+			targets = append(targets, t)
+		}
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no account and region in %s match -account and -region", o.config)
+	}
+	return targets, nil
+}
+
+// expeditionInput returns the ExpeditionInput for the one target
+// selected by the flags.
+func (o *options) expeditionInput() (input *dustcollector.ExpeditionInput, err error) {
+	targets, err := o.targets()
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) > 1 {
+		return nil, fmt.Errorf(
+			"%s has %d accounts and regions, select one with -account and -region",
+			o.config, len(targets),
+		)
+	}
+	return targets[0].Input, nil
+}
+
+// expedition returns a new Expedition configured from the flags.
+func (o *options) expedition() (exp *dustcollector.Expedition, err error) {
+	input, err := o.expeditionInput()
+	if err != nil {
+		return nil, err
+	}
+	return dustcollector.New(input)
+}
+
+// results returns an Expedition with results, either from a new scan
+// when scan is true (saving the state afterwards) or from the saved
+// state file.
+func (o *options) results(scan bool) (exp *dustcollector.Expedition, err error) {
+	exp, err = o.expedition()
+	if err != nil {
+		return nil, err
+	}
+	if scan {
+		err = exp.Start()
+		if err != nil {
+			return nil, err
+		}
+		return exp, exp.ExportState()
+	}
+	return exp, exp.LoadState(exp.StatePath())
+}
+
+// splitList splits a comma separated flag value.
+func splitList(s string) (items []string) {
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
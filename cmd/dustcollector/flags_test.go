@@ -0,0 +1,153 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/GESkunkworks/dustcollector"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/inconshreveable/log15"
+)
+
+// setenv sets an environment variable for the rest of the test.
+func setenv(t *testing.T, key, value string) {
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+// TestParsePrecedence makes sure environment variables set the flags
+// and flags given on the command line take precedence over them.
+func TestParsePrecedence(t *testing.T) {
+	setenv(t, "DUSTCOLLECTOR_DATE_FILTER", "2018-01-01")
+	setenv(t, "DUSTCOLLECTOR_PAGE_SIZE", "100")
+	setenv(t, "DUSTCOLLECTOR_RATE_LIMITS", "ec2:read=5")
+	setenv(t, "DUSTCOLLECTOR_TERRAFORM_STATE", "a.tfstate")
+	setenv(t, "DUSTCOLLECTOR_LOG_LEVEL", "debug")
+	o := newOptions("test", "test")
+	err := o.parse([]string{"-page-size", "200", "-terraform-state", "b.tfstate, c.tfstate"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := o.input
+	if aws.StringValue(in.DateFilter) != "2018-01-01" {
+		t.Errorf("got date filter %v, want the environment's 2018-01-01", aws.StringValue(in.DateFilter))
+	}
+	if aws.IntValue(in.PageSize) != 200 {
+		t.Errorf("got page size %d, want the flag's 200", aws.IntValue(in.PageSize))
+	}
+	if want := map[string]float64{"ec2:read": 5}; !reflect.DeepEqual(in.RateLimits, want) {
+		t.Errorf("got rate limits %v, want %v", in.RateLimits, want)
+	}
+	if o.terraformState != "b.tfstate, c.tfstate" {
+		t.Errorf("got terraform state %q, want the flag's", o.terraformState)
+	}
+	if o.logLevel != "debug" {
+		t.Errorf("got log level %q, want debug", o.logLevel)
+	}
+	// flags that are not given leave the package defaults alone
+	if in.MaxPages != nil || in.Language != nil || in.OutfileState != nil {
+		t.Errorf("unset flags set the input: %+v", in)
+	}
+
+	setenv(t, "DUSTCOLLECTOR_MAX_PAGES", "many")
+	o = newOptions("test", "test")
+	err = o.parse(nil)
+	if err == nil || !strings.HasPrefix(err.Error(), "invalid DUSTCOLLECTOR_MAX_PAGES") {
+		t.Errorf("got error %v, want DUSTCOLLECTOR_MAX_PAGES rejected", err)
+	}
+}
+
+// TestOverlay makes sure the fields set by flags replace those of the
+// config and the others are kept.
+func TestOverlay(t *testing.T) {
+	logger := log15.New()
+	cfgInput := &dustcollector.ExpeditionInput{
+		DateFilter:   aws.String("2017-01-01"),
+		PageSize:     aws.Int(50),
+		Language:     aws.String("fr"),
+		OutfileState: aws.String("config-state.json"),
+		MaxRetries:   aws.Int(3),
+		RateLimits:   map[string]float64{"ec2:read": 5},
+		Logger:       &logger,
+	}
+	o := newOptions("test", "test")
+	err := o.parse([]string{
+		"-page-size", "10", "-out-state", "flag-state.json",
+		"-terraform-state", "a.tfstate,b.tfstate",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = o.flagInput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.overlay(cfgInput)
+	want := &dustcollector.ExpeditionInput{
+		DateFilter:          aws.String("2017-01-01"),
+		PageSize:            aws.Int(10),
+		Language:            aws.String("fr"),
+		OutfileState:        aws.String("flag-state.json"),
+		MaxRetries:          aws.Int(3),
+		RateLimits:          map[string]float64{"ec2:read": 5},
+		TerraformStateFiles: []string{"a.tfstate", "b.tfstate"},
+		Logger:              &logger,
+	}
+	if !reflect.DeepEqual(cfgInput, want) {
+		t.Errorf("got %+v, want %+v", cfgInput, want)
+	}
+}
+
+// TestExemptionFlags checks -exempt-snapshots, -exempt-tags, and
+// -output-dir from both the environment and the command line, and that
+// they replace the config's exemptions and directory.
+func TestExemptionFlags(t *testing.T) {
+	setenv(t, "DUSTCOLLECTOR_EXEMPT_TAGS", "Keep,aws:backup=yes")
+	setenv(t, "DUSTCOLLECTOR_EXEMPT_SNAPSHOTS", "snap-1")
+	o := newOptions("test", "test")
+	err := o.parse([]string{"-exempt-snapshots", "snap-2, snap-3", "-output-dir", "from-flag"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfgInput := &dustcollector.ExpeditionInput{
+		OutputDirectory: aws.String("from-config"),
+		ExemptSnapshots: []string{"snap-0"},
+		ExemptTags:      map[string]string{"Team": "ops"},
+	}
+	o.overlay(cfgInput)
+	want := &dustcollector.ExpeditionInput{
+		OutputDirectory: aws.String("from-flag"),
+		ExemptSnapshots: []string{"snap-2", "snap-3"},
+		ExemptTags:      map[string]string{"Keep": "", "aws:backup": "yes"},
+	}
+	if !reflect.DeepEqual(cfgInput, want) {
+		t.Errorf("got %+v, want %+v", cfgInput, want)
+	}
+}
+
+// TestTagMap checks the parsing of -exempt-tags.
+func TestTagMap(t *testing.T) {
+	var tags map[string]string
+	v := tagMap{&tags}
+	if err := v.Set("Keep, Team=a=b,Env="); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"Keep": "", "Team": "a=b", "Env": ""}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("got %v, want %v", tags, want)
+	}
+	if s := v.String(); s != "Env=,Keep=,Team=a=b" {
+		t.Errorf("got %q", s)
+	}
+	if err := v.Set("=x"); err == nil {
+		t.Error("tag without a key was accepted")
+	}
+}
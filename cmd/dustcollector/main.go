@@ -0,0 +1,84 @@
+// Command dustcollector analyzes the EBS snapshots of an AWS account
+// for old, orphaned snapshots. See the documentation of the
+// github.com/GESkunkworks/dustcollector package for details.
+//
+// Usage:
+//
+//	dustcollector <command> [flags]
+//
+// The scan command analyzes the account and saves the results to the
+// state file (-out-state). The plan, report, apply, and diff commands
+// work from saved state files so an account can be scanned once and the
+// results reviewed and applied later:
+//
+//	dustcollector scan -profile prod -region us-east-1
+//	dustcollector report -formats html,markdown,xlsx
+//	dustcollector apply
+//
+// Every ExpeditionInput field except the Session, Logger, and Hooks has
+// a flag and every flag can also be set with an environment variable
+// named after it, e.g. DUSTCOLLECTOR_DATE_FILTER for -date-filter or
+// DUSTCOLLECTOR_EXEMPT_TAGS for -exempt-tags. Flags take precedence over
+// environment variables, and both take precedence over the -config file.
+//
+// Run "dustcollector <command> -h" for the flags of each command.
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// command is a subcommand of the tool. run is called with the
+// arguments that follow the command name.
+type command struct {
+	summary string
+	run     func(args []string) error
+}
+
+var commands = map[string]command{
+	"scan":   {"analyze the account and save the results", runScan},
+	"plan":   {"show the deletion plan", runPlan},
+	"report": {"write reports for the results", runReport},
+	"apply":  {"execute the deletion plan", runApply},
+	"diff":   {"compare the deletion plans of two saved states", runDiff},
+	"serve":  {"run scheduled scans and serve the REST API", runServe},
+	"notify": {"send test notifications to a webhook", runNotify},
+	"trends": {"show waste and realized savings over time", runTrends},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: dustcollector <command> [flags]")
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].summary)
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	if os.Args[1] == "-h" || os.Args[1] == "--help" || os.Args[1] == "help" {
+		usage()
+		return
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "dustcollector: unknown command %q\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+	err := cmd.run(os.Args[2:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dustcollector %s: %s\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/GESkunkworks/dustcollector"
+)
+
+// runNotify sends a test notification, and optionally the scan and
+// apply notifications for a saved state, to a webhook or to a local
+// stand-in that prints what it receives.
+func runNotify(args []string) (err error) {
+	o := newOptions("notify", "notify [flags]")
+	fs := o.fs
+	url := fs.String("url", "", "webhook URL")
+	format := fs.String("format", dustcollector.WebhookGeneric, "one of slack, teams, or generic")
+	tmpl := fs.String("template", "", "file with a text/template for the message")
+	state := fs.String("state", "", "also send scan and apply notifications for this saved state")
+	standIn := fs.Bool("standin", false, "post to a local stand-in instead of -url and print the payloads")
+	failFirst := fs.Int("standin-fail", 1, "number of requests the stand-in rejects to exercise retries")
+	err = o.parse(args)
+	if err != nil {
+		return err
+	}
+	logger, err := o.logger()
+	if err != nil {
+		return err
+	}
+	var stand *dustcollector.WebhookStandIn
+	if *standIn {
+		stand, err = dustcollector.StartWebhookStandIn("127.0.0.1:0", *failFirst)
+		if err != nil {
+			return err
+		}
+		defer stand.Close()
+		*url = stand.URL + "/hook"
+	}
+	if *url == "" {
+		return fmt.Errorf("-url or -standin is required")
+	}
+	hook := dustcollector.Webhook{URL: *url, Format: *format}
+	if *tmpl != "" {
+		data, err := ioutil.ReadFile(*tmpl)
+		if err != nil {
+			return err
+		}
+		hook.Template = string(data)
+	}
+	backoff := 100 * time.Millisecond
+	notifier, err := dustcollector.NewNotifier(&dustcollector.NotifierInput{
+		Webhooks:     []dustcollector.Webhook{hook},
+		RetryBackoff: &backoff,
+		Logger:       &logger,
+	})
+	if err != nil {
+		return err
+	}
+	err = notifier.Test()
+	if err == nil && *state != "" {
+		var exp *dustcollector.Expedition
+		exp, err = o.expedition()
+		if err == nil {
+			err = exp.LoadState(*state)
+		}
+		if err == nil {
+			err = notifier.NotifyScan(exp, nil, "")
+		}
+		if err == nil {
+			err = notifier.NotifyApply(exp, "", nil)
+		}
+	}
+	if stand != nil {
+		for _, d := range stand.Deliveries() {
+			fmt.Printf("%s %d %s\n%s\n\n", d.Time.Format(time.RFC3339), d.Status, d.Path, d.Body)
+		}
+	}
+	return err
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"os"
+)
+
+// runPlan prints the deletion plan of the saved state, or of a new scan
+// with -scan.
+func runPlan(args []string) (err error) {
+	o := newOptions("plan", "plan [flags]")
+	scan := o.fs.Bool("scan", false, "scan the account instead of reading the saved state (see -out-state)")
+	output := o.fs.String("o", "text", "output format: text, json, markdown, or script")
+	err = o.parse(args)
+	if err != nil {
+		return err
+	}
+	exp, err := o.results(*scan)
+	if err != nil {
+		return err
+	}
+	return writeResults(os.Stdout, exp, *output)
+}
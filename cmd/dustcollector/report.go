@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/GESkunkworks/dustcollector"
+)
+
+// reports maps the names accepted by -formats to the Export methods
+// writing them to their -out-* files.
+var reports = map[string]func(exp *dustcollector.Expedition) error{
+	"recommendations": (*dustcollector.Expedition).ExportRecommendations,
+	"nuggets":         (*dustcollector.Expedition).ExportNuggets,
+	"bars":            (*dustcollector.Expedition).ExportBars,
+	"script":          (*dustcollector.Expedition).ExportScript,
+	"iac":             (*dustcollector.Expedition).ExportIaCReport,
+	"dot":             (*dustcollector.Expedition).ExportGraphDot,
+	"mermaid":         (*dustcollector.Expedition).ExportGraphMermaid,
+	"html":            (*dustcollector.Expedition).ExportHTML,
+	"markdown":        (*dustcollector.Expedition).ExportMarkdown,
+	"xlsx":            (*dustcollector.Expedition).ExportWorkbook,
+	"owners":          (*dustcollector.Expedition).ExportOwnerReports,
+}
+
+func reportNames() (names []string) {
+	for name := range reports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runReport writes the chosen reports for the saved state, or for a new
+// scan with -scan.
+func runReport(args []string) (err error) {
+	o := newOptions("report", "report [flags]")
+	scan := o.fs.Bool("scan", false, "scan the account instead of reading the saved state (see -out-state)")
+	formats := o.fs.String(
+		"formats", "recommendations,nuggets,bars,script",
+		"comma separated reports to write, any of "+strings.Join(reportNames(), ", ")+", or all",
+	)
+	err = o.parse(args)
+	if err != nil {
+		return err
+	}
+	names := splitList(*formats)
+	if len(names) == 1 && names[0] == "all" {
+		names = reportNames()
+	}
+	for _, name := range names {
+		if _, ok := reports[name]; !ok {
+			return fmt.Errorf("unknown report %q", name)
+		}
+	}
+	exp, err := o.results(*scan)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		err = reports[name](exp)
+		if err != nil {
+			return fmt.Errorf("%s report: %s", name, err)
+		}
+	}
+	return nil
+}
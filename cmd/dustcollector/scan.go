@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/GESkunkworks/dustcollector"
+)
+
+// writeResults writes the results of an Expedition to w in one of the
+// output formats shared by the scan and plan commands.
+func writeResults(w io.Writer, exp *dustcollector.Expedition, format string) (err error) {
+	switch format {
+	case "text":
+		for _, line := range exp.GetRecommendations() {
+			fmt.Fprintln(w, line)
+		}
+		return nil
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(exp.GetSummary())
+	case "markdown":
+		return exp.WriteMarkdown(w)
+	case "script":
+		return exp.WriteScript(w)
+	}
+	return fmt.Errorf("unknown output format %q", format)
+}
+
+// interruptContext returns a context that is cancelled on the first
+// SIGINT or SIGTERM. Calling cancel stops listening for the signals.
+func interruptContext() (ctx context.Context, cancel context.CancelFunc) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-sig:
+			cancelCtx()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(sig)
+		cancelCtx()
+	}
+}
+
+// runScan analyzes the account, saves the state for the other commands,
+// and prints the recommendations. With -config every account and region
+// of the config is scanned in turn.
+func runScan(args []string) (err error) {
+	o := newOptions("scan", "scan [flags]")
+	output := o.fs.String("o", "text", "output format: text, json, or markdown")
+	progress := o.fs.Duration("progress", 0, "write progress with an ETA to stderr at this interval, e.g. 5s")
+	timeout := o.fs.Duration("timeout", 0, "stop scanning after this long, e.g. 10m (default no limit)")
+	err = o.parse(args)
+	if err != nil {
+		return err
+	}
+	// stop the scan on the first interrupt
+	ctx, cancel := interruptContext()
+	defer cancel()
+	if *timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, *timeout)
+		defer cancelTimeout()
+	}
+	targets, err := o.targets()
+	if err != nil {
+		return err
+	}
+	for _, t := range targets {
+		if *progress > 0 {
+			reporter := dustcollector.NewProgressReporter(os.Stderr, *progress)
+			t.Input.Hooks = reporter.Hooks(t.Input.Hooks)
+		}
+		exp, err := dustcollector.New(t.Input)
+		if err != nil {
+			return err
+		}
+		err = exp.StartWithContext(ctx)
+		if err == nil {
+			err = exp.ExportState()
+		}
+		if err != nil {
+			return fmt.Errorf("account %s region %s: %s", t.Account, t.Region, err)
+		}
+		if len(targets) > 1 && *output == "text" {
+			fmt.Printf("== account %s region %s ==\n", t.Account, t.Region)
+		}
+		err = writeResults(os.Stdout, exp, *output)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/GESkunkworks/dustcollector"
+	"github.com/GESkunkworks/dustcollector/server"
+)
+
+// runServe runs scheduled scans and serves the REST API of the server
+// package until interrupted.
+func runServe(args []string) (err error) {
+	o := newOptions("serve", "serve [flags]")
+	addr := o.fs.String("listen", "127.0.0.1:8080", "address to serve the API on")
+	token := o.fs.String("token", os.Getenv("DUSTCOLLECTOR_API_TOKEN"), "bearer token required by the POST routes (default $DUSTCOLLECTOR_API_TOKEN)")
+	tlsCert := o.fs.String("tls-cert", "", "PEM certificate to serve the API over TLS")
+	tlsKey := o.fs.String("tls-key", "", "PEM key of the -tls-cert")
+	clientCA := o.fs.String("client-ca", "", "PEM CAs whose client certificates may use the POST routes, requires -tls-cert")
+	schedule := o.fs.String("schedule", "@daily", `cron expression for scans, "" to only scan on request`)
+	dataDir := o.fs.String("data-dir", "dustcollector-runs", "directory holding the history of runs")
+	webhook := o.fs.String("webhook-url", "", "webhook notified of scan findings and apply runs (default the notifiers of the -config)")
+	webhookFormat := o.fs.String("webhook-format", dustcollector.WebhookGeneric, "one of slack, teams, or generic")
+	err = o.parse(args)
+	if err != nil {
+		return err
+	}
+	input, err := o.expeditionInput()
+	if err != nil {
+		return err
+	}
+	srvInput := &server.Input{
+		ExpeditionInput: input,
+		Schedule:        schedule,
+		DataDir:         dataDir,
+		Addr:            addr,
+		AuthToken:       token,
+		TLSCertFile:     tlsCert,
+		TLSKeyFile:      tlsKey,
+		ClientCAFile:    clientCA,
+	}
+	cfg, err := o.loadConfig()
+	if err != nil {
+		return err
+	}
+	if *webhook == "" && cfg != nil {
+		var notifierInput *dustcollector.NotifierInput
+		notifierInput, err = cfg.NotifierInput(input.Logger)
+		if err == nil && notifierInput != nil {
+			srvInput.Notifier, err = dustcollector.NewNotifier(notifierInput)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if *webhook != "" {
+		srvInput.Notifier, err = dustcollector.NewNotifier(&dustcollector.NotifierInput{
+			Webhooks: []dustcollector.Webhook{{URL: *webhook, Format: *webhookFormat}},
+			Logger:   input.Logger,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	srv, err := server.New(srvInput)
+	if err != nil {
+		return err
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		srv.Close()
+	}()
+	return srv.ListenAndServe()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/GESkunkworks/dustcollector"
+)
+
+// runTrends prints the waste and realized savings recorded in a
+// TrendStore (e.g., the one kept by the server) over time.
+func runTrends(args []string) (err error) {
+	fs := flag.NewFlagSet("trends", flag.ExitOnError)
+	db := fs.String("db", "dustcollector-runs/trends.jsonl", "trend store file")
+	account := fs.String("account", "", "only include this account")
+	region := fs.String("region", "", "only include this region")
+	since := fs.String("since", "", "only include points from this date (YYYY-MM-DD)")
+	until := fs.String("until", "", "only include points before this date (YYYY-MM-DD)")
+	interval := fs.String("interval", dustcollector.IntervalDay, "one of run, day, week, or month")
+	format := fs.String("format", "table", "one of table, csv, or json")
+	fs.Parse(args)
+
+	if _, err = os.Stat(*db); err != nil {
+		return err
+	}
+	ts, err := dustcollector.OpenTrendStore(*db)
+	if err != nil {
+		return err
+	}
+	q := dustcollector.TrendQuery{
+		Account:  *account,
+		Region:   *region,
+		Interval: *interval,
+	}
+	if *since != "" {
+		q.Since, err = time.Parse("2006-01-02", *since)
+		if err != nil {
+			return fmt.Errorf("invalid -since: %s", err)
+		}
+	}
+	if *until != "" {
+		q.Until, err = time.Parse("2006-01-02", *until)
+		if err != nil {
+			return fmt.Errorf("invalid -until: %s", err)
+		}
+	}
+	points, err := ts.Query(q)
+	if err != nil {
+		return err
+	}
+	switch *format {
+	case "table":
+		return dustcollector.WriteTrendsTable(os.Stdout, points)
+	case "csv":
+		return dustcollector.WriteTrendsCSV(os.Stdout, points)
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(points)
+	}
+	return fmt.Errorf("unknown format %q", *format)
+}
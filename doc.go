@@ -35,6 +35,10 @@
 // After the expedition is complete you can collect a summary and 
 // deletion plan by calling GetRecommendations()
 //
+// If you are comfortable with the plan you can call Apply() to carry
+// it out. Each resource is re-checked immediately before it is deleted
+// and is skipped if it has come into use since the analysis.
+//
 // It provides methods to export the raw Snapshot information to CSV
 // that contains the additional metadata that was collected such as 
 // AMI's registered with the snapshots, LaunchConfigurations, and 
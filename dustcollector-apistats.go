@@ -0,0 +1,96 @@
+package dustcollector
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// apiStats counts the AWS API calls made during an Expedition keyed by
+// "service:operation". The counters are shared by every goroutine of
+// the Expedition so all access goes through the mutex.
+type apiStats struct {
+	mu     sync.Mutex
+	calls  map[string]int64
+	errors map[string]int64
+}
+
+// APICallCount is the number of calls made to a single AWS API operation.
+type APICallCount struct {
+	Service   string `json:"service"`
+	Operation string `json:"operation"`
+	Calls     int64  `json:"calls"`
+	Errors    int64  `json:"errors"`
+}
+
+// instrumentSession replaces the Expedition session with a copy that
+// counts every AWS API call made through it. The session that was passed
+// in the ExpeditionInput is left untouched.
+func (exp *Expedition) instrumentSession() {
+	exp.stats.calls = make(map[string]int64)
+	exp.stats.errors = make(map[string]int64)
+	exp.session = exp.session.Copy()
+	exp.session.Handlers.Complete.PushBackNamed(request.NamedHandler{
+		Name: "dustcollector.apiStats",
+		Fn:   exp.countAPICall,
+	})
+}
+
+// countAPICall is run by the AWS SDK once each request is complete,
+// including all retries.
+func (exp *Expedition) countAPICall(r *request.Request) {
+	key := r.ClientInfo.ServiceName + ":" + r.Operation.Name
+	exp.stats.mu.Lock()
+	defer exp.stats.mu.Unlock()
+	exp.stats.calls[key]++
+	if r.Error != nil {
+		exp.stats.errors[key]++
+	}
+}
+
+// GetAPICalls returns the number of AWS API calls made so far by the
+// Expedition for each operation, sorted by service and operation.
+func (exp *Expedition) GetAPICalls() (counts []APICallCount) {
+	exp.stats.mu.Lock()
+	defer exp.stats.mu.Unlock()
+	for key, calls := range exp.stats.calls {
+		service, operation := splitAPIKey(key)
+		counts = append(counts, APICallCount{
+			Service:   service,
+			Operation: operation,
+			Calls:     calls,
+			Errors:    exp.stats.errors[key],
+		})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Service != counts[j].Service {
+			return counts[i].Service < counts[j].Service
+		}
+		return counts[i].Operation < counts[j].Operation
+	})
+	return counts
+}
+
+// setAPICalls restores the counters from a saved state.
+func (exp *Expedition) setAPICalls(counts []APICallCount) {
+	exp.stats.mu.Lock()
+	defer exp.stats.mu.Unlock()
+	exp.stats.calls = make(map[string]int64)
+	exp.stats.errors = make(map[string]int64)
+	for _, c := range counts {
+		key := c.Service + ":" + c.Operation
+		exp.stats.calls[key] = c.Calls
+		exp.stats.errors[key] = c.Errors
+	}
+}
+
+// splitAPIKey splits a "service:operation" key.
+func splitAPIKey(key string) (service, operation string) {
+	parts := strings.SplitN(key, ":", 2)
+	if len(parts) < 2 {
+		return key, ""
+	}
+	return parts[0], parts[1]
+}
@@ -0,0 +1,543 @@
+package dustcollector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Resource types that can appear in a PlanStep. The deletion plan
+// always lists them in this order since each type may be blocking
+// the deletion of the next.
+const (
+	ResourceLaunchTemplate      = "LaunchTemplate"
+	ResourceLaunchConfiguration = "LaunchConfiguration"
+	ResourceAMI                 = "AMI"
+	ResourceSnapshot            = "Snapshot"
+)
+
+// Statuses recorded on a PlanStep by Apply.
+const (
+	StepPending = "pending"
+	StepDeleted = "deleted"
+	StepSkipped = "skipped"
+	StepFailed  = "failed"
+)
+
+// PlanStep is a single deletion in the ordered deletion plan. After
+// Apply has run, Status and Reason describe what happened to the
+// resource.
+type PlanStep struct {
+	// One of ResourceLaunchTemplate, ResourceLaunchConfiguration,
+	// ResourceAMI, or ResourceSnapshot
+	ResourceType string
+
+	// Name of the LaunchTemplate/LaunchConfiguration or the
+	// ID of the AMI/Snapshot
+	ResourceId string
+
+	// Why the analysis decided the resource can be removed
+	Justification string
+
+	// One of StepPending, StepDeleted, StepSkipped, or StepFailed
+	Status string
+
+	// Why the step was skipped or failed
+	Reason string
+}
+
+// setPlan builds the ordered deletion plan from the *ToDelete slices
+// so that LaunchTemplates come first, then LaunchConfigurations, then
+// AMIs, and finally Snapshots.
+func (exp *Expedition) setPlan() {
+	exp.Plan = nil
+	idx := exp.newPlanIndex()
+	add := func(resourceType string, ids []string) {
+		for _, id := range ids {
+			exp.Plan = append(exp.Plan, &PlanStep{
+				ResourceType:  resourceType,
+				ResourceId:    id,
+				Justification: exp.justify(idx, resourceType, id),
+				Status:        StepPending,
+			})
+		}
+	}
+	add(ResourceLaunchTemplate, exp.LtsToDelete)
+	add(ResourceLaunchConfiguration, exp.LcsToDelete)
+	add(ResourceAMI, exp.AmiToDelete)
+	add(ResourceSnapshot, exp.SnapToDelete)
+}
+
+// justify explains why the resource was added to the deletion plan. For
+// snapshots this is the analysis result itself and for AMIs and Launch
+// Templates/Configs it is the list of snapshots they are blocking.
+func (exp *Expedition) justify(idx *planIndex, resourceType, id string) string {
+	if resourceType == ResourceSnapshot {
+		nug, ok := idx.snapshots[id]
+		if !ok {
+			return ""
+		}
+		return fmt.Sprintf(
+			"created %s before %s, volume %s no longer exists, and it is "+
+				"not used by any autoscaling group or shared to another account",
+			nug.Snap.StartTime.Format("2006-01-02"), exp.dateFilter,
+			*nug.Snap.VolumeId,
+		)
+	}
+	blocked := idx.blocked[resourceKey(resourceType, id)]
+	if len(blocked) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(
+		"blocks deletion of %s and is not used by any autoscaling group",
+		strings.Join(dedupeString(blocked), ", "),
+	)
+}
+
+// Apply executes the deletion plan built by Start. Since things can
+// change between the analysis and the deletion, every step is
+// re-validated immediately before its delete call and is skipped with
+// a recorded reason if the resource is now in use. Failed deletions do
+// not stop the run; the outcome of every step is recorded in Plan and
+// an error is returned if any step failed.
+//
+// Every step outcome is appended to the ApplyJournal file. If a previous
+// run was interrupted Apply resumes from that journal, never re-attempting
+// a deletion the journal records as completed. If Start was not called
+// in this process the plan itself is rebuilt from the journal.
+func (exp *Expedition) Apply() (err error) {
+	return exp.ApplyWithContext(context.Background())
+}
+
+// ApplyWithContext is Apply with a context that can stop it. The step
+// in progress when the context is done is recorded as failed and the
+// remaining steps are left pending for a later run to resume.
+func (exp *Expedition) ApplyWithContext(ctx context.Context) (err error) {
+	// without Start or LoadState the account is only known to the
+	// journal, which is checked against the account of the session
+	if exp.account == "" {
+		err = exp.getAccountNumber(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	known, err := exp.resumeFromJournal()
+	if err != nil {
+		return err
+	}
+	if len(exp.Plan) == 0 {
+		return errors.New("no deletion plan found, call Start before Apply")
+	}
+	journal, err := exp.openJournal()
+	if err != nil {
+		return err
+	}
+	defer journal.Close()
+	// record the plan itself so a later run can resume it
+	for _, step := range exp.Plan {
+		if known[stepKey(step)] {
+			continue
+		}
+		err = exp.writeJournal(journal, step)
+		if err != nil {
+			return err
+		}
+	}
+	exp.setPhase(PhaseApply)
+	defer exp.setPhase(PhaseDone)
+	var countFailed, done, total int
+	for _, step := range exp.Plan {
+		if step.Status != StepDeleted {
+			total++
+		}
+	}
+	for _, step := range exp.Plan {
+		if step.Status == StepDeleted {
+			continue
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		exp.applyStep(ctx, step)
+		err = exp.writeJournal(journal, step)
+		if err != nil {
+			return err
+		}
+		if step.Status == StepFailed {
+			countFailed++
+		}
+		done++
+		exp.emit(exp.hooks.OnPlanStep != nil, func() {
+			exp.hooks.OnPlanStep(PlanStepEvent{Step: step, Done: done, Total: total})
+		})
+	}
+	if countFailed > 0 {
+		err = fmt.Errorf("%d of %d plan steps failed", countFailed, len(exp.Plan))
+	}
+	return err
+}
+
+// applyListings holds the launch configurations, launch templates, and
+// autoscaling groups of the account as described by the pre-flight
+// check of a single step, right before its delete call, so an ASG or
+// launch configuration/template created or re-pointed during a long
+// Apply is always seen.
+type applyListings struct {
+	idx *relationIndex
+}
+
+// describeApplyListings describes every launch configuration, launch
+// template, and autoscaling group for the pre-flight checks. Unlike the
+// analysis they are never cut short by MaxPages since a missed ASG
+// could let Apply delete a resource that is in use.
+func (exp *Expedition) describeApplyListings(ctx context.Context) (listings *applyListings, err error) {
+	lcs, err := exp.describeLaunchConfigurations(ctx, allPages)
+	if err != nil {
+		return nil, err
+	}
+	lts, err := exp.describeLaunchTemplates(ctx, allPages)
+	if err != nil {
+		return nil, err
+	}
+	asgs, err := exp.describeASGs(ctx, allPages)
+	if err != nil {
+		return nil, err
+	}
+	return &applyListings{idx: newRelationIndex(nil, lcs, lts, asgs)}, nil
+}
+
+// applyStep re-validates a single step and deletes the resource if it
+// is still safe to do so. The outcome is recorded on the step.
+func (exp *Expedition) applyStep(ctx context.Context, step *PlanStep) {
+	var reason string
+	var err error
+	switch step.ResourceType {
+	case ResourceLaunchTemplate:
+		reason, err = exp.preflightLaunchTemplate(ctx, step.ResourceId)
+	case ResourceLaunchConfiguration:
+		reason, err = exp.preflightLaunchConfiguration(ctx, step.ResourceId)
+	case ResourceAMI:
+		reason, err = exp.preflightImage(ctx, step.ResourceId)
+	case ResourceSnapshot:
+		reason, err = exp.preflightSnapshot(ctx, step.ResourceId)
+	default:
+		err = fmt.Errorf("unknown resource type %q", step.ResourceType)
+	}
+	if err != nil {
+		exp.log.Error(
+			"pre-flight check failed", "type", step.ResourceType,
+			"id", step.ResourceId, "error", err.Error(),
+		)
+		step.Status = StepFailed
+		step.Reason = err.Error()
+		return
+	}
+	if reason != "" {
+		exp.log.Warn(
+			"skipping plan step", "type", step.ResourceType,
+			"id", step.ResourceId, "reason", reason,
+		)
+		step.Status = StepSkipped
+		step.Reason = reason
+		return
+	}
+	err = exp.deleteResource(ctx, step.ResourceType, step.ResourceId)
+	if err != nil {
+		exp.log.Error(
+			"delete failed", "type", step.ResourceType,
+			"id", step.ResourceId, "error", err.Error(),
+		)
+		step.Status = StepFailed
+		step.Reason = err.Error()
+		return
+	}
+	exp.log.Info("deleted", "type", step.ResourceType, "id", step.ResourceId)
+	step.Status = StepDeleted
+	step.Reason = ""
+}
+
+// deleteResource issues the actual delete call for the given resource.
+func (exp *Expedition) deleteResource(ctx context.Context, resourceType, id string) (err error) {
+	switch resourceType {
+	case ResourceLaunchTemplate:
+		svc := ec2.New(exp.session)
+		_, err = svc.DeleteLaunchTemplateWithContext(ctx, &ec2.DeleteLaunchTemplateInput{
+			LaunchTemplateName: aws.String(id),
+		})
+	case ResourceLaunchConfiguration:
+		svc := autoscaling.New(exp.session)
+		_, err = svc.DeleteLaunchConfigurationWithContext(ctx, &autoscaling.DeleteLaunchConfigurationInput{
+			LaunchConfigurationName: aws.String(id),
+		})
+	case ResourceAMI:
+		svc := ec2.New(exp.session)
+		_, err = svc.DeregisterImageWithContext(ctx, &ec2.DeregisterImageInput{
+			ImageId: aws.String(id),
+		})
+	case ResourceSnapshot:
+		svc := ec2.New(exp.session)
+		_, err = svc.DeleteSnapshotWithContext(ctx, &ec2.DeleteSnapshotInput{
+			SnapshotId: aws.String(id),
+		})
+	default:
+		err = fmt.Errorf("unknown resource type %q", resourceType)
+	}
+	return err
+}
+
+// isNotFound returns true if err is an AWS error whose code indicates
+// that the requested resource does not exist.
+func isNotFound(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case "InvalidLaunchTemplateName.NotFoundException",
+			"InvalidLaunchTemplateId.NotFound",
+			"InvalidAMIID.NotFound",
+			"InvalidAMIID.Unavailable",
+			"InvalidSnapshot.NotFound",
+			"InvalidVolume.NotFound":
+			return true
+		}
+	}
+	return false
+}
+
+// preflightLaunchTemplate checks whether the launch template still exists,
+// has had new versions created since the analysis, or is now referenced by
+// an autoscaling group. It returns a non-empty reason if the template
+// should not be deleted.
+func (exp *Expedition) preflightLaunchTemplate(ctx context.Context, name string) (reason string, err error) {
+	svc := ec2.New(exp.session)
+	results, err := svc.DescribeLaunchTemplatesWithContext(ctx, &ec2.DescribeLaunchTemplatesInput{
+		LaunchTemplateNames: []*string{aws.String(name)},
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return "launch template no longer exists", nil
+		}
+		return reason, err
+	}
+	if len(results.LaunchTemplates) == 0 {
+		return "launch template no longer exists", nil
+	}
+	latest := *results.LaunchTemplates[0].LatestVersionNumber
+	if analyzed, ok := exp.ltVersions[name]; ok && latest != analyzed {
+		return fmt.Sprintf(
+			"launch template has new versions since analysis (was %d, now %d)",
+			analyzed, latest,
+		), nil
+	}
+	asgs, err := exp.describeASGs(ctx, allPages)
+	if err != nil {
+		return reason, err
+	}
+	ltId := aws.StringValue(results.LaunchTemplates[0].LaunchTemplateId)
+	if inASGs, asgNames := ltInASGs(name, ltId, asgs); inASGs {
+		return fmt.Sprintf("launch template is used by autoscaling groups %v", asgNames), nil
+	}
+	return reason, err
+}
+
+// preflightLaunchConfiguration checks whether the launch configuration
+// still exists or is now referenced by an autoscaling group. It returns
+// a non-empty reason if the launch configuration should not be deleted.
+func (exp *Expedition) preflightLaunchConfiguration(ctx context.Context, name string) (reason string, err error) {
+	svc := autoscaling.New(exp.session)
+	results, err := svc.DescribeLaunchConfigurationsWithContext(ctx, &autoscaling.DescribeLaunchConfigurationsInput{
+		LaunchConfigurationNames: []*string{aws.String(name)},
+	})
+	if err != nil {
+		return reason, err
+	}
+	if len(results.LaunchConfigurations) == 0 {
+		return "launch configuration no longer exists", nil
+	}
+	asgs, err := exp.describeASGs(ctx, allPages)
+	if err != nil {
+		return reason, err
+	}
+	if inASGs, asgNames := lcInASGs(name, asgs); inASGs {
+		return fmt.Sprintf("launch configuration is used by autoscaling groups %v", asgNames), nil
+	}
+	return reason, err
+}
+
+// preflightImage checks whether the AMI still exists, is now shared to
+// another account, was launched recently (see SpareLaunchedWithinDays),
+// is used by any running or stopped instances, or is referenced by a
+// launch configuration or template that is used by an autoscaling group.
+// It returns a non-empty reason if the AMI should not be deregistered.
+func (exp *Expedition) preflightImage(ctx context.Context, ami string) (reason string, err error) {
+	images, err := exp.describeImages(ctx, allPages, ami)
+	if err != nil {
+		if isNotFound(err) {
+			return "AMI no longer exists", nil
+		}
+		return reason, err
+	}
+	if len(images) == 0 {
+		return "AMI no longer exists", nil
+	}
+	shared, err := exp.imageSharedTo(ctx, ami)
+	if err != nil {
+		return reason, err
+	}
+	if len(shared) > 0 {
+		return fmt.Sprintf("AMI is shared with %v", shared), nil
+	}
+	if exp.spareLaunchedDays > 0 {
+		launched, err := exp.imageLastLaunched(ctx, ami)
+		if err != nil {
+			return reason, err
+		}
+		if exp.launchedRecently(&AMI{ImageId: ami, LastLaunchedTime: launched}) {
+			return fmt.Sprintf("AMI was launched on %s", launched.Format("2006-01-02")), nil
+		}
+	}
+	instances, err := exp.instancesUsingImage(ctx, ami)
+	if err != nil {
+		return reason, err
+	}
+	if len(instances) > 0 {
+		return fmt.Sprintf("AMI is used by instances %v", instances), nil
+	}
+	listings, err := exp.describeApplyListings(ctx)
+	if err != nil {
+		return reason, err
+	}
+	if asgNames := listings.asgsUsingSnapImage("", ami); len(asgNames) > 0 {
+		return fmt.Sprintf("AMI is used by autoscaling groups %v", asgNames), nil
+	}
+	return reason, err
+}
+
+// preflightSnapshot checks whether the snapshot still exists, whether its
+// source volume has come back, whether it is shared to another account,
+// whether it is still registered to an AMI, or whether it is referenced by
+// a launch configuration or template that is used by an autoscaling group.
+// It returns a non-empty reason if the snapshot should not be deleted.
+func (exp *Expedition) preflightSnapshot(ctx context.Context, snapshotId string) (reason string, err error) {
+	svc := ec2.New(exp.session)
+	results, err := svc.DescribeSnapshotsWithContext(ctx, &ec2.DescribeSnapshotsInput{
+		SnapshotIds: []*string{aws.String(snapshotId)},
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return "snapshot no longer exists", nil
+		}
+		return reason, err
+	}
+	if len(results.Snapshots) == 0 {
+		return "snapshot no longer exists", nil
+	}
+	snap := results.Snapshots[0]
+	if snap.VolumeId != nil {
+		exists, err := exp.volumeExists(ctx, *snap.VolumeId)
+		if err != nil {
+			return reason, err
+		}
+		if exists {
+			return fmt.Sprintf("volume %s exists", *snap.VolumeId), nil
+		}
+	}
+	shared, err := exp.snapshotSharedTo(ctx, snapshotId)
+	if err != nil {
+		return reason, err
+	}
+	if len(shared) > 0 {
+		return fmt.Sprintf("snapshot is shared with %v", shared), nil
+	}
+	images, err := svc.DescribeImagesWithContext(ctx, &ec2.DescribeImagesInput{
+		Owners: []*string{aws.String("self")},
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("block-device-mapping.snapshot-id"),
+				Values: []*string{aws.String(snapshotId)},
+			},
+		},
+	})
+	if err != nil {
+		return reason, err
+	}
+	if len(images.Images) > 0 {
+		var amis []string
+		for _, image := range images.Images {
+			amis = append(amis, *image.ImageId)
+		}
+		return fmt.Sprintf("snapshot is registered to AMIs %v", amis), nil
+	}
+	listings, err := exp.describeApplyListings(ctx)
+	if err != nil {
+		return reason, err
+	}
+	if asgNames := listings.asgsUsingSnapImage(snapshotId, ""); len(asgNames) > 0 {
+		return fmt.Sprintf("snapshot is used by autoscaling groups %v", asgNames), nil
+	}
+	return reason, err
+}
+
+// volumeExists describes a single volume and reports whether it exists.
+// Unlike the analysis phase any error other than "not found" is returned
+// since a throttled call must never be mistaken for a missing volume.
+func (exp *Expedition) volumeExists(ctx context.Context, volumeId string) (exists bool, err error) {
+	svc := ec2.New(exp.session)
+	results, err := svc.DescribeVolumesWithContext(ctx, &ec2.DescribeVolumesInput{
+		VolumeIds: []*string{aws.String(volumeId)},
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return false, nil
+		}
+		return exists, err
+	}
+	return len(results.Volumes) > 0, err
+}
+
+// instancesUsingImage returns the IDs of any non-terminated instances
+// that were launched from the given AMI.
+func (exp *Expedition) instancesUsingImage(ctx context.Context, ami string) (instanceIds []string, err error) {
+	svc := ec2.New(exp.session)
+	input := ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("image-id"),
+				Values: []*string{aws.String(ami)},
+			},
+			{
+				Name: aws.String("instance-state-name"),
+				Values: aws.StringSlice([]string{
+					"pending", "running", "shutting-down", "stopping", "stopped",
+				}),
+			},
+		},
+	}
+	err = svc.DescribeInstancesPagesWithContext(ctx, &input,
+		func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+			for _, res := range page.Reservations {
+				for _, inst := range res.Instances {
+					instanceIds = append(instanceIds, *inst.InstanceId)
+				}
+			}
+			return true
+		})
+	return instanceIds, err
+}
+
+// asgsUsingSnapImage returns the names of any ASGs whose launch
+// configuration or template references the given snapshot or image ID.
+func (listings *applyListings) asgsUsingSnapImage(snapshotId, imageId string) (asgNames []string) {
+	idx := listings.idx
+	for _, lc := range idx.lcsWithSnapImage(snapshotId, imageId) {
+		asgNames = append(asgNames, idx.lcASGs[lc]...)
+	}
+	for _, lt := range idx.ltsWithSnapImage(snapshotId, imageId) {
+		asgNames = append(asgNames, idx.ltASGs[lt]...)
+	}
+	return dedupeString(asgNames)
+}
@@ -0,0 +1,150 @@
+package dustcollector
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Association is one chain of resources through which a snapshot is
+// used, e.g. the snapshot is in AMI ami-1 which is the image of version
+// 3 of launch template web which is used by autoscaling group web-asg.
+// Links that aren't part of the chain are empty, e.g. AMI is empty if
+// a launch template has the snapshot in its own block device mappings.
+type Association struct {
+	AMI string `json:"ami,omitempty"`
+
+	// Accounts the AMI is shared to
+	SharedWith []string `json:"sharedWith,omitempty"`
+
+	LaunchConfiguration string `json:"launchConfiguration,omitempty"`
+
+	// Only the latest version of each launch template is analyzed
+	LaunchTemplate        string `json:"launchTemplate,omitempty"`
+	LaunchTemplateVersion int64  `json:"launchTemplateVersion,omitempty"`
+
+	ASG string `json:"asg,omitempty"`
+}
+
+// String renders the chain, e.g. "AMI ami-1 -> launch template web
+// version 3 -> autoscaling group web-asg".
+func (a Association) String() string {
+	var links []string
+	if a.AMI != "" {
+		link := "AMI " + a.AMI
+		if len(a.SharedWith) > 0 {
+			link += fmt.Sprintf(" (shared with %s)", strings.Join(a.SharedWith, ", "))
+		}
+		links = append(links, link)
+	}
+	if a.LaunchConfiguration != "" {
+		links = append(links, "launch configuration "+a.LaunchConfiguration)
+	}
+	if a.LaunchTemplate != "" {
+		links = append(links, fmt.Sprintf("launch template %s version %d", a.LaunchTemplate, a.LaunchTemplateVersion))
+	}
+	if a.ASG != "" {
+		links = append(links, "autoscaling group "+a.ASG)
+	}
+	return strings.Join(links, " -> ")
+}
+
+// AssociationChains returns the String of every Association of the
+// Nugget, for use in templates.
+func (nug *Nugget) AssociationChains() (chains []string) {
+	for _, a := range nug.Associations {
+		chains = append(chains, a.String())
+	}
+	return chains
+}
+
+// associate finds every AMI, launch configuration, launch template, and
+// autoscaling group that uses the snapshot of the Nugget, directly or
+// through one another, and records them along with the Association
+// chains that link them. Associations found through one AMI or launch
+// configuration/template are added to the ones found through the
+// others, so a snapshot is only unused if none of them is used. The
+// details and sharing of each AMI are looked up once and cached in amis
+// and shared.
+func (exp *Expedition) associate(
+	ctx context.Context,
+	idx *relationIndex,
+	nug *Nugget,
+	amis map[string]*AMI,
+	shared map[string][]string,
+) (err error) {
+	snapshotId := *nug.Snap.SnapshotId
+	// launch configs/templates with the snapshot in their own block
+	// device mappings
+	exp.associateLaunch(idx, nug, Association{}, idx.snapshotLCs[snapshotId], idx.snapshotLTs[snapshotId])
+	for _, image := range idx.snapshotImages[snapshotId] {
+		imageId := *image.ImageId
+		exp.log.Debug("mapping snapshot to image", "snapshot", snapshotId, "ami", imageId)
+		ami, ok := amis[imageId]
+		if !ok {
+			ami, err = exp.newAMI(ctx, image)
+			if err != nil {
+				return err
+			}
+			amis[imageId] = ami
+		}
+		// find out where the image is shared to
+		accts, ok := shared[imageId]
+		if !ok {
+			accts, err = exp.imageSharedTo(ctx, imageId)
+			if err != nil {
+				return err
+			}
+			shared[imageId] = accts
+		}
+		nug.AMIIDs = append(nug.AMIIDs, imageId)
+		nug.AMIs = append(nug.AMIs, ami)
+		nug.AMISharedWith = append(nug.AMISharedWith, accts...)
+		nug.amiTags = append(nug.amiTags, image.Tags...)
+		exp.associateLaunch(
+			idx, nug, Association{AMI: imageId, SharedWith: accts},
+			idx.imageLCs[imageId], idx.imageLTs[imageId],
+		)
+	}
+	nug.LCs = dedupeString(nug.LCs)
+	nug.LTs = dedupeString(nug.LTs)
+	nug.ASGs = dedupeString(nug.ASGs)
+	nug.AMISharedWith = dedupeString(nug.AMISharedWith)
+	return err
+}
+
+// associateLaunch adds the launch configurations and templates, the
+// ASGs that use them, and the chains from via through them to the
+// Nugget. If via is an AMI that no launch configuration or template
+// uses, the AMI alone is recorded as a chain.
+func (exp *Expedition) associateLaunch(idx *relationIndex, nug *Nugget, via Association, lcs, lts []string) {
+	if via.AMI != "" && len(lcs) == 0 && len(lts) == 0 {
+		nug.Associations = append(nug.Associations, via)
+		return
+	}
+	chain := func(link Association, asgs []string) {
+		if len(asgs) == 0 {
+			nug.Associations = append(nug.Associations, link)
+		}
+		for _, asg := range asgs {
+			link.ASG = asg
+			nug.Associations = append(nug.Associations, link)
+		}
+		nug.ASGs = append(nug.ASGs, asgs...)
+	}
+	for _, lc := range dedupeString(lcs) {
+		asgs := idx.lcASGs[lc]
+		nug.LCs = append(nug.LCs, lc)
+		link := via
+		link.LaunchConfiguration = lc
+		chain(link, asgs)
+	}
+	for _, lt := range dedupeString(lts) {
+		asgs := idx.ltASGs[lt]
+		nug.LTs = append(nug.LTs, lt)
+		link := via
+		link.LaunchTemplate = lt
+		link.LaunchTemplateVersion = exp.ltVersions[lt]
+		chain(link, asgs)
+	}
+}
@@ -0,0 +1,428 @@
+package dustcollector
+
+import (
+	"fmt"
+	"sort"
+)
+
+// DefaultLanguage is the language of the built-in message catalog used
+// when ExpeditionInput.Language is not set and for any message missing
+// from another catalog.
+const DefaultLanguage = "en"
+
+// catalogs holds the built-in message catalogs keyed by language. The
+// messages are fmt format strings used by the "t" template function.
+var catalogs = map[string]map[string]string{
+	"en": {
+		"intro": "After analyzing the account we can see that there are %d " +
+			"snapshots that can be deleted because they were created before " +
+			"%s and are not used in any AutoScaling group or AMI sharing capacity.",
+		"intro.blockers": "However, before these snapshots can be deleted " +
+			"several other resources need to be deleted first. Below you can " +
+			"find the ordered deletion plan:",
+		"intro.plan": "Below you can find the deletion plan:",
+		"warning": "Some of the snapshots we need to delete are currently " +
+			"registered as AMIs or used in Launch Templates/Configs. However " +
+			"we've detected that those AMI's and Launch Templates/Configs are " +
+			"not used in any autoscaling group. This doesn't mean they're not " +
+			"being used by someone (e.g., referenced in a cloudformation " +
+			"template). You should be safe to delete them but you should " +
+			"always check to be sure",
+		"warning.plan":  "If you feel comfortable then here's the plan:",
+		"nothing":       "After analyzing the account there are no snapshots created before %s that can be deleted.",
+		"stage.only":    "Delete the following %s:",
+		"stage.first":   "Delete the following %s first:",
+		"stage.then":    "then delete the following %s:",
+		"stage.finally": "then finally delete the following %s:",
+
+		"type.LaunchTemplate":      "LaunchTemplates",
+		"type.LaunchConfiguration": "LaunchConfigurations",
+		"type.AMI":                 "AMIs",
+		"type.Snapshot":            "Snapshots",
+
+		"listing.snapshots":            "snapshots",
+		"listing.launchTemplates":      "launch templates",
+		"listing.launchConfigurations": "launch configurations",
+		"listing.autoScalingGroups":    "autoscaling groups",
+		"listing.images":               "images",
+
+		"truncated": "WARNING: the listing of %s was cut short after %d pages " +
+			"(%d items) so these results are incomplete.",
+		"truncated.associations": "WARNING: snapshots may be used by resources " +
+			"that were not listed. Review the plan carefully before deleting anything.",
+
+		"spared.volume": "%d snapshots were spared because their EBS volume still exists",
+		"spared.inuse": "%d snapshots were spared because they were associated " +
+			"with an autoscaling group, were shared directly to another account, " +
+			"or were registered as an AMI that was shared to another account.",
+		"spared.launched": "%d snapshots were spared because an AMI using them was launched recently",
+		"spared.exempt":   "%d snapshots were spared because they are exempted by policy",
+		"savings": "Total size of eligible for deletion is %d GB. At a per " +
+			"GB-month rate of $%f there is a potential savings of $%f",
+
+		"report.title":        "Orphaned EBS snapshot report",
+		"report.scanned":      "Account %s, scanned %s, report generated %s",
+		"report.summary":      "Summary",
+		"report.created":      "Snapshots created before %s",
+		"report.eligible":     "Snapshots eligible for deletion",
+		"report.toDelete":     "Snapshots to delete",
+		"report.spared":       "Snapshots spared",
+		"report.size":         "Size eligible for deletion",
+		"report.rate":         "Per GB-month rate",
+		"report.savings":      "Potential monthly savings",
+		"report.savingsAt":    "Potential monthly savings at $%.3f per GB-month",
+		"report.totals":       "Totals",
+		"report.plan":         "Deletion plan",
+		"report.nothing":      "Nothing needs to be deleted.",
+		"report.sparedTitle":  "Spared snapshots",
+		"report.sparedGroup":  "%d snapshots spared because: %s",
+		"report.noneSpared":   "No snapshots were spared.",
+		"report.age":          "Snapshot age",
+		"report.topVolumes":   "Top volumes by cost",
+		"report.costByVolume": "Cost by volume",
+		"report.throttling":   "Throttling",
+		"report.snapshots":    "%d snapshots",
+		"report.perMonth":     "$%.2f/month",
+		"report.yes":          "yes",
+		"report.no":           "no",
+
+		"md.title":       "Orphaned EBS snapshots in account %s",
+		"md.title.owner": "Orphaned EBS snapshots in account %s owned by %s",
+		"md.intro": "%d of the %d snapshots created before %s can be deleted " +
+			"because their EBS volume no longer exists and they are not used by " +
+			"any AutoScaling group or shared to another account.",
+		"md.warning": "Some of these snapshots are registered as AMIs or used in " +
+			"Launch Templates/Configs that are not used by any AutoScaling group. " +
+			"They may still be referenced elsewhere (e.g., in a CloudFormation " +
+			"template) so check before deleting them.",
+		"md.stage": "Delete %d %s",
+
+		"age.6m":    "< 6 months",
+		"age.12m":   "6-12 months",
+		"age.2y":    "1-2 years",
+		"age.3y":    "2-3 years",
+		"age.5y":    "3-5 years",
+		"age.older": "5+ years",
+
+		"col.order":        "Order",
+		"col.type":         "Type",
+		"col.resource":     "Resource",
+		"col.status":       "Status",
+		"col.why":          "Why",
+		"col.snapshot":     "Snapshot",
+		"col.volume":       "Volume",
+		"col.created":      "Created",
+		"col.size":         "Size (GB)",
+		"col.amis":         "AMIs",
+		"col.asgs":         "AutoScaling groups",
+		"col.sharedWith":   "Shared with",
+		"col.usedThrough":  "Used through",
+		"col.snapshots":    "Snapshots",
+		"col.volumeExists": "Volume exists",
+		"col.inPlan":       "In plan",
+		"col.monthlyCost":  "Monthly cost ($)",
+		"col.apiFamily":    "API family",
+		"col.requests":     "Requests",
+		"col.throttled":    "Throttled",
+		"col.retries":      "Retries",
+		"col.waited":       "Waited (s)",
+
+		"reason.volume":   "EBS volume still exists",
+		"reason.asg":      "associated with an autoscaling group",
+		"reason.shared":   "shared to another account",
+		"reason.launched": "AMI launched recently",
+		"reason.exempt":   "exempted by policy",
+
+		"notify.scan": "Found %d new orphaned snapshots in account %s (%s). " +
+			"%d snapshots (%d GB) can now be deleted for a potential savings " +
+			"of $%.2f per month.",
+		"notify.apply": "Applied the deletion plan for account %s (%s): %d " +
+			"deleted, %d skipped, %d failed. Realized savings of $%.2f per month.",
+		"notify.apply.error": "Applying the deletion plan for account %s (%s) failed: %s.",
+		"notify.test":        "This is a test notification from dustcollector.",
+	},
+	"es": {
+		"intro": "Tras analizar la cuenta hay %d snapshots que se pueden " +
+			"eliminar porque se crearon antes del %s y no se usan en ningún " +
+			"grupo de AutoScaling ni se comparten como AMI.",
+		"intro.blockers": "Sin embargo, antes de eliminar estos snapshots hay " +
+			"que eliminar otros recursos. A continuación se muestra el plan de " +
+			"eliminación ordenado:",
+		"intro.plan": "A continuación se muestra el plan de eliminación:",
+		"warning": "Algunos de los snapshots a eliminar están registrados " +
+			"como AMIs o se usan en Launch Templates/Configs. Hemos detectado " +
+			"que esas AMIs y Launch Templates/Configs no se usan en ningún " +
+			"grupo de AutoScaling. Esto no significa que nadie las esté usando " +
+			"(p. ej., referenciadas en una plantilla de CloudFormation). " +
+			"Debería ser seguro eliminarlas, pero compruébelo siempre antes",
+		"warning.plan":  "Si está conforme, este es el plan:",
+		"nothing":       "Tras analizar la cuenta no hay snapshots creados antes del %s que se puedan eliminar.",
+		"stage.only":    "Elimine los siguientes %s:",
+		"stage.first":   "Elimine primero los siguientes %s:",
+		"stage.then":    "después elimine los siguientes %s:",
+		"stage.finally": "y por último elimine los siguientes %s:",
+
+		"type.LaunchTemplate":      "Launch Templates",
+		"type.LaunchConfiguration": "Launch Configurations",
+		"type.AMI":                 "AMIs",
+		"type.Snapshot":            "snapshots",
+
+		"listing.snapshots":            "snapshots",
+		"listing.launchTemplates":      "Launch Templates",
+		"listing.launchConfigurations": "Launch Configurations",
+		"listing.autoScalingGroups":    "grupos de AutoScaling",
+		"listing.images":               "imágenes",
+
+		"spared.volume": "Se conservaron %d snapshots porque su volumen EBS todavía existe",
+		"spared.inuse": "Se conservaron %d snapshots porque están asociados a " +
+			"un grupo de AutoScaling, se comparten directamente con otra cuenta " +
+			"o están registrados como una AMI compartida con otra cuenta.",
+		"spared.launched": "Se conservaron %d snapshots porque una AMI que los usa se lanzó recientemente",
+		"spared.exempt":   "Se conservaron %d snapshots porque están exentos por política",
+		"truncated": "AVISO: el listado de %s se interrumpió tras %d páginas " +
+			"(%d elementos), por lo que estos resultados están incompletos.",
+		"truncated.associations": "AVISO: los snapshots pueden estar en uso por " +
+			"recursos que no se listaron. Revise el plan con cuidado antes de eliminar nada.",
+		"savings": "El tamaño total que se puede eliminar es de %d GB. Con una " +
+			"tarifa de $%f por GB-mes el ahorro potencial es de $%f",
+
+		"report.title":        "Informe de snapshots de EBS huérfanos",
+		"report.scanned":      "Cuenta %s, analizada el %s, informe generado el %s",
+		"report.summary":      "Resumen",
+		"report.created":      "Snapshots creados antes del %s",
+		"report.eligible":     "Snapshots que se pueden eliminar",
+		"report.toDelete":     "Snapshots a eliminar",
+		"report.spared":       "Snapshots conservados",
+		"report.size":         "Tamaño que se puede eliminar",
+		"report.rate":         "Tarifa por GB-mes",
+		"report.savings":      "Ahorro mensual potencial",
+		"report.savingsAt":    "Ahorro mensual potencial a $%.3f por GB-mes",
+		"report.totals":       "Totales",
+		"report.plan":         "Plan de eliminación",
+		"report.nothing":      "No hay nada que eliminar.",
+		"report.sparedTitle":  "Snapshots conservados",
+		"report.sparedGroup":  "%d snapshots conservados porque: %s",
+		"report.noneSpared":   "No se conservó ningún snapshot.",
+		"report.age":          "Antigüedad de los snapshots",
+		"report.topVolumes":   "Volúmenes con mayor coste",
+		"report.costByVolume": "Coste por volumen",
+		"report.throttling":   "Limitación de peticiones",
+		"report.snapshots":    "%d snapshots",
+		"report.perMonth":     "$%.2f/mes",
+		"report.yes":          "sí",
+		"report.no":           "no",
+
+		"md.title":       "Snapshots de EBS huérfanos en la cuenta %s",
+		"md.title.owner": "Snapshots de EBS huérfanos en la cuenta %s de %s",
+		"md.intro": "Se pueden eliminar %d de los %d snapshots creados antes del %s " +
+			"porque su volumen EBS ya no existe y no se usan en ningún grupo de " +
+			"AutoScaling ni se comparten con otra cuenta.",
+		"md.warning": "Algunos de estos snapshots están registrados como AMIs o se usan en " +
+			"Launch Templates/Configs que no usa ningún grupo de AutoScaling. " +
+			"Puede que se referencien en otro sitio (p. ej., en una plantilla de " +
+			"CloudFormation), así que compruébelo antes de eliminarlos.",
+		"md.stage": "Eliminar %d %s",
+
+		"age.6m":    "< 6 meses",
+		"age.12m":   "6-12 meses",
+		"age.2y":    "1-2 años",
+		"age.3y":    "2-3 años",
+		"age.5y":    "3-5 años",
+		"age.older": "5+ años",
+
+		"col.order":        "Orden",
+		"col.type":         "Tipo",
+		"col.resource":     "Recurso",
+		"col.status":       "Estado",
+		"col.why":          "Motivo",
+		"col.snapshot":     "Snapshot",
+		"col.volume":       "Volumen",
+		"col.created":      "Creado",
+		"col.size":         "Tamaño (GB)",
+		"col.amis":         "AMIs",
+		"col.asgs":         "Grupos de AutoScaling",
+		"col.sharedWith":   "Compartido con",
+		"col.usedThrough":  "Usado a través de",
+		"col.snapshots":    "Snapshots",
+		"col.volumeExists": "El volumen existe",
+		"col.inPlan":       "En el plan",
+		"col.monthlyCost":  "Coste mensual ($)",
+		"col.apiFamily":    "Familia de API",
+		"col.requests":     "Peticiones",
+		"col.throttled":    "Limitadas",
+		"col.retries":      "Reintentos",
+		"col.waited":       "Espera (s)",
+
+		"reason.volume":   "el volumen EBS todavía existe",
+		"reason.asg":      "asociado a un grupo de AutoScaling",
+		"reason.shared":   "compartido con otra cuenta",
+		"reason.launched": "AMI lanzada recientemente",
+		"reason.exempt":   "exento por política",
+
+		"notify.scan": "Se encontraron %d snapshots huérfanos nuevos en la " +
+			"cuenta %s (%s). Se pueden eliminar %d snapshots (%d GB) con un " +
+			"ahorro potencial de $%.2f al mes.",
+		"notify.apply": "Se aplicó el plan de eliminación de la cuenta %s (%s): " +
+			"%d eliminados, %d omitidos, %d fallidos. Ahorro conseguido de $%.2f al mes.",
+		"notify.apply.error": "Falló la aplicación del plan de eliminación de la cuenta %s (%s): %s.",
+		"notify.test":        "Esta es una notificación de prueba de dustcollector.",
+	},
+	"fr": {
+		"intro": "Après analyse du compte, %d snapshots peuvent être supprimés " +
+			"car ils ont été créés avant le %s et ne sont utilisés par aucun " +
+			"groupe AutoScaling ni partagés en tant qu'AMI.",
+		"intro.blockers": "Cependant, d'autres ressources doivent être " +
+			"supprimées avant ces snapshots. Voici le plan de suppression " +
+			"ordonné :",
+		"intro.plan": "Voici le plan de suppression :",
+		"warning": "Certains des snapshots à supprimer sont enregistrés en " +
+			"tant qu'AMI ou utilisés dans des Launch Templates/Configs. Nous " +
+			"avons détecté que ces AMI et Launch Templates/Configs ne sont " +
+			"utilisés par aucun groupe AutoScaling. Cela ne signifie pas que " +
+			"personne ne les utilise (par ex. référencés dans un modèle " +
+			"CloudFormation). Leur suppression devrait être sans risque mais " +
+			"vérifiez toujours avant",
+		"warning.plan":  "Si cela vous convient, voici le plan :",
+		"nothing":       "Après analyse du compte, aucun snapshot créé avant le %s ne peut être supprimé.",
+		"stage.only":    "Supprimez les %s suivants :",
+		"stage.first":   "Supprimez d'abord les %s suivants :",
+		"stage.then":    "puis supprimez les %s suivants :",
+		"stage.finally": "et enfin supprimez les %s suivants :",
+
+		"type.LaunchTemplate":      "Launch Templates",
+		"type.LaunchConfiguration": "Launch Configurations",
+		"type.AMI":                 "AMI",
+		"type.Snapshot":            "snapshots",
+
+		"listing.snapshots":            "snapshots",
+		"listing.launchTemplates":      "Launch Templates",
+		"listing.launchConfigurations": "Launch Configurations",
+		"listing.autoScalingGroups":    "groupes AutoScaling",
+		"listing.images":               "images",
+
+		"spared.volume": "%d snapshots ont été conservés car leur volume EBS existe toujours",
+		"spared.inuse": "%d snapshots ont été conservés car ils sont associés " +
+			"à un groupe AutoScaling, partagés directement avec un autre compte " +
+			"ou enregistrés en tant qu'AMI partagée avec un autre compte.",
+		"spared.launched": "%d snapshots ont été conservés car une AMI qui les utilise a été lancée récemment",
+		"spared.exempt":   "%d snapshots ont été conservés car ils sont exemptés par la politique",
+		"truncated": "ATTENTION : la liste des %s a été interrompue après %d " +
+			"pages (%d éléments), ces résultats sont donc incomplets.",
+		"truncated.associations": "ATTENTION : des snapshots peuvent être utilisés " +
+			"par des ressources non listées. Vérifiez le plan avant de supprimer quoi que ce soit.",
+		"savings": "La taille totale pouvant être supprimée est de %d Go. Au " +
+			"tarif de $%f par Go-mois, l'économie potentielle est de $%f",
+
+		"report.title":        "Rapport des snapshots EBS orphelins",
+		"report.scanned":      "Compte %s, analysé le %s, rapport généré le %s",
+		"report.summary":      "Résumé",
+		"report.created":      "Snapshots créés avant le %s",
+		"report.eligible":     "Snapshots pouvant être supprimés",
+		"report.toDelete":     "Snapshots à supprimer",
+		"report.spared":       "Snapshots conservés",
+		"report.size":         "Taille pouvant être supprimée",
+		"report.rate":         "Tarif par Go-mois",
+		"report.savings":      "Économie mensuelle potentielle",
+		"report.savingsAt":    "Économie mensuelle potentielle à $%.3f par Go-mois",
+		"report.totals":       "Totaux",
+		"report.plan":         "Plan de suppression",
+		"report.nothing":      "Rien à supprimer.",
+		"report.sparedTitle":  "Snapshots conservés",
+		"report.sparedGroup":  "%d snapshots conservés car : %s",
+		"report.noneSpared":   "Aucun snapshot n'a été conservé.",
+		"report.age":          "Âge des snapshots",
+		"report.topVolumes":   "Volumes les plus coûteux",
+		"report.costByVolume": "Coût par volume",
+		"report.throttling":   "Limitation des requêtes",
+		"report.snapshots":    "%d snapshots",
+		"report.perMonth":     "$%.2f/mois",
+		"report.yes":          "oui",
+		"report.no":           "non",
+
+		"md.title":       "Snapshots EBS orphelins du compte %s",
+		"md.title.owner": "Snapshots EBS orphelins du compte %s appartenant à %s",
+		"md.intro": "%d des %d snapshots créés avant le %s peuvent être supprimés car " +
+			"leur volume EBS n'existe plus et ils ne sont utilisés par aucun " +
+			"groupe AutoScaling ni partagés avec un autre compte.",
+		"md.warning": "Certains de ces snapshots sont enregistrés en tant qu'AMI ou utilisés " +
+			"dans des Launch Templates/Configs qu'aucun groupe AutoScaling n'utilise. " +
+			"Ils peuvent encore être référencés ailleurs (par ex. dans un modèle " +
+			"CloudFormation), vérifiez donc avant de les supprimer.",
+		"md.stage": "Supprimer %d %s",
+
+		"age.6m":    "< 6 mois",
+		"age.12m":   "6-12 mois",
+		"age.2y":    "1-2 ans",
+		"age.3y":    "2-3 ans",
+		"age.5y":    "3-5 ans",
+		"age.older": "5 ans et plus",
+
+		"col.order":        "Ordre",
+		"col.type":         "Type",
+		"col.resource":     "Ressource",
+		"col.status":       "Statut",
+		"col.why":          "Motif",
+		"col.snapshot":     "Snapshot",
+		"col.volume":       "Volume",
+		"col.created":      "Créé le",
+		"col.size":         "Taille (Go)",
+		"col.amis":         "AMI",
+		"col.asgs":         "Groupes AutoScaling",
+		"col.sharedWith":   "Partagé avec",
+		"col.usedThrough":  "Utilisé via",
+		"col.snapshots":    "Snapshots",
+		"col.volumeExists": "Le volume existe",
+		"col.inPlan":       "Dans le plan",
+		"col.monthlyCost":  "Coût mensuel ($)",
+		"col.apiFamily":    "Famille d'API",
+		"col.requests":     "Requêtes",
+		"col.throttled":    "Limitées",
+		"col.retries":      "Nouvelles tentatives",
+		"col.waited":       "Attente (s)",
+
+		"reason.volume":   "le volume EBS existe toujours",
+		"reason.asg":      "associé à un groupe AutoScaling",
+		"reason.shared":   "partagé avec un autre compte",
+		"reason.launched": "AMI lancée récemment",
+		"reason.exempt":   "exempté par la politique",
+
+		"notify.scan": "%d nouveaux snapshots orphelins trouvés dans le compte " +
+			"%s (%s). %d snapshots (%d Go) peuvent être supprimés pour une " +
+			"économie potentielle de $%.2f par mois.",
+		"notify.apply": "Plan de suppression appliqué pour le compte %s (%s) : " +
+			"%d supprimés, %d ignorés, %d en échec. Économie réalisée de $%.2f par mois.",
+		"notify.apply.error": "L'application du plan de suppression pour le compte %s (%s) a échoué : %s.",
+		"notify.test":        "Ceci est une notification de test de dustcollector.",
+	},
+}
+
+// Languages returns the languages that have a built-in message catalog.
+func Languages() (langs []string) {
+	for lang := range catalogs {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// message looks up the message with the given key first in the
+// Messages provided in the ExpeditionInput, then in the catalog for
+// the Expedition language, and finally in the DefaultLanguage catalog.
+// The message is formatted with args. Unknown keys are returned as is
+// so they are easy to spot in a custom template.
+func (exp *Expedition) message(key string, args ...interface{}) string {
+	format, ok := exp.messages[key]
+	if !ok {
+		format, ok = catalogs[exp.language][key]
+	}
+	if !ok {
+		format, ok = catalogs[DefaultLanguage][key]
+	}
+	if !ok {
+		return key
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
@@ -0,0 +1,123 @@
+package dustcollector
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// TestCatalogKeys makes sure every built-in catalog translates the same
+// messages as the DefaultLanguage catalog.
+func TestCatalogKeys(t *testing.T) {
+	for _, lang := range Languages() {
+		var missing, extra []string
+		for key := range catalogs[DefaultLanguage] {
+			if _, ok := catalogs[lang][key]; !ok {
+				missing = append(missing, key)
+			}
+		}
+		for key := range catalogs[lang] {
+			if _, ok := catalogs[DefaultLanguage][key]; !ok {
+				extra = append(extra, key)
+			}
+		}
+		sort.Strings(missing)
+		sort.Strings(extra)
+		if len(missing) > 0 {
+			t.Errorf("%q catalog is missing %s", lang, strings.Join(missing, ", "))
+		}
+		if len(extra) > 0 {
+			t.Errorf("%q catalog has keys not in %q: %s", lang, DefaultLanguage, strings.Join(extra, ", "))
+		}
+	}
+}
+
+// usedKey matches the literal message keys passed to the "t" template
+// function and to Expedition.message.
+var usedKey = regexp.MustCompile(`(?:\bt |message\()"([a-z][A-Za-z.]*[A-Za-z])"`)
+
+// TestCatalogCoversTemplates makes sure every message used by the
+// templates and the code, including the keys built from resource
+// types, listings, spare reasons, and age buckets, is in the
+// DefaultLanguage catalog and therefore in every catalog.
+func TestCatalogCoversTemplates(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range usedKey.FindAllStringSubmatch(string(src), -1) {
+			keys = append(keys, m[1])
+		}
+	}
+	if len(keys) < 50 {
+		t.Fatalf("only found %d message keys in the source", len(keys))
+	}
+	for _, resourceType := range []string{ResourceLaunchTemplate, ResourceLaunchConfiguration, ResourceAMI, ResourceSnapshot} {
+		keys = append(keys, "type."+resourceType)
+	}
+	for _, listing := range []string{ListingSnapshots, ListingLaunchTemplates, ListingLaunchConfigurations, ListingAutoScalingGroups, ListingImages} {
+		keys = append(keys, "listing."+listing)
+	}
+	for _, reason := range spareReasons {
+		key, ok := spareReasonKeys[reason]
+		if !ok {
+			t.Errorf("spare reason %q has no message", reason)
+		}
+		keys = append(keys, key)
+	}
+	for _, bucket := range ageBuckets {
+		keys = append(keys, bucket.label)
+	}
+	for _, key := range dedupeString(keys) {
+		if _, ok := catalogs[DefaultLanguage][key]; !ok {
+			t.Errorf("message %q is used but not in the %q catalog", key, DefaultLanguage)
+		}
+	}
+}
+
+// TestReportsTranslated renders the Markdown and HTML reports in French
+// and looks for English left in them.
+func TestReportsTranslated(t *testing.T) {
+	f := &fakeAWS{snaps: 40, pageSize: 100}
+	exp := newFakeExpedition(t, f, func(in *ExpeditionInput) {
+		lang := "fr"
+		in.Language = &lang
+	})
+	if err := exp.Start(); err != nil {
+		t.Fatal(err)
+	}
+	var markdown, html strings.Builder
+	if err := exp.WriteMarkdown(&markdown); err != nil {
+		t.Fatal(err)
+	}
+	if err := exp.WriteHTML(&html); err != nil {
+		t.Fatal(err)
+	}
+	for name, report := range map[string]string{"Markdown": markdown.String(), "HTML": html.String()} {
+		for _, fr := range []string{"Plan de suppression", "Snapshots conservés", "le volume EBS existe toujours"} {
+			if !strings.Contains(report, fr) {
+				t.Errorf("%s report doesn't contain %q:\n%s", name, fr, report)
+			}
+		}
+		for _, en := range []string{"Deletion plan", "Spared snapshots", "spared because", "Orphaned", "Snapshots to delete", "Size (GB)", "Why"} {
+			if strings.Contains(report, en) {
+				t.Errorf("%s report contains %q:\n%s", name, en, report)
+			}
+		}
+	}
+	if !strings.Contains(html.String(), `<html lang="fr">`) {
+		t.Error(`HTML report isn't marked lang="fr"`)
+	}
+}
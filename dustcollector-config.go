@@ -0,0 +1,507 @@
+package dustcollector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/inconshreveable/log15"
+)
+
+// Config is a declarative description of the Expeditions to run, e.g.
+// loaded from a file with LoadConfig, so the policy can be changed
+// without recompiling. An Expedition is run for every combination of
+// Accounts and Regions; see Targets. A Config in JSON looks like:
+//
+//	{
+//	  "accounts": [{"name": "prod", "profile": "prod"}],
+//	  "regions": ["us-east-1", "eu-west-1"],
+//	  "retention": {"minAgeDays": 180},
+//	  "exemptions": {"tags": {"Keep": ""}},
+//	  "pricing": {"ebsSnapRate": 0.05},
+//	  "outputs": {"directory": "reports"},
+//	  "notifiers": {"webhooks": [{"url": "https://hooks.slack.com/...", "format": "slack"}]}
+//	}
+type Config struct {
+	// Accounts to scan. Default: one account using the default
+	// credentials
+	Accounts []ConfigAccount `json:"accounts"`
+
+	// Regions to scan in every account. Default: the region of the
+	// AWS config
+	Regions []string `json:"regions"`
+
+	Filters    ConfigFilters    `json:"filters"`
+	Retention  ConfigRetention  `json:"retention"`
+	Exemptions ConfigExemptions `json:"exemptions"`
+	Pricing    ConfigPricing    `json:"pricing"`
+	Limits     ConfigLimits     `json:"limits"`
+	Owners     ConfigOwners     `json:"owners"`
+	Outputs    ConfigOutputs    `json:"outputs"`
+	Notifiers  ConfigNotifiers  `json:"notifiers"`
+
+	// directory of the config file, relative file names in the
+	// config are resolved against it
+	dir string
+}
+
+// ConfigAccount is an AWS account to scan and the credentials to use.
+type ConfigAccount struct {
+	// Used in output paths and logs. Default: the profile, or
+	// "default"
+	Name string `json:"name"`
+
+	// Shared config profile. Default: the default credentials
+	Profile string `json:"profile"`
+
+	// Role assumed with the profile's credentials, e.g. to scan
+	// another account of the organization
+	RoleArn    string `json:"roleArn"`
+	ExternalId string `json:"externalId"`
+}
+
+// ConfigFilters limit which snapshots are analyzed.
+type ConfigFilters struct {
+	// Snapshots created on or after this date ("YYYY-MM-DD") are
+	// ignored. Can't be combined with Retention.MinAgeDays.
+	CreatedBefore string `json:"createdBefore"`
+
+	// Optional caps on the pages fetched from each listing and on
+	// the snapshots fetched, see ExpeditionInput.MaxPages and
+	// MaxSnapshots. Zero reads every listing to the end.
+	MaxPages        int `json:"maxPages"`
+	MaxSnapshots    int `json:"maxSnapshots"`
+	PageSize        int `json:"pageSize"`
+	VolumeBatchSize int `json:"volumeBatchSize"`
+	VolumeWorkers   int `json:"volumeWorkers"`
+}
+
+// ConfigRetention keeps recent snapshots out of the analysis.
+type ConfigRetention struct {
+	// Snapshots younger than this many days are ignored. The date
+	// filter is computed when Targets is called.
+	MinAgeDays int `json:"minAgeDays"`
+
+	// Snapshots of AMIs launched within this many days are kept,
+	// see ExpeditionInput.SpareLaunchedWithinDays.
+	LaunchedWithinDays int `json:"launchedWithinDays"`
+}
+
+// ConfigExemptions keep snapshots out of the deletion plan, see
+// ExpeditionInput.ExemptSnapshots and ExemptTags.
+type ConfigExemptions struct {
+	SnapshotIds []string          `json:"snapshotIds"`
+	Tags        map[string]string `json:"tags"`
+}
+
+// ConfigPricing sets the rates used for the savings.
+type ConfigPricing struct {
+	EbsSnapRate float64 `json:"ebsSnapRate"`
+}
+
+// ConfigLimits sets how fast AWS is called, see
+// ExpeditionInput.RateLimits and MaxRetries.
+type ConfigLimits struct {
+	RateLimits map[string]float64 `json:"rateLimits"`
+	MaxRetries int                `json:"maxRetries"`
+}
+
+// ConfigOwners sets how snapshots are attributed to owners. When either
+// field is set the TagKeys are looked up on the snapshot, its volume,
+// and its AMIs, followed by the Pattern on the description.
+type ConfigOwners struct {
+	TagKeys []string `json:"tagKeys"`
+	Pattern string   `json:"pattern"`
+}
+
+// ConfigOutputs sets where and how the reports are written.
+type ConfigOutputs struct {
+	// Directory for the reports. When more than one account or
+	// region is scanned each gets a <account>/<region> subdirectory.
+	// Default: the current directory
+	Directory string `json:"directory"`
+
+	Language string            `json:"language"`
+	Messages map[string]string `json:"messages"`
+
+	// Files holding templates for the Markdown summary and the
+	// recommendations
+	MarkdownTemplate        string `json:"markdownTemplate"`
+	RecommendationsTemplate string `json:"recommendationsTemplate"`
+
+	// Terraform state files for the IaC report
+	TerraformState []string `json:"terraformState"`
+}
+
+// ConfigNotifiers configures the Notifier, see NotifierInput.
+type ConfigNotifiers struct {
+	Webhooks            []ConfigWebhook `json:"webhooks"`
+	NewOrphansThreshold int             `json:"newOrphansThreshold"`
+	MaxAttempts         int             `json:"maxAttempts"`
+
+	// A duration such as "1s" or "500ms"
+	RetryBackoff string `json:"retryBackoff"`
+}
+
+// ConfigWebhook is a Webhook. Template is a file name.
+type ConfigWebhook struct {
+	Name     string   `json:"name"`
+	URL      string   `json:"url"`
+	Format   string   `json:"format"`
+	Events   []string `json:"events"`
+	Template string   `json:"template"`
+}
+
+// ConfigTarget is one account and region of a Config along with the
+// ExpeditionInput to scan it.
+type ConfigTarget struct {
+	Account string
+	Region  string
+	Input   *ExpeditionInput
+}
+
+// LoadConfig reads a config file and validates it. Files ending in
+// .yaml or .yml are read as YAML and files ending in .toml as TOML,
+// using the same field names as JSON; anything else is read as JSON.
+// Relative file names in the config are resolved against the directory
+// of the file.
+func LoadConfig(filename string) (c *Config, err error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		data, err = yamlToJSON(data)
+	case ".toml":
+		data, err = tomlToJSON(data)
+	}
+	if err == nil {
+		c, err = ParseConfig(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	c.dir = filepath.Dir(filename)
+	return c, nil
+}
+
+// ParseConfig parses and validates a JSON config. Unknown fields are
+// rejected so typos don't silently fall back to defaults.
+func ParseConfig(data []byte) (c *Config, err error) {
+	c = &Config{}
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(c)
+	if err != nil {
+		return nil, err
+	}
+	return c, c.Validate()
+}
+
+// ConfigError lists every problem found by Config.Validate.
+type ConfigError []string
+
+func (e ConfigError) Error() string {
+	return "invalid config: " + strings.Join(e, "; ")
+}
+
+// Validate checks the Config, returning a ConfigError listing all the
+// problems found.
+func (c *Config) Validate() (err error) {
+	var errs ConfigError
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, args...))
+	}
+	names := make(map[string]bool)
+	for i, account := range c.Accounts {
+		name := account.name()
+		if names[name] {
+			add("accounts[%d]: duplicate name %q", i, name)
+		}
+		names[name] = true
+		if account.ExternalId != "" && account.RoleArn == "" {
+			add("accounts[%d]: externalId requires roleArn", i)
+		}
+	}
+	regions := make(map[string]bool)
+	for i, region := range c.Regions {
+		if region == "" || regions[region] {
+			add("regions[%d]: empty or duplicate region %q", i, region)
+		}
+		regions[region] = true
+	}
+	f := c.Filters
+	if f.CreatedBefore != "" {
+		if _, err := time.Parse("2006-01-02", f.CreatedBefore); err != nil {
+			add("filters.createdBefore: %q is not a YYYY-MM-DD date", f.CreatedBefore)
+		}
+		if c.Retention.MinAgeDays != 0 {
+			add("filters.createdBefore and retention.minAgeDays can't both be set")
+		}
+	}
+	for _, v := range []struct {
+		name  string
+		value int
+	}{
+		{"filters.maxPages", f.MaxPages},
+		{"filters.maxSnapshots", f.MaxSnapshots},
+		{"filters.pageSize", f.PageSize},
+		{"filters.volumeBatchSize", f.VolumeBatchSize},
+		{"filters.volumeWorkers", f.VolumeWorkers},
+		{"retention.minAgeDays", c.Retention.MinAgeDays},
+		{"retention.launchedWithinDays", c.Retention.LaunchedWithinDays},
+		{"notifiers.newOrphansThreshold", c.Notifiers.NewOrphansThreshold},
+		{"notifiers.maxAttempts", c.Notifiers.MaxAttempts},
+		{"limits.maxRetries", c.Limits.MaxRetries},
+	} {
+		if v.value < 0 {
+			add("%s: must not be negative", v.name)
+		}
+	}
+	if c.Pricing.EbsSnapRate < 0 {
+		add("pricing.ebsSnapRate: must not be negative")
+	}
+	for family, rate := range c.Limits.RateLimits {
+		if rate < 0 || !strings.Contains(family, ":") {
+			add("limits.rateLimits: invalid rate %v for family %q", rate, family)
+		}
+	}
+	for i, id := range c.Exemptions.SnapshotIds {
+		if !strings.HasPrefix(id, "snap-") {
+			add("exemptions.snapshotIds[%d]: %q is not a snapshot ID", i, id)
+		}
+	}
+	if c.Owners.Pattern != "" {
+		if _, err := regexp.Compile(c.Owners.Pattern); err != nil {
+			add("owners.pattern: %s", err)
+		}
+	}
+	if lang := c.Outputs.Language; lang != "" {
+		if _, ok := catalogs[lang]; !ok {
+			add("outputs.language: %q is not one of %s", lang, strings.Join(Languages(), ", "))
+		}
+	}
+	if c.Notifiers.RetryBackoff != "" {
+		if _, err := time.ParseDuration(c.Notifiers.RetryBackoff); err != nil {
+			add("notifiers.retryBackoff: %s", err)
+		}
+	}
+	for i, hook := range c.Notifiers.Webhooks {
+		if hook.URL == "" {
+			add("notifiers.webhooks[%d]: url is required", i)
+		}
+		switch hook.Format {
+		case "", WebhookSlack, WebhookTeams, WebhookGeneric:
+		default:
+			add("notifiers.webhooks[%d]: unknown format %q", i, hook.Format)
+		}
+		for _, event := range hook.Events {
+			switch event {
+			case EventScanFindings, EventApplyFinished, EventTest:
+			default:
+				add("notifiers.webhooks[%d]: unknown event %q", i, event)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (account ConfigAccount) name() string {
+	switch {
+	case account.Name != "":
+		return account.Name
+	case account.Profile != "":
+		return account.Profile
+	}
+	return "default"
+}
+
+// path resolves a file name in the config against its directory.
+func (c *Config) path(name string) string {
+	if name == "" || filepath.IsAbs(name) || c.dir == "" {
+		return name
+	}
+	return filepath.Join(c.dir, name)
+}
+
+// readFile returns the contents of a file named in the config.
+func (c *Config) readFile(name string) (text string, err error) {
+	data, err := ioutil.ReadFile(c.path(name))
+	return string(data), err
+}
+
+// Targets returns an ExpeditionInput for every account and region of
+// the Config, with a session for the account's credentials and region
+// and the given logger.
+func (c *Config) Targets(logger *log15.Logger) (targets []*ConfigTarget, err error) {
+	accounts := c.Accounts
+	if len(accounts) == 0 {
+		accounts = []ConfigAccount{{}}
+	}
+	regions := c.Regions
+	if len(regions) == 0 {
+		regions = []string{""}
+	}
+	base, err := c.baseInput()
+	if err != nil {
+		return nil, err
+	}
+	for _, account := range accounts {
+		for _, region := range regions {
+			sess, err := account.session(region)
+			if err != nil {
+				return nil, fmt.Errorf("account %s: %s", account.name(), err)
+			}
+			input := *base
+			input.Session = sess
+			input.Logger = logger
+			dir := c.path(c.Outputs.Directory)
+			if len(accounts) > 1 || len(regions) > 1 {
+				dir = filepath.Join(dir, account.name(), aws.StringValue(sess.Config.Region))
+			}
+			if dir != "" {
+				input.OutputDirectory = aws.String(dir)
+			}
+			targets = append(targets, &ConfigTarget{
+				Account: account.name(),
+				Region:  aws.StringValue(sess.Config.Region),
+				Input:   &input,
+			})
+		}
+	}
+	return targets, nil
+}
+
+// session returns a session for the account in the region, assuming
+// the account's role if there is one.
+func (account ConfigAccount) session(region string) (sess *session.Session, err error) {
+	opts := session.Options{
+		Profile:           account.Profile,
+		SharedConfigState: session.SharedConfigEnable,
+	}
+	if region != "" {
+		opts.Config.Region = aws.String(region)
+	}
+	sess, err = session.NewSessionWithOptions(opts)
+	if err != nil || account.RoleArn == "" {
+		return sess, err
+	}
+	creds := stscreds.NewCredentials(sess, account.RoleArn, func(p *stscreds.AssumeRoleProvider) {
+		if account.ExternalId != "" {
+			p.ExternalID = aws.String(account.ExternalId)
+		}
+	})
+	return sess.Copy(&aws.Config{Credentials: creds}), nil
+}
+
+// baseInput returns the ExpeditionInput shared by every target.
+func (c *Config) baseInput() (input *ExpeditionInput, err error) {
+	input = &ExpeditionInput{}
+	optInt := func(v int) *int {
+		if v == 0 {
+			return nil
+		}
+		return aws.Int(v)
+	}
+	input.MaxPages = optInt(c.Filters.MaxPages)
+	input.MaxSnapshots = optInt(c.Filters.MaxSnapshots)
+	input.PageSize = optInt(c.Filters.PageSize)
+	input.VolumeBatchSize = optInt(c.Filters.VolumeBatchSize)
+	input.VolumeWorkers = optInt(c.Filters.VolumeWorkers)
+	if c.Filters.CreatedBefore != "" {
+		input.DateFilter = aws.String(c.Filters.CreatedBefore)
+	}
+	if c.Retention.MinAgeDays > 0 {
+		before := time.Now().UTC().AddDate(0, 0, -c.Retention.MinAgeDays)
+		input.DateFilter = aws.String(before.Format("2006-01-02"))
+	}
+	input.SpareLaunchedWithinDays = optInt(c.Retention.LaunchedWithinDays)
+	input.ExemptSnapshots = c.Exemptions.SnapshotIds
+	input.ExemptTags = c.Exemptions.Tags
+	if c.Pricing.EbsSnapRate > 0 {
+		input.EbsSnapRate = aws.Float64(c.Pricing.EbsSnapRate)
+	}
+	input.RateLimits = c.Limits.RateLimits
+	input.MaxRetries = optInt(c.Limits.MaxRetries)
+	if len(c.Owners.TagKeys) > 0 || c.Owners.Pattern != "" {
+		keys := c.Owners.TagKeys
+		if len(keys) == 0 {
+			keys = DefaultOwnerTagKeys
+		}
+		input.OwnerRules = TagOwnerRules(keys, c.Owners.Pattern)
+	}
+	if c.Outputs.Language != "" {
+		input.Language = aws.String(c.Outputs.Language)
+	}
+	input.Messages = c.Outputs.Messages
+	for _, tmpl := range []struct {
+		file   string
+		target **string
+	}{
+		{c.Outputs.MarkdownTemplate, &input.MarkdownTemplate},
+		{c.Outputs.RecommendationsTemplate, &input.RecommendationsTemplate},
+	} {
+		if tmpl.file == "" {
+			continue
+		}
+		text, err := c.readFile(tmpl.file)
+		if err != nil {
+			return nil, err
+		}
+		*tmpl.target = &text
+	}
+	for _, name := range c.Outputs.TerraformState {
+		input.TerraformStateFiles = append(input.TerraformStateFiles, c.path(name))
+	}
+	return input, nil
+}
+
+// NotifierInput returns the NotifierInput for the Notifiers of the
+// Config, or nil if there are no webhooks.
+func (c *Config) NotifierInput(logger *log15.Logger) (input *NotifierInput, err error) {
+	n := c.Notifiers
+	if len(n.Webhooks) == 0 {
+		return nil, nil
+	}
+	input = &NotifierInput{Logger: logger}
+	for _, hook := range n.Webhooks {
+		webhook := Webhook{
+			Name:   hook.Name,
+			URL:    hook.URL,
+			Format: hook.Format,
+			Events: hook.Events,
+		}
+		if hook.Template != "" {
+			webhook.Template, err = c.readFile(hook.Template)
+			if err != nil {
+				return nil, err
+			}
+		}
+		input.Webhooks = append(input.Webhooks, webhook)
+	}
+	if n.NewOrphansThreshold > 0 {
+		input.NewOrphansThreshold = aws.Int(n.NewOrphansThreshold)
+	}
+	if n.MaxAttempts > 0 {
+		input.MaxAttempts = aws.Int(n.MaxAttempts)
+	}
+	if n.RetryBackoff != "" {
+		backoff, err := time.ParseDuration(n.RetryBackoff)
+		if err != nil {
+			return nil, err
+		}
+		input.RetryBackoff = &backoff
+	}
+	return input, nil
+}
@@ -0,0 +1,209 @@
+package dustcollector
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const configJSON = `{
+  "accounts": [
+    {"name": "prod", "profile": "prod"},
+    {"name": "audit", "roleArn": "arn:aws:iam::123456789012:role/audit", "externalId": "x#1"}
+  ],
+  "regions": ["us-east-1", "eu-west-1"],
+  "filters": {"createdBefore": "2019-01-01", "pageSize": 500},
+  "retention": {"launchedWithinDays": 30},
+  "exemptions": {"snapshotIds": ["snap-1"], "tags": {"Keep": "", "aws:backup": "yes"}},
+  "pricing": {"ebsSnapRate": 0.05},
+  "limits": {"rateLimits": {"ec2:DescribeSnapshots": 2.5}, "maxRetries": 3},
+  "owners": {"tagKeys": ["Owner", "team"], "pattern": "owner: (\\w+)"},
+  "outputs": {"directory": "reports", "language": "fr", "messages": {}},
+  "notifiers": {
+    "webhooks": [
+      {"name": "ops", "url": "https://hooks.slack.com/a?b=c", "format": "slack"},
+      {"url": "https://example.com/hook", "format": "generic", "template": "hook.tmpl"}
+    ],
+    "maxAttempts": 2,
+    "retryBackoff": "500ms"
+  }
+}`
+
+const configYAML = `# the same config as configJSON
+---
+accounts:
+  - name: prod
+    profile: prod
+  - name: audit
+    roleArn: "arn:aws:iam::123456789012:role/audit"
+    externalId: 'x#1'
+regions: [us-east-1, eu-west-1]
+filters:
+  createdBefore: 2019-01-01
+  pageSize: 500   # per page
+retention:
+  launchedWithinDays: 30
+exemptions:
+  snapshotIds:
+  - snap-1
+  tags: {Keep: "", "aws:backup": yes}
+pricing:
+  ebsSnapRate: 0.05
+limits:
+  rateLimits:
+    "ec2:DescribeSnapshots": 2.5
+  maxRetries: 3
+owners:
+  tagKeys: [Owner, team]
+  pattern: "owner: (\\w+)"
+outputs:
+  directory: reports
+  language: fr
+  messages: {}
+notifiers:
+  webhooks:
+    - name: ops
+      url: https://hooks.slack.com/a?b=c
+      format: slack
+    -
+      url: https://example.com/hook
+      format: generic
+      template: hook.tmpl
+  maxAttempts: 2
+  retryBackoff: 500ms
+`
+
+const configTOML = `# the same config as configJSON
+regions = ["us-east-1", "eu-west-1"]
+
+[[accounts]]
+name = "prod"
+profile = "prod"
+
+[[accounts]]
+name = "audit"
+roleArn = "arn:aws:iam::123456789012:role/audit"
+externalId = 'x#1' # not a comment inside quotes
+
+[filters]
+createdBefore = "2019-01-01"
+pageSize = 5_00
+
+[retention]
+launchedWithinDays = 30
+
+[exemptions]
+snapshotIds = [
+  "snap-1", # trailing comma
+]
+tags = { Keep = "", "aws:backup" = "yes" }
+
+[pricing]
+ebsSnapRate = 0.05
+
+[limits]
+rateLimits."ec2:DescribeSnapshots" = 2.5
+maxRetries = 3
+
+[owners]
+tagKeys = ["Owner", "team"]
+pattern = "owner: (\\w+)"
+
+[outputs]
+directory = "reports"
+language = "fr"
+messages = {}
+
+[notifiers]
+maxAttempts = 2
+retryBackoff = "500ms"
+
+[[notifiers.webhooks]]
+name = "ops"
+url = "https://hooks.slack.com/a?b=c"
+format = "slack"
+
+[[notifiers.webhooks]]
+url = "https://example.com/hook"
+format = "generic"
+template = "hook.tmpl"
+`
+
+// loadTestConfig writes a config file to a temporary directory and
+// loads it.
+func loadTestConfig(t *testing.T, name, content string) (*Config, error) {
+	dir, err := ioutil.TempDir("", "dustcollector-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	filename := filepath.Join(dir, name)
+	err = ioutil.WriteFile(filename, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := LoadConfig(filename)
+	if c != nil {
+		c.dir = ""
+	}
+	return c, err
+}
+
+// TestConfigFormats loads the same config written in JSON, YAML, and
+// TOML.
+func TestConfigFormats(t *testing.T) {
+	want, err := loadTestConfig(t, "config.json", configJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want.Accounts[1].ExternalId != "x#1" || want.Exemptions.Tags["aws:backup"] != "yes" {
+		t.Fatalf("unexpected JSON config %+v", want)
+	}
+	for name, content := range map[string]string{
+		"config.yaml": configYAML,
+		"config.YML":  configYAML,
+		"config.toml": configTOML,
+	} {
+		c, err := loadTestConfig(t, name, content)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if !reflect.DeepEqual(c, want) {
+			t.Errorf("%s: got %+v, want %+v", name, c, want)
+		}
+	}
+}
+
+// TestConfigFormatErrors makes sure unsupported syntax, unknown fields,
+// and invalid values are reported for YAML and TOML too.
+func TestConfigFormatErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name, content, err string
+	}{
+		{"unknown.yaml", "regions: [us-east-1]\nregion: us-east-1\n", `unknown field "region"`},
+		{"invalid.yaml", "retention:\n  minAgeDays: -1\n", "retention.minAgeDays: must not be negative"},
+		{"type.yaml", "regions: us-east-1\n", "cannot unmarshal string"},
+		{"anchor.yaml", "regions: &r [us-east-1]\n", "line 1: unsupported YAML syntax"},
+		{"block.yaml", "owners:\n  pattern: |\n    x\n", "line 2: unsupported YAML syntax"},
+		{"indent.yaml", "pricing:\n  ebsSnapRate: 1\n    x: 2\n", "line 3: unexpected indentation"},
+		{"duplicate.yaml", "regions: []\nregions: []\n", `line 2: duplicate key "regions"`},
+		{"flow.yaml", "regions: [us-east-1\n", "line 1: unterminated flow collection"},
+		{"documents.yaml", "regions: []\n---\nregions: []\n", "line 2: only one YAML document"},
+		{"unknown.toml", "[retention]\nminAge = 1\n", `unknown field "minAge"`},
+		{"invalid.toml", "[filters]\ncreatedBefore = \"yesterday\"\n", "is not a YYYY-MM-DD date"},
+		{"duplicate.toml", "[pricing]\n[pricing]\n", "line 2: table pricing is defined twice"},
+		{"key.toml", "regions = []\nregions = []\n", "line 2: duplicate key regions"},
+		{"string.toml", "[owners]\npattern = \"x\n", "line 2: unterminated string"},
+		{"multiline.toml", "[owners]\npattern = \"\"\"x\"\"\"\n", "line 2: multi-line strings are not supported"},
+		{"value.toml", "[pricing]\nebsSnapRate = cheap\n", `line 2: invalid value "cheap"`},
+		{"trailing.toml", "[pricing] x\n", `line 1: unexpected "x"`},
+	} {
+		_, err := loadTestConfig(t, tc.name, tc.content)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: got error %v, want %q", tc.name, err, tc.err)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package dustcollector
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// Changes recorded in a PlanChange
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+)
+
+// PlanChange is a resource that entered or left the deletion plan
+// between two Expeditions.
+type PlanChange struct {
+	Change       string `json:"change"`
+	ResourceType string `json:"resourceType"`
+	ResourceId   string `json:"resourceId"`
+
+	// For removed snapshots, why the snapshot is no longer in the
+	// plan (e.g., its Nugget.SpareReason or that it no longer exists)
+	Note string `json:"note,omitempty"`
+}
+
+// PlanDiff compares the results of two Expeditions of the same account,
+// e.g. last week's saved state against today's scan.
+type PlanDiff struct {
+	Changes []PlanChange `json:"changes"`
+
+	// Snapshots that are in the plan now but were not before
+	NewSnapshots []string `json:"newSnapshots"`
+
+	// Snapshots that were in the plan before but are not now
+	ResolvedSnapshots []string `json:"resolvedSnapshots"`
+
+	SnapshotsInScopeDelta int     `json:"snapshotsInScopeDelta"`
+	TotalGbsDelta         int64   `json:"totalGbsDelta"`
+	SavingsDelta          float64 `json:"savingsDelta"`
+}
+
+// Diff compares the deletion plan of the Expedition with that of a
+// previous Expedition. If previous is nil every step is new.
+func (exp *Expedition) Diff(previous *Expedition) *PlanDiff {
+	var d PlanDiff
+	current := exp.GetSummary()
+	before := &Summary{}
+	var prevPlan []*PlanStep
+	if previous != nil {
+		before = previous.GetSummary()
+		prevPlan = previous.Plan
+	}
+	d.SnapshotsInScopeDelta = current.SnapshotCount - before.SnapshotCount
+	d.TotalGbsDelta = current.TotalGbs - before.TotalGbs
+	d.SavingsDelta = current.Savings - before.Savings
+
+	inPrev := make(map[string]bool)
+	for _, step := range prevPlan {
+		inPrev[stepKey(step)] = true
+	}
+	inCurrent := make(map[string]bool)
+	for _, step := range exp.Plan {
+		inCurrent[stepKey(step)] = true
+		if inPrev[stepKey(step)] {
+			continue
+		}
+		d.Changes = append(d.Changes, PlanChange{
+			Change:       ChangeAdded,
+			ResourceType: step.ResourceType,
+			ResourceId:   step.ResourceId,
+		})
+		if step.ResourceType == ResourceSnapshot {
+			d.NewSnapshots = append(d.NewSnapshots, step.ResourceId)
+		}
+	}
+	nuggets := make(map[string]*Nugget)
+	for _, nug := range exp.Nuggets {
+		nuggets[*nug.Snap.SnapshotId] = nug
+	}
+	for _, step := range prevPlan {
+		if inCurrent[stepKey(step)] {
+			continue
+		}
+		change := PlanChange{
+			Change:       ChangeRemoved,
+			ResourceType: step.ResourceType,
+			ResourceId:   step.ResourceId,
+		}
+		if step.ResourceType == ResourceSnapshot {
+			d.ResolvedSnapshots = append(d.ResolvedSnapshots, step.ResourceId)
+			if nug, ok := nuggets[step.ResourceId]; ok {
+				if nug.SpareReason != "" {
+					change.Note = "spared: " + nug.SpareReason
+				}
+			} else {
+				change.Note = "no longer exists"
+			}
+		}
+		d.Changes = append(d.Changes, change)
+	}
+	sortChanges(d.Changes)
+	return &d
+}
+
+// sortChanges orders the changes by deletion order keeping additions
+// before removals within each resource type.
+func sortChanges(changes []PlanChange) {
+	sort.SliceStable(changes, func(i, j int) bool {
+		return planOrder(changes[i].ResourceType) < planOrder(changes[j].ResourceType)
+	})
+}
+
+// WriteDiff renders the PlanDiff as text.
+func (d *PlanDiff) WriteDiff(w io.Writer) (err error) {
+	fmt.Fprintf(
+		w, "%d new snapshots in the plan, %d resolved\n",
+		len(d.NewSnapshots), len(d.ResolvedSnapshots),
+	)
+	fmt.Fprintf(
+		w, "snapshots in scope %+d, size eligible for deletion %+d GB, potential monthly savings %+.2f\n",
+		d.SnapshotsInScopeDelta, d.TotalGbsDelta, d.SavingsDelta,
+	)
+	if len(d.Changes) == 0 {
+		fmt.Fprintln(w, "the deletion plan is unchanged")
+		return nil
+	}
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, c := range d.Changes {
+		sign := "+"
+		if c.Change == ChangeRemoved {
+			sign = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", sign, c.ResourceType, c.ResourceId, c.Note)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,106 @@
+package dustcollector
+
+import (
+	"time"
+)
+
+// Phases of an Expedition reported to Hooks.OnPhaseChange
+const (
+	PhaseAccount      = "account"
+	PhaseSnapshots    = "snapshots"
+	PhaseAssociations = "associations"
+	PhasePlan         = "plan"
+	PhaseApply        = "apply"
+	PhaseDone         = "done"
+)
+
+// PhaseEvent is passed to Hooks.OnPhaseChange when the Expedition moves
+// to another phase.
+type PhaseEvent struct {
+	Phase    string
+	Previous string
+	Time     time.Time
+}
+
+// PageEvent is passed to Hooks.OnPageFetched for every page of
+// snapshots.
+type PageEvent struct {
+	Page int
+
+	// Snapshots on the page and how many of them were created
+	// before the date filter
+	Snapshots int
+	InScope   int
+
+	// Snapshots on all the pages fetched so far
+	TotalSnapshots int
+	TotalInScope   int
+
+	// Volume batches queued for the page
+	Batches int
+}
+
+// VolumeBatchEvent is passed to Hooks.OnVolumeBatchDone when a batch of
+// volumes has been described.
+type VolumeBatchEvent struct {
+	Page int
+
+	// Volumes in the batch and how many of them still exist
+	Volumes int
+	Found   int
+
+	// Set if describing the batch failed
+	Err error
+
+	// Batches done and queued so far across all pages
+	BatchesDone   int
+	BatchesQueued int
+}
+
+// PlanStepEvent is passed to Hooks.OnPlanStep after Apply attempts a
+// step of the plan.
+type PlanStepEvent struct {
+	Step *PlanStep
+
+	// Steps attempted so far in this Apply and the steps left to
+	// attempt when Apply started
+	Done  int
+	Total int
+}
+
+// Hooks are called as an Expedition makes progress so that callers can
+// show progress bars or stream status to their clients. Any hook may be
+// nil. Hooks are called from several goroutines but never at the same
+// time, and the Expedition waits for each hook to return so hooks
+// should be quick.
+type Hooks struct {
+	OnPhaseChange     func(e PhaseEvent)
+	OnPageFetched     func(e PageEvent)
+	OnVolumeBatchDone func(e VolumeBatchEvent)
+
+	// Called for every Nugget once its volume has been checked, in
+	// the order of exp.Nuggets
+	OnNuggetBuilt func(nug *Nugget)
+
+	OnPlanStep func(e PlanStepEvent)
+}
+
+// emit calls a hook while holding the hook lock. call is only run if
+// the hook is set, so building the event costs nothing otherwise.
+func (exp *Expedition) emit(isSet bool, call func()) {
+	if !isSet {
+		return
+	}
+	exp.hookMu.Lock()
+	defer exp.hookMu.Unlock()
+	call()
+}
+
+// setPhase moves the Expedition to phase and calls OnPhaseChange.
+func (exp *Expedition) setPhase(phase string) {
+	previous := exp.phase
+	exp.phase = phase
+	exp.emit(exp.hooks.OnPhaseChange != nil, func() {
+		exp.hooks.OnPhaseChange(PhaseEvent{Phase: phase, Previous: previous, Time: time.Now()})
+	})
+}
@@ -0,0 +1,237 @@
+package dustcollector
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Node kinds used in the dependency graph in addition to the plan
+// resource types.
+const (
+	nodeVolume  = "Volume"
+	nodeASG     = "AutoScalingGroup"
+	nodeAccount = "Account"
+)
+
+// Plan actions used to color the nodes of the dependency graph.
+const (
+	actionDelete = "delete"
+	actionSpare  = "spare"
+	actionKeep   = "keep"
+)
+
+// actionColors maps each plan action to the fill color of its nodes.
+var actionColors = map[string]string{
+	actionDelete: "#f4cccc",
+	actionSpare:  "#fff2cc",
+	actionKeep:   "#d9ead3",
+}
+
+type graphNode struct {
+	key    string
+	kind   string
+	name   string
+	action string
+}
+
+type graphEdge struct {
+	from  *graphNode
+	to    *graphNode
+	label string
+}
+
+// depGraph is the snapshot -> AMI -> LT/LC -> ASG dependency graph built
+// from the Nuggets of an Expedition.
+type depGraph struct {
+	nodes []*graphNode
+	index map[string]*graphNode
+	edges []graphEdge
+	seen  map[string]bool
+}
+
+// node returns the node for the given resource, adding it to the graph
+// if it does not exist yet.
+func (g *depGraph) node(kind, name, action string) *graphNode {
+	key := resourceKey(kind, name)
+	if n, ok := g.index[key]; ok {
+		return n
+	}
+	n := &graphNode{key: key, kind: kind, name: name, action: action}
+	g.index[key] = n
+	g.nodes = append(g.nodes, n)
+	return n
+}
+
+// edge adds a labeled edge between two nodes unless it already exists.
+func (g *depGraph) edge(from, to *graphNode, label string) {
+	key := from.key + "->" + to.key
+	if g.seen[key] {
+		return
+	}
+	g.seen[key] = true
+	g.edges = append(g.edges, graphEdge{from: from, to: to, label: label})
+}
+
+// buildGraph builds the dependency graph from the Nuggets. Resources in
+// the deletion plan are marked delete, snapshots that were left out of
+// the plan are marked spare, and everything else is marked keep.
+func (exp *Expedition) buildGraph() *depGraph {
+	g := depGraph{
+		index: make(map[string]*graphNode),
+		seen:  make(map[string]bool),
+	}
+	inPlan := make(map[string]bool)
+	for _, step := range exp.Plan {
+		inPlan[stepKey(step)] = true
+	}
+	action := func(kind, name string) string {
+		if inPlan[resourceKey(kind, name)] {
+			return actionDelete
+		}
+		return actionKeep
+	}
+	for _, nug := range exp.Nuggets {
+		snapId := *nug.Snap.SnapshotId
+		snapAction := action(ResourceSnapshot, snapId)
+		if nug.SpareReason != "" {
+			snapAction = actionSpare
+		}
+		snap := g.node(ResourceSnapshot, snapId, snapAction)
+		if nug.HasVol {
+			vol := g.node(nodeVolume, *nug.Snap.VolumeId, actionKeep)
+			g.edge(vol, snap, "snapshotted as")
+		}
+		// every Association is one chain from the snapshot, through
+		// an AMI or directly as a block device mapping, to the launch
+		// config/template and the ASG using it
+		for _, a := range nug.Associations {
+			parent := snap
+			if a.AMI != "" {
+				parent = g.node(ResourceAMI, a.AMI, action(ResourceAMI, a.AMI))
+				g.edge(snap, parent, "registered as")
+				for _, acct := range a.SharedWith {
+					g.edge(parent, g.node(nodeAccount, acct, actionKeep), "shared with")
+				}
+			}
+			switch {
+			case a.LaunchConfiguration != "":
+				n := g.node(ResourceLaunchConfiguration, a.LaunchConfiguration, action(ResourceLaunchConfiguration, a.LaunchConfiguration))
+				g.edge(parent, n, "referenced by")
+				parent = n
+			case a.LaunchTemplate != "":
+				n := g.node(ResourceLaunchTemplate, a.LaunchTemplate, action(ResourceLaunchTemplate, a.LaunchTemplate))
+				g.edge(parent, n, "referenced by")
+				parent = n
+			}
+			if a.ASG != "" {
+				g.edge(parent, g.node(nodeASG, a.ASG, actionKeep), "used by")
+			}
+		}
+	}
+	return &g
+}
+
+// dotQuote quotes s for use as a DOT ID or label.
+func dotQuote(s string) string {
+	return `"` + strings.Replace(strings.Replace(s, `\`, `\\`, -1), `"`, `\"`, -1) + `"`
+}
+
+// dotLabel quotes the lines as a single multi-line DOT label.
+func dotLabel(lines ...string) string {
+	var quoted []string
+	for _, line := range lines {
+		q := dotQuote(line)
+		quoted = append(quoted, q[1:len(q)-1])
+	}
+	return `"` + strings.Join(quoted, `\n`) + `"`
+}
+
+// mermaidQuote makes s safe to use inside a quoted mermaid label.
+func mermaidQuote(s string) string {
+	r := strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;")
+	return r.Replace(s)
+}
+
+// WriteGraphDot writes the dependency graph between snapshots, volumes,
+// AMIs, Launch Templates/Configs, and AutoScaling groups to w in Graphviz
+// DOT format. Nodes are colored by their plan action: delete, spare, or
+// keep.
+func (exp *Expedition) WriteGraphDot(w io.Writer) (err error) {
+	g := exp.buildGraph()
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph dustcollector {")
+	fmt.Fprintln(bw, "  rankdir=LR;")
+	fmt.Fprintln(bw, "  node [shape=box, style=filled];")
+	for _, n := range g.nodes {
+		fmt.Fprintf(
+			bw, "  %s [label=%s, fillcolor=%s, tooltip=%s];\n",
+			dotQuote(n.key), dotLabel(n.kind, n.name),
+			dotQuote(actionColors[n.action]), dotQuote(n.action),
+		)
+	}
+	for _, e := range g.edges {
+		fmt.Fprintf(
+			bw, "  %s -> %s [label=%s];\n",
+			dotQuote(e.from.key), dotQuote(e.to.key), dotQuote(e.label),
+		)
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// WriteGraphMermaid writes the same dependency graph as WriteGraphDot to w
+// as a Mermaid flowchart.
+func (exp *Expedition) WriteGraphMermaid(w io.Writer) (err error) {
+	g := exp.buildGraph()
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "flowchart LR")
+	ids := make(map[*graphNode]string)
+	for i, n := range g.nodes {
+		ids[n] = fmt.Sprintf("n%d", i)
+		fmt.Fprintf(
+			bw, "  %s[\"%s<br/>%s\"]:::%s\n",
+			ids[n], n.kind, mermaidQuote(n.name), n.action,
+		)
+	}
+	for _, e := range g.edges {
+		fmt.Fprintf(bw, "  %s -->|%s| %s\n", ids[e.from], e.label, ids[e.to])
+	}
+	for _, action := range []string{actionDelete, actionSpare, actionKeep} {
+		fmt.Fprintf(bw, "  classDef %s fill:%s\n", action, actionColors[action])
+	}
+	return bw.Flush()
+}
+
+// ExportGraphDot writes the dependency graph to outfile in DOT format.
+func (exp *Expedition) ExportGraphDot() (err error) {
+	file, err := os.Create(exp.outfileGraphDot)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	err = exp.WriteGraphDot(file)
+	if err != nil {
+		return err
+	}
+	exp.log.Info("wrote dot graph to file", "filename", exp.outfileGraphDot)
+	return err
+}
+
+// ExportGraphMermaid writes the dependency graph to outfile in Mermaid
+// format.
+func (exp *Expedition) ExportGraphMermaid() (err error) {
+	file, err := os.Create(exp.outfileGraphMermaid)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	err = exp.WriteGraphMermaid(file)
+	if err != nil {
+		return err
+	}
+	exp.log.Info("wrote mermaid graph to file", "filename", exp.outfileGraphMermaid)
+	return err
+}
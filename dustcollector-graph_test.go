@@ -0,0 +1,65 @@
+package dustcollector
+
+import (
+	"bytes"
+	"sort"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// TestGraphFollowsAssociations makes sure the graph only has the edges
+// of the recorded chains: each AMI points at its own launch template
+// and a launch configuration mapping the snapshot directly hangs off
+// the snapshot, not the AMIs.
+func TestGraphFollowsAssociations(t *testing.T) {
+	exp := &Expedition{
+		Nuggets: []*Nugget{{
+			Snap:   &ec2.Snapshot{SnapshotId: aws.String("snap-1"), VolumeId: aws.String("vol-1")},
+			AMIIDs: []string{"ami-1", "ami-2"},
+			LCs:    []string{"lc-direct"},
+			LTs:    []string{"lt-1", "lt-2"},
+			Associations: []Association{
+				{LaunchConfiguration: "lc-direct"},
+				{AMI: "ami-1", SharedWith: []string{"999988887777"}, LaunchTemplate: "lt-1", LaunchTemplateVersion: 3, ASG: "asg-1"},
+				{AMI: "ami-2", LaunchTemplate: "lt-2", LaunchTemplateVersion: 1},
+			},
+		}},
+		Plan: []*PlanStep{{ResourceType: ResourceLaunchTemplate, ResourceId: "lt-2"}},
+	}
+	g := exp.buildGraph()
+	var edges []string
+	for _, e := range g.edges {
+		edges = append(edges, e.from.key+" -"+e.label+"-> "+e.to.key)
+	}
+	sort.Strings(edges)
+	want := []string{
+		"AMI/ami-1 -referenced by-> LaunchTemplate/lt-1",
+		"AMI/ami-1 -shared with-> Account/999988887777",
+		"AMI/ami-2 -referenced by-> LaunchTemplate/lt-2",
+		"LaunchTemplate/lt-1 -used by-> AutoScalingGroup/asg-1",
+		"Snapshot/snap-1 -referenced by-> LaunchConfiguration/lc-direct",
+		"Snapshot/snap-1 -registered as-> AMI/ami-1",
+		"Snapshot/snap-1 -registered as-> AMI/ami-2",
+	}
+	if len(edges) != len(want) {
+		t.Fatalf("got edges %q, want %q", edges, want)
+	}
+	for i := range want {
+		if edges[i] != want[i] {
+			t.Fatalf("got edges %q, want %q", edges, want)
+		}
+	}
+	if n := g.index[resourceKey(ResourceLaunchTemplate, "lt-2")]; n.action != actionDelete {
+		t.Errorf("lt-2 has action %s, want %s", n.action, actionDelete)
+	}
+	var dot bytes.Buffer
+	err := exp.WriteGraphDot(&dot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(dot.Bytes(), []byte(`"AMI/ami-1" -> "LaunchTemplate/lt-2"`)) {
+		t.Errorf("DOT has a cross edge:\n%s", dot.String())
+	}
+}
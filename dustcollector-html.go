@@ -0,0 +1,334 @@
+package dustcollector
+
+import (
+	"html/template"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// htmlTemplate is the self-contained HTML report. All styles, scripts,
+// and charts are inlined so the report can be emailed or attached to a
+// ticket as a single file.
+const htmlTemplate = `<!DOCTYPE html>
+<html lang="{{.Language}}">
+<head>
+<meta charset="utf-8">
+<title>{{t "report.title"}} {{.Account}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #222; }
+h1, h2, h3 { font-weight: 600; }
+table { border-collapse: collapse; margin-bottom: 1.5em; font-size: 0.9em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; vertical-align: top; }
+th { background: #eee; }
+table.sortable th { cursor: pointer; }
+table.sortable th:after { content: " \2195"; color: #999; }
+td.num { text-align: right; }
+.summary td:first-child { font-weight: 600; }
+.chart text { font-size: 12px; }
+.muted { color: #777; }
+.warning { border: 1px solid #d9a400; background: #fff8e1; padding: 0.5em 1em; }
+</style>
+</head>
+<body>
+<h1>{{t "report.title"}}</h1>
+<p class="muted">{{t "report.scanned" .Account .ScanTime .Generated}}</p>
+{{if .Truncations}}
+<div class="warning">
+{{range .Truncations}}<p>{{t "truncated" (t (print "listing." .Listing)) .Pages .Items}}</p>
+{{end}}{{if .AssociationsTruncated}}<p><strong>{{t "truncated.associations"}}</strong></p>
+{{end}}</div>
+{{end}}
+<h2>{{t "report.summary"}}</h2>
+<table class="summary">
+<tr><td>{{t "report.created" .DateFilter}}</td><td class="num">{{.SnapCount}}</td></tr>
+<tr><td>{{t "report.eligible"}}</td><td class="num">{{.DeleteCount}}</td></tr>
+<tr><td>{{t "report.spared"}}</td><td class="num">{{.SparedCount}}</td></tr>
+<tr><td>{{t "report.size"}}</td><td class="num">{{.TotalGbs}} GB</td></tr>
+<tr><td>{{t "report.rate"}}</td><td class="num">${{printf "%.4f" .Rate}}</td></tr>
+<tr><td>{{t "report.savings"}}</td><td class="num">${{printf "%.2f" .Savings}}</td></tr>
+</table>
+
+<h2>{{t "report.plan"}}</h2>
+{{if .Plan}}
+<table class="sortable">
+<thead><tr><th>{{t "col.order"}}</th><th>{{t "col.type"}}</th><th>{{t "col.resource"}}</th><th>{{t "col.status"}}</th><th>{{t "col.why"}}</th></tr></thead>
+<tbody>
+{{range $i, $step := .Plan}}<tr><td class="num">{{inc $i}}</td><td>{{$step.ResourceType}}</td><td>{{$step.ResourceId}}</td><td>{{$step.Status}}{{if $step.Reason}}: {{$step.Reason}}{{end}}</td><td>{{$step.Justification}}</td></tr>
+{{end}}</tbody>
+</table>
+{{else}}
+<p>{{t "report.nothing"}}</p>
+{{end}}
+
+<h2>{{t "report.sparedTitle"}}</h2>
+{{range .Spared}}
+<h3>{{t "report.sparedGroup" (len .Nuggets) (reason .Reason)}}</h3>
+<table class="sortable">
+<thead><tr><th>{{t "col.snapshot"}}</th><th>{{t "col.volume"}}</th><th>{{t "col.created"}}</th><th>{{t "col.size"}}</th><th>{{t "col.amis"}}</th><th>{{t "col.asgs"}}</th><th>{{t "col.sharedWith"}}</th></tr></thead>
+<tbody>
+{{range .Nuggets}}<tr><td>{{.Snap.SnapshotId}}</td><td>{{.Snap.VolumeId}}</td><td>{{date .Snap.StartTime}}</td><td class="num">{{.Snap.VolumeSize}}</td><td>{{join .AMIIDs}}</td><td>{{join .ASGs}}</td><td>{{join .AMISharedWith}}</td></tr>
+{{end}}</tbody>
+</table>
+{{else}}
+<p>{{t "report.noneSpared"}}</p>
+{{end}}
+
+<h2>{{t "report.age"}}</h2>
+{{template "chart" .AgeChart}}
+
+<h2>{{t "report.topVolumes"}}</h2>
+{{if .TopVolumes.Bars}}{{template "chart" .TopVolumes}}{{else}}<p>{{t "report.nothing"}}</p>{{end}}
+
+<h2>{{t "report.costByVolume"}}</h2>
+<table class="sortable">
+<thead><tr><th>{{t "col.volume"}}</th><th>{{t "col.snapshots"}}</th><th>{{t "col.size"}}</th><th>{{t "col.volumeExists"}}</th><th>{{t "col.inPlan"}}</th><th>{{t "col.monthlyCost"}}</th></tr></thead>
+<tbody>
+{{range .Bars}}<tr><td>{{.VolumeId}}</td><td class="num">{{.Snapshots}}</td><td class="num">{{.SizeGb}}</td><td>{{if .HasVol}}{{t "report.yes"}}{{else}}{{t "report.no"}}{{end}}</td><td>{{if .InPlan}}{{t "report.yes"}}{{else}}{{t "report.no"}}{{end}}</td><td class="num">{{printf "%.2f" .MonthlyCost}}</td></tr>
+{{end}}</tbody>
+</table>
+
+<script>
+(function () {
+  function value(row, i) {
+    var text = row.cells[i].textContent.trim();
+    var num = parseFloat(text.replace(/[$,]/g, ""));
+    return isNaN(num) || !/^[$\d.,-]+$/.test(text) ? text.toLowerCase() : num;
+  }
+  document.querySelectorAll("table.sortable").forEach(function (table) {
+    table.querySelectorAll("th").forEach(function (th, i) {
+      var asc = true;
+      th.addEventListener("click", function () {
+        var body = table.tBodies[0];
+        var rows = Array.prototype.slice.call(body.rows);
+        rows.sort(function (a, b) {
+          var x = value(a, i), y = value(b, i);
+          return (x < y ? -1 : x > y ? 1 : 0) * (asc ? 1 : -1);
+        });
+        rows.forEach(function (row) { body.appendChild(row); });
+        asc = !asc;
+      });
+    });
+  });
+})();
+</script>
+</body>
+</html>
+{{define "chart"}}<svg class="chart" width="{{.Width}}" height="{{.Height}}" role="img" aria-label="{{.Title}}">
+{{range .Bars}}<text x="0" y="{{.TextY}}">{{.Label}}</text><rect x="{{$.LabelWidth}}" y="{{.Y}}" width="{{.Width}}" height="18" fill="#6d9eeb"><title>{{.Display}}</title></rect><text x="{{.ValueX}}" y="{{.TextY}}">{{.Display}}</text>
+{{end}}</svg>{{end}}
+`
+
+// chartBar is one bar of a horizontal bar chart in the HTML report.
+type chartBar struct {
+	Label   string
+	Value   float64
+	Display string
+	Y       int
+	TextY   int
+	Width   int
+	ValueX  int
+}
+
+// htmlChart is a horizontal bar chart rendered as inline SVG.
+type htmlChart struct {
+	Title      string
+	Width      int
+	Height     int
+	LabelWidth int
+	Bars       []*chartBar
+}
+
+// newHTMLChart lays out the bars so the longest one spans the chart.
+func newHTMLChart(title string, bars []*chartBar) *htmlChart {
+	c := htmlChart{
+		Title:      title,
+		Width:      700,
+		LabelWidth: 200,
+		Bars:       bars,
+	}
+	maxWidth := 400
+	var max float64
+	for _, b := range bars {
+		if b.Value > max {
+			max = b.Value
+		}
+	}
+	for i, b := range bars {
+		b.Y = i * 24
+		b.TextY = b.Y + 14
+		if max > 0 {
+			b.Width = int(b.Value / max * float64(maxWidth))
+		}
+		b.ValueX = c.LabelWidth + b.Width + 6
+	}
+	c.Height = len(bars) * 24
+	return &c
+}
+
+type htmlBarRow struct {
+	VolumeId    string
+	Snapshots   int
+	SizeGb      int64
+	HasVol      bool
+	InPlan      bool
+	MonthlyCost float64
+}
+
+// htmlReport holds everything rendered by htmlTemplate.
+type htmlReport struct {
+	Language    string
+	Account     string
+	ScanTime    string
+	Generated   string
+	DateFilter  string
+	Rate        float64
+	SnapCount   int
+	DeleteCount int
+	SparedCount int
+	TotalGbs    int64
+	Savings     float64
+	Plan        []*PlanStep
+
+	// Listings cut short by MaxPages or MaxSnapshots
+	Truncations           []Truncation
+	AssociationsTruncated bool
+
+	Spared     []*SparedGroup
+	Bars       []*htmlBarRow
+	AgeChart   *htmlChart
+	TopVolumes *htmlChart
+}
+
+// ageBuckets are the snapshot age ranges shown in the age histogram
+// and the catalog keys of their labels.
+var ageBuckets = []struct {
+	label  string
+	months int
+}{
+	{"age.6m", 6},
+	{"age.12m", 12},
+	{"age.2y", 24},
+	{"age.3y", 36},
+	{"age.5y", 60},
+	{"age.older", 0},
+}
+
+// ageBucket returns the index in ageBuckets for a snapshot started at
+// start as seen at time now.
+func ageBucket(start, now time.Time) int {
+	for i, bucket := range ageBuckets {
+		if bucket.months == 0 || start.After(now.AddDate(0, -bucket.months, 0)) {
+			return i
+		}
+	}
+	return len(ageBuckets) - 1
+}
+
+// buildHTMLReport gathers the data shown in the HTML report.
+func (exp *Expedition) buildHTMLReport() *htmlReport {
+	scanTime := exp.scanTime
+	if scanTime.IsZero() {
+		scanTime = time.Now().UTC()
+	}
+	r := htmlReport{
+		Language:    exp.language,
+		Account:     exp.account,
+		ScanTime:    scanTime.Format("2006-01-02 15:04 MST"),
+		Generated:   time.Now().UTC().Format("2006-01-02 15:04 MST"),
+		DateFilter:  exp.dateFilter,
+		Rate:        exp.ebsSnapRate,
+		SnapCount:   len(exp.Nuggets),
+		DeleteCount: len(exp.SnapToDelete),
+		Plan:        exp.Plan,
+
+		Truncations:           exp.Truncations,
+		AssociationsTruncated: exp.associationsTruncated(),
+	}
+	deletable, totalGbs := exp.deletableBars()
+	r.TotalGbs = totalGbs
+	r.Savings = float64(totalGbs) * exp.ebsSnapRate
+
+	// spared snapshots grouped by reason
+	summary := exp.GetSummary()
+	r.Spared = summary.Spared
+	r.SparedCount = summary.SparedCount
+	ages := make([]*chartBar, len(ageBuckets))
+	for i, bucket := range ageBuckets {
+		ages[i] = &chartBar{Label: exp.message(bucket.label)}
+	}
+	for _, nug := range exp.Nuggets {
+		ages[ageBucket(*nug.Snap.StartTime, scanTime)].Value++
+	}
+	for _, b := range ages {
+		b.Display = exp.message("report.snapshots", int(b.Value))
+	}
+	r.AgeChart = newHTMLChart(exp.message("report.age"), ages)
+
+	// per bar cost breakdown
+	inPlan := make(map[*Bar]bool)
+	for _, bar := range deletable {
+		inPlan[bar] = true
+	}
+	for _, bar := range exp.Bars {
+		size := *bar.Nuggets[0].Snap.VolumeSize
+		r.Bars = append(r.Bars, &htmlBarRow{
+			VolumeId:    *bar.VolumeId,
+			Snapshots:   len(bar.Nuggets),
+			SizeGb:      size,
+			HasVol:      bar.HasVol,
+			InPlan:      inPlan[bar],
+			MonthlyCost: float64(size) * exp.ebsSnapRate,
+		})
+	}
+	sort.SliceStable(r.Bars, func(i, j int) bool {
+		return r.Bars[i].MonthlyCost > r.Bars[j].MonthlyCost
+	})
+
+	// top deletable volumes by cost
+	var top []*chartBar
+	for _, row := range r.Bars {
+		if !row.InPlan {
+			continue
+		}
+		top = append(top, &chartBar{
+			Label:   row.VolumeId,
+			Value:   row.MonthlyCost,
+			Display: exp.message("report.perMonth", row.MonthlyCost),
+		})
+		if len(top) == 10 {
+			break
+		}
+	}
+	r.TopVolumes = newHTMLChart(exp.message("report.topVolumes"), top)
+	return &r
+}
+
+// WriteHTML renders a self-contained HTML report of the Expedition to w
+// including the deletion plan, spared snapshots grouped by reason, a cost
+// breakdown per Bar, a snapshot age histogram, and the top volumes by cost.
+// Listings cut short by MaxPages or MaxSnapshots are warned about first.
+func (exp *Expedition) WriteHTML(w io.Writer) (err error) {
+	funcs := template.FuncMap(exp.summaryFuncs())
+	tmpl, err := template.New("report").Funcs(funcs).Parse(htmlTemplate)
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, exp.buildHTMLReport())
+}
+
+// ExportHTML writes the HTML report (see WriteHTML) to outfile.
+func (exp *Expedition) ExportHTML() (err error) {
+	file, err := os.Create(exp.outfileHTML)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	err = exp.WriteHTML(file)
+	if err != nil {
+		return err
+	}
+	exp.log.Info("wrote html report to file", "filename", exp.outfileHTML)
+	return err
+}
@@ -0,0 +1,209 @@
+package dustcollector
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// IaC tools that can manage a deletion plan item.
+const (
+	IaCTerraform      = "terraform"
+	IaCCloudFormation = "cloudformation"
+)
+
+// terraformResourceTypes maps each Terraform AWS provider resource type
+// that can manage a plan item to the plan resource type and the state
+// attribute holding the value found in PlanStep.ResourceId.
+var terraformResourceTypes = map[string]struct {
+	resourceType string
+	attribute    string
+}{
+	"aws_launch_template":      {ResourceLaunchTemplate, "name"},
+	"aws_launch_configuration": {ResourceLaunchConfiguration, "name"},
+	"aws_ami":                  {ResourceAMI, "id"},
+	"aws_ami_copy":             {ResourceAMI, "id"},
+	"aws_ami_from_instance":    {ResourceAMI, "id"},
+	"aws_ebs_snapshot":         {ResourceSnapshot, "id"},
+	"aws_ebs_snapshot_copy":    {ResourceSnapshot, "id"},
+	"aws_ebs_snapshot_import":  {ResourceSnapshot, "id"},
+}
+
+// terraformState is the subset of the Terraform state file format
+// (version 4) needed to find managed resources.
+type terraformState struct {
+	Version   int `json:"version"`
+	Resources []struct {
+		Module    string `json:"module"`
+		Mode      string `json:"mode"`
+		Type      string `json:"type"`
+		Name      string `json:"name"`
+		Instances []struct {
+			IndexKey   interface{}            `json:"index_key"`
+			Attributes map[string]interface{} `json:"attributes"`
+		} `json:"instances"`
+	} `json:"resources"`
+}
+
+// IaCItem maps a single deletion plan step to the infrastructure as
+// code that manages it, if any.
+type IaCItem struct {
+	Step *PlanStep
+
+	// IaCTerraform, IaCCloudFormation, or empty if the resource
+	// is not known to be managed by IaC
+	ManagedBy string
+
+	// Terraform resource address or CloudFormation stack name
+	Address string
+
+	// Terraform state file the address was found in
+	Source string
+}
+
+// dumpString is a method to export the IaCItem object as a CSV string
+func (item *IaCItem) dumpString() (s []string) {
+	action := "delete directly"
+	if item.ManagedBy != "" {
+		action = "remove through " + item.ManagedBy
+	}
+	s = []string{
+		item.Step.ResourceType,
+		item.Step.ResourceId,
+		action,
+		item.ManagedBy,
+		item.Address,
+		item.Source,
+	}
+	return s
+}
+
+// terraformAddress builds the Terraform resource address for a state
+// resource instance, e.g. module.app.aws_ami.base["east"].
+func terraformAddress(module, resourceType, name string, indexKey interface{}) string {
+	addr := resourceType + "." + name
+	if module != "" {
+		addr = module + "." + addr
+	}
+	switch key := indexKey.(type) {
+	case float64:
+		addr += fmt.Sprintf("[%d]", int64(key))
+	case string:
+		addr += fmt.Sprintf("[%q]", key)
+	}
+	return addr
+}
+
+// readTerraformState parses a Terraform state file and returns a map of
+// resource keys (see resourceKey) to the address of the managing resource.
+func readTerraformState(filename string) (addrs map[string]string, err error) {
+	addrs = make(map[string]string)
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return addrs, err
+	}
+	var state terraformState
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return addrs, fmt.Errorf("parsing terraform state %s: %s", filename, err)
+	}
+	if state.Version != 4 {
+		return addrs, fmt.Errorf(
+			"unsupported terraform state version %d in %s", state.Version, filename,
+		)
+	}
+	for _, res := range state.Resources {
+		if res.Mode != "managed" {
+			// data sources only read resources
+			continue
+		}
+		rt, ok := terraformResourceTypes[res.Type]
+		if !ok {
+			continue
+		}
+		for _, inst := range res.Instances {
+			id, ok := inst.Attributes[rt.attribute].(string)
+			if !ok || id == "" {
+				continue
+			}
+			addr := terraformAddress(res.Module, res.Type, res.Name, inst.IndexKey)
+			addrs[resourceKey(rt.resourceType, id)] = addr
+		}
+	}
+	return addrs, err
+}
+
+// GetIaCReport maps each step of the deletion plan to the Terraform
+// resource address managing it by searching the TerraformStateFiles.
+// Snapshots tagged with a CloudFormation stack name are reported as
+// managed by that stack. Items that are not managed by either can be
+// deleted directly while the others should be removed through IaC to
+// avoid drift.
+func (exp *Expedition) GetIaCReport() (items []*IaCItem, err error) {
+	tfAddrs := make(map[string]string)
+	tfSources := make(map[string]string)
+	for _, filename := range exp.terraformStateFiles {
+		exp.log.Debug("reading terraform state", "filename", filename)
+		addrs, err := readTerraformState(filename)
+		if err != nil {
+			return items, err
+		}
+		for key, addr := range addrs {
+			tfAddrs[key] = addr
+			tfSources[key] = filename
+		}
+	}
+	stacks := make(map[string]string)
+	for _, nug := range exp.Nuggets {
+		for _, tag := range nug.Snap.Tags {
+			if *tag.Key == "aws:cloudformation:stack-name" {
+				stacks[*nug.Snap.SnapshotId] = *tag.Value
+			}
+		}
+	}
+	for _, step := range exp.Plan {
+		item := IaCItem{Step: step}
+		if addr, ok := tfAddrs[stepKey(step)]; ok {
+			item.ManagedBy = IaCTerraform
+			item.Address = addr
+			item.Source = tfSources[stepKey(step)]
+		} else if stack, ok := stacks[step.ResourceId]; ok && step.ResourceType == ResourceSnapshot {
+			item.ManagedBy = IaCCloudFormation
+			item.Address = stack
+		}
+		items = append(items, &item)
+	}
+	return items, err
+}
+
+// ExportIaCReport writes the result of GetIaCReport to outfile as csv
+// so it is clear which deletion plan items must be removed through IaC
+// and which can be deleted directly.
+func (exp *Expedition) ExportIaCReport() (err error) {
+	items, err := exp.GetIaCReport()
+	if err != nil {
+		return err
+	}
+	csvfile, err := os.Create(exp.outfileIaCReport)
+	if err != nil {
+		return err
+	}
+	defer csvfile.Close()
+	csvwriter := csv.NewWriter(csvfile)
+	header := []string{
+		"ResourceType", "ResourceId", "Action", "ManagedBy", "Address", "Source",
+	}
+	csvwriter.Write(header)
+	for _, item := range items {
+		csvwriter.Write(item.dumpString())
+	}
+	csvwriter.Flush()
+	err = csvwriter.Error()
+	if err != nil {
+		return err
+	}
+	exp.log.Info("wrote IaC report to file", "filename", exp.outfileIaCReport)
+	return err
+}
@@ -0,0 +1,169 @@
+package dustcollector
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/inconshreveable/log15"
+)
+
+// terraformStateV4 has a resource in a module with for_each instances,
+// a resource with count instances, a plain resource, a data source,
+// and a resource type that can't manage a plan item.
+const terraformStateV4 = `{
+  "version": 4,
+  "terraform_version": "0.13.5",
+  "serial": 7,
+  "lineage": "3c2d7a6e",
+  "outputs": {},
+  "resources": [
+    {
+      "module": "module.app",
+      "mode": "managed",
+      "type": "aws_ami",
+      "name": "base",
+      "provider": "provider[\"registry.terraform.io/hashicorp/aws\"]",
+      "instances": [
+        {"index_key": "east", "schema_version": 0, "attributes": {"id": "ami-east", "name": "base-east"}},
+        {"index_key": "west", "schema_version": 0, "attributes": {"id": "ami-west", "name": "base-west"}}
+      ]
+    },
+    {
+      "mode": "managed",
+      "type": "aws_launch_template",
+      "name": "web",
+      "provider": "provider[\"registry.terraform.io/hashicorp/aws\"]",
+      "instances": [
+        {"index_key": 0, "schema_version": 0, "attributes": {"id": "lt-0aaa", "name": "web-0"}},
+        {"index_key": 1, "schema_version": 0, "attributes": {"id": "lt-0bbb", "name": "web-1"}}
+      ]
+    },
+    {
+      "mode": "managed",
+      "type": "aws_ebs_snapshot",
+      "name": "backup",
+      "provider": "provider[\"registry.terraform.io/hashicorp/aws\"]",
+      "instances": [
+        {"schema_version": 0, "attributes": {"id": "snap-tf", "volume_id": "vol-1"}}
+      ]
+    },
+    {
+      "mode": "data",
+      "type": "aws_ami",
+      "name": "lookup",
+      "provider": "provider[\"registry.terraform.io/hashicorp/aws\"]",
+      "instances": [
+        {"schema_version": 0, "attributes": {"id": "ami-data"}}
+      ]
+    },
+    {
+      "mode": "managed",
+      "type": "aws_instance",
+      "name": "app",
+      "provider": "provider[\"registry.terraform.io/hashicorp/aws\"]",
+      "instances": [
+        {"schema_version": 1, "attributes": {"id": "i-1", "ami": "ami-east"}}
+      ]
+    }
+  ]
+}`
+
+// TestIaCReport maps a plan to a Terraform state and a CloudFormation
+// stack tag and checks which items must be removed through IaC and
+// which can be deleted directly.
+func TestIaCReport(t *testing.T) {
+	state := writeTestFile(t, "terraform.tfstate", terraformStateV4)
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	exp := &Expedition{
+		log:                 logger,
+		terraformStateFiles: []string{state},
+		Nuggets: []*Nugget{{
+			Snap: &ec2.Snapshot{
+				SnapshotId: aws.String("snap-cfn"),
+				Tags: []*ec2.Tag{{
+					Key:   aws.String("aws:cloudformation:stack-name"),
+					Value: aws.String("backups"),
+				}},
+			},
+		}},
+	}
+	for _, tc := range []struct {
+		resourceType, id   string
+		managedBy, address string
+	}{
+		{ResourceAMI, "ami-east", IaCTerraform, `module.app.aws_ami.base["east"]`},
+		{ResourceAMI, "ami-west", IaCTerraform, `module.app.aws_ami.base["west"]`},
+		{ResourceLaunchTemplate, "web-1", IaCTerraform, "aws_launch_template.web[1]"},
+		{ResourceSnapshot, "snap-tf", IaCTerraform, "aws_ebs_snapshot.backup"},
+		{ResourceSnapshot, "snap-cfn", IaCCloudFormation, "backups"},
+		// launch templates are planned by name, not ID
+		{ResourceLaunchTemplate, "lt-0aaa", "", ""},
+		// data sources only read resources
+		{ResourceAMI, "ami-data", "", ""},
+		// not in the state
+		{ResourceLaunchConfiguration, "lc-1", "", ""},
+		{ResourceSnapshot, "snap-direct", "", ""},
+	} {
+		exp.Plan = []*PlanStep{{ResourceType: tc.resourceType, ResourceId: tc.id}}
+		items, err := exp.GetIaCReport()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != 1 {
+			t.Fatalf("%s %s: got %d items, want 1", tc.resourceType, tc.id, len(items))
+		}
+		item := items[0]
+		if item.ManagedBy != tc.managedBy || item.Address != tc.address {
+			t.Errorf(
+				"%s %s: got %q %q, want %q %q", tc.resourceType, tc.id,
+				item.ManagedBy, item.Address, tc.managedBy, tc.address,
+			)
+		}
+		wantSource := ""
+		if tc.managedBy == IaCTerraform {
+			wantSource = state
+		}
+		if item.Source != wantSource {
+			t.Errorf("%s %s: got source %q, want %q", tc.resourceType, tc.id, item.Source, wantSource)
+		}
+		wantAction := "delete directly"
+		if tc.managedBy != "" {
+			wantAction = "remove through " + tc.managedBy
+		}
+		if action := item.dumpString()[2]; action != wantAction {
+			t.Errorf("%s %s: got action %q, want %q", tc.resourceType, tc.id, action, wantAction)
+		}
+	}
+}
+
+// TestTerraformResourceTypes reads a state with one resource of every
+// supported type and makes sure it is found by the attribute the plan
+// uses.
+func TestTerraformResourceTypes(t *testing.T) {
+	for tfType, rt := range terraformResourceTypes {
+		state := `{"version": 4, "resources": [{"mode": "managed", "type": "` + tfType +
+			`", "name": "x", "instances": [{"attributes": {"id": "by-id", "name": "by-name"}}]}]}`
+		addrs, err := readTerraformState(writeTestFile(t, "terraform.tfstate", state))
+		if err != nil {
+			t.Fatalf("%s: %s", tfType, err)
+		}
+		id := "by-id"
+		if rt.attribute == "name" {
+			id = "by-name"
+		}
+		if addr := addrs[resourceKey(rt.resourceType, id)]; addr != tfType+".x" || len(addrs) != 1 {
+			t.Errorf("%s: got %v, want %s managed by %s.x", tfType, addrs, resourceKey(rt.resourceType, id), tfType)
+		}
+	}
+	for _, rt := range []string{"aws_launch_template", "aws_launch_configuration"} {
+		if terraformResourceTypes[rt].attribute != "name" {
+			t.Errorf("%s is planned by name but matched by %q", rt, terraformResourceTypes[rt].attribute)
+		}
+	}
+	_, err := readTerraformState(writeTestFile(t, "old.tfstate", `{"version": 3, "modules": []}`))
+	if err == nil {
+		t.Error("version 3 state was accepted")
+	}
+}
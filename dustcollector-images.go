@@ -0,0 +1,202 @@
+package dustcollector
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// AMI holds the details of an image that uses a snapshot which are used
+// as deletion criteria. The times are nil when EC2 doesn't report them,
+// e.g. LastLaunchedTime for images that were never launched or were
+// last launched before EC2 started tracking it. LastLaunchedTime is
+// only looked up when ExpeditionInput.SpareLaunchedWithinDays is set.
+type AMI struct {
+	ImageId string `json:"imageId"`
+	Name    string `json:"name"`
+
+	// "available", "disabled", "pending", or "failed". Disabled
+	// images still hold on to their snapshots until deregistered.
+	State string `json:"state"`
+
+	CreationDate     *time.Time `json:"creationDate,omitempty"`
+	DeprecationTime  *time.Time `json:"deprecationTime,omitempty"`
+	LastLaunchedTime *time.Time `json:"lastLaunchedTime,omitempty"`
+}
+
+// describeImagesInput and the types below mirror the DescribeImages and
+// DescribeImageAttribute shapes of the EC2 API including the
+// pagination, deprecation, disabled image, and last launched time
+// fields that the ec2 package of the pinned SDK doesn't model yet. They
+// are sent with the ec2 client so they are signed, rate limited, and
+// retried like every other call.
+type describeImagesInput struct {
+	_ struct{} `type:"structure"`
+
+	ImageIds []*string `locationName:"ImageId" locationNameList:"ImageId" type:"list"`
+	Owners   []*string `locationName:"Owner" locationNameList:"Owner" type:"list"`
+
+	IncludeDeprecated *bool `type:"boolean"`
+	IncludeDisabled   *bool `type:"boolean"`
+
+	MaxResults *int64  `type:"integer"`
+	NextToken  *string `type:"string"`
+}
+
+type describeImagesOutput struct {
+	_ struct{} `type:"structure"`
+
+	Images    []*imageDescription `locationName:"imagesSet" locationNameList:"item" type:"list"`
+	NextToken *string             `locationName:"nextToken" type:"string"`
+}
+
+type imageDescription struct {
+	_ struct{} `type:"structure"`
+
+	BlockDeviceMappings []*ec2.BlockDeviceMapping `locationName:"blockDeviceMapping" locationNameList:"item" type:"list"`
+
+	CreationDate    *string    `locationName:"creationDate" type:"string"`
+	DeprecationTime *string    `locationName:"deprecationTime" type:"string"`
+	ImageId         *string    `locationName:"imageId" type:"string"`
+	Name            *string    `locationName:"name" type:"string"`
+	State           *string    `locationName:"imageState" type:"string"`
+	Tags            []*ec2.Tag `locationName:"tagSet" locationNameList:"item" type:"list"`
+}
+
+type describeImageLastLaunchedOutput struct {
+	_ struct{} `type:"structure"`
+
+	ImageId          *string             `locationName:"imageId" type:"string"`
+	LastLaunchedTime *ec2.AttributeValue `locationName:"lastLaunchedTime" type:"structure"`
+}
+
+// describeImages describes the images owned by this account, including
+// disabled and deprecated ones, with pagination handling, fetching
+// pages for as long as more allows. If ids are given only those images
+// are described.
+func (exp *Expedition) describeImages(ctx context.Context, more pager, ids ...string) (images []*imageDescription, err error) {
+	svc := ec2.New(exp.session)
+	input := describeImagesInput{
+		Owners:            []*string{aws.String(exp.account)},
+		IncludeDeprecated: aws.Bool(true),
+		IncludeDisabled:   aws.Bool(true),
+	}
+	if len(ids) > 0 {
+		input.ImageIds = aws.StringSlice(ids)
+	} else {
+		// EC2 accepts between 5 and 1000 results per page
+		pageSize := int64(exp.pageSize)
+		if pageSize < 5 {
+			pageSize = 5
+		} else if pageSize > 1000 {
+			pageSize = 1000
+		}
+		input.MaxResults = aws.Int64(pageSize)
+	}
+	for pageNum := 1; ; pageNum++ {
+		exp.log.Debug("handling image results", "page", pageNum)
+		output := describeImagesOutput{}
+		req := svc.NewRequest(&request.Operation{
+			Name:       "DescribeImages",
+			HTTPMethod: "POST",
+			HTTPPath:   "/",
+		}, &input, &output)
+		req.SetContext(ctx)
+		err = req.Send()
+		if err != nil {
+			return images, err
+		}
+		images = append(images, output.Images...)
+		lastPage := aws.StringValue(output.NextToken) == ""
+		if lastPage || !more(ListingImages, pageNum, len(images), lastPage) {
+			return images, err
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// imageLastLaunched looks up when the AMI was last used to launch an
+// instance. It returns nil if the AMI was never launched or if the
+// region doesn't support the lastLaunchedTime attribute.
+func (exp *Expedition) imageLastLaunched(ctx context.Context, ami string) (launched *time.Time, err error) {
+	exp.log.Debug("describing image attributes for last launch", "ami", ami)
+	svc := ec2.New(exp.session)
+	output := describeImageLastLaunchedOutput{}
+	req := svc.NewRequest(&request.Operation{
+		Name:       "DescribeImageAttribute",
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}, &ec2.DescribeImageAttributeInput{
+		Attribute: aws.String("lastLaunchedTime"),
+		ImageId:   aws.String(ami),
+	}, &output)
+	req.SetContext(ctx)
+	err = req.Send()
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "InvalidParameterValue" {
+		if !exp.lastLaunchUnsupported {
+			exp.log.Warn(
+				"last launched time of AMIs is not available, AMIs can't be spared by launch time",
+				"ami", ami, "error", aerr.Message(),
+			)
+			exp.lastLaunchUnsupported = true
+		}
+		return nil, nil
+	}
+	if err != nil || output.LastLaunchedTime == nil {
+		return nil, err
+	}
+	return parseImageTime(output.LastLaunchedTime.Value), err
+}
+
+// newAMI gathers the details of an image used as deletion criteria.
+// The last launched time takes a call per image so it is only looked
+// up when SpareLaunchedWithinDays uses it.
+func (exp *Expedition) newAMI(ctx context.Context, img *imageDescription) (ami *AMI, err error) {
+	ami = &AMI{
+		ImageId:         aws.StringValue(img.ImageId),
+		Name:            aws.StringValue(img.Name),
+		State:           aws.StringValue(img.State),
+		CreationDate:    parseImageTime(img.CreationDate),
+		DeprecationTime: parseImageTime(img.DeprecationTime),
+	}
+	if exp.spareLaunchedDays > 0 {
+		ami.LastLaunchedTime, err = exp.imageLastLaunched(ctx, ami.ImageId)
+	}
+	return ami, err
+}
+
+// parseImageTime parses the ISO 8601 times EC2 reports for images,
+// returning nil if the time is missing or malformed.
+func parseImageTime(s *string) *time.Time {
+	if s == nil || *s == "" {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, *s)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+// launchedRecently reports whether any of the AMIs was launched within
+// ExpeditionInput.SpareLaunchedWithinDays of the scan.
+func (exp *Expedition) launchedRecently(amis ...*AMI) bool {
+	if exp.spareLaunchedDays <= 0 {
+		return false
+	}
+	now := exp.scanTime
+	if now.IsZero() {
+		now = time.Now()
+	}
+	cutoff := now.AddDate(0, 0, -exp.spareLaunchedDays)
+	for _, ami := range amis {
+		if ami.LastLaunchedTime != nil && ami.LastLaunchedTime.After(cutoff) {
+			return true
+		}
+	}
+	return false
+}
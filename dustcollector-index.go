@@ -0,0 +1,167 @@
+package dustcollector
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// relationIndex maps the images, launch configurations, launch
+// templates, and autoscaling groups of an account to each other. It is
+// built once so that the relationships of every snapshot are resolved
+// with map lookups instead of scanning all of the resources for each
+// snapshot, which doesn't scale to accounts with tens of thousands of
+// snapshots and thousands of AMIs.
+type relationIndex struct {
+	// snapshot ID -> images with the snapshot in their block device
+	// mappings
+	snapshotImages map[string][]*imageDescription
+
+	// AMI ID or snapshot ID -> names of the launch configurations
+	// and templates that reference it
+	imageLCs    map[string][]string
+	snapshotLCs map[string][]string
+	imageLTs    map[string][]string
+	snapshotLTs map[string][]string
+
+	// launch configuration or template name -> names of the ASGs
+	// that use it, directly or through a mixed instances policy
+	lcASGs map[string][]string
+	ltASGs map[string][]string
+}
+
+// newRelationIndex indexes the given resources. Any of them may be nil.
+func newRelationIndex(
+	images []*imageDescription,
+	lcs []*autoscaling.LaunchConfiguration,
+	lts []*ec2.LaunchTemplateVersion,
+	asgs []*autoscaling.Group,
+) *relationIndex {
+	idx := relationIndex{
+		snapshotImages: make(map[string][]*imageDescription),
+		imageLCs:       make(map[string][]string),
+		snapshotLCs:    make(map[string][]string),
+		imageLTs:       make(map[string][]string),
+		snapshotLTs:    make(map[string][]string),
+		lcASGs:         make(map[string][]string),
+		ltASGs:         make(map[string][]string),
+	}
+	for _, img := range images {
+		seen := make(map[string]bool)
+		for _, bdm := range img.BlockDeviceMappings {
+			if bdm.Ebs == nil || bdm.Ebs.SnapshotId == nil || seen[*bdm.Ebs.SnapshotId] {
+				continue
+			}
+			seen[*bdm.Ebs.SnapshotId] = true
+			idx.snapshotImages[*bdm.Ebs.SnapshotId] = append(idx.snapshotImages[*bdm.Ebs.SnapshotId], img)
+		}
+	}
+	for _, lc := range lcs {
+		name := *lc.LaunchConfigurationName
+		addName(idx.imageLCs, lc.ImageId, name)
+		for _, bdm := range lc.BlockDeviceMappings {
+			if bdm.Ebs != nil {
+				addName(idx.snapshotLCs, bdm.Ebs.SnapshotId, name)
+			}
+		}
+	}
+	// ASGs may refer to a launch template by ID only
+	ltNames := make(map[string]string)
+	for _, lt := range lts {
+		name := *lt.LaunchTemplateName
+		if lt.LaunchTemplateId != nil {
+			ltNames[*lt.LaunchTemplateId] = name
+		}
+		if lt.LaunchTemplateData == nil {
+			continue
+		}
+		addName(idx.imageLTs, lt.LaunchTemplateData.ImageId, name)
+		for _, bdm := range lt.LaunchTemplateData.BlockDeviceMappings {
+			if bdm.Ebs != nil {
+				addName(idx.snapshotLTs, bdm.Ebs.SnapshotId, name)
+			}
+		}
+	}
+	for _, asg := range asgs {
+		name := *asg.AutoScalingGroupName
+		addName(idx.lcASGs, asg.LaunchConfigurationName, name)
+		if spec := asgLaunchTemplate(asg); spec != nil {
+			ltName := spec.LaunchTemplateName
+			if ltName == nil && spec.LaunchTemplateId != nil {
+				ltName = aws.String(ltNames[*spec.LaunchTemplateId])
+			}
+			addName(idx.ltASGs, ltName, name)
+		}
+	}
+	return &idx
+}
+
+// addName appends name to the names indexed by key unless key is empty.
+func addName(index map[string][]string, key *string, name string) {
+	if key == nil || *key == "" {
+		return
+	}
+	index[*key] = append(index[*key], name)
+}
+
+// lcsWithSnapImage returns the names of the launch configurations that
+// use the image or have the snapshot in their block device mappings.
+func (idx *relationIndex) lcsWithSnapImage(snapshotId, imageId string) (lcNames []string) {
+	lcNames = append(lcNames, idx.imageLCs[imageId]...)
+	return append(lcNames, idx.snapshotLCs[snapshotId]...)
+}
+
+// ltsWithSnapImage returns the names of the latest launch template
+// versions that use the image or have the snapshot in their block
+// device mappings.
+func (idx *relationIndex) ltsWithSnapImage(snapshotId, imageId string) (ltNames []string) {
+	ltNames = append(ltNames, idx.imageLTs[imageId]...)
+	return append(ltNames, idx.snapshotLTs[snapshotId]...)
+}
+
+// planIndex maps the resources in the deletion plan to the snapshots in
+// the plan so each PlanStep can be justified without scanning every
+// Nugget.
+type planIndex struct {
+	// snapshot ID -> Nugget of every snapshot in SnapToDelete
+	snapshots map[string]*Nugget
+
+	// resourceKey of an AMI or launch configuration/template -> IDs
+	// of the snapshots in SnapToDelete it blocks
+	blocked map[string][]string
+}
+
+// newPlanIndex indexes the Nuggets of the snapshots in SnapToDelete.
+func (exp *Expedition) newPlanIndex() *planIndex {
+	idx := planIndex{
+		snapshots: make(map[string]*Nugget),
+		blocked:   make(map[string][]string),
+	}
+	toDelete := make(map[string]bool, len(exp.SnapToDelete))
+	for _, id := range exp.SnapToDelete {
+		toDelete[id] = true
+	}
+	for _, nug := range exp.Nuggets {
+		id := *nug.Snap.SnapshotId
+		if !toDelete[id] {
+			continue
+		}
+		if _, ok := idx.snapshots[id]; !ok {
+			idx.snapshots[id] = nug
+		}
+		for _, blocker := range []struct {
+			resourceType string
+			ids          []string
+		}{
+			{ResourceAMI, nug.AMIIDs},
+			{ResourceLaunchConfiguration, nug.LCs},
+			{ResourceLaunchTemplate, nug.LTs},
+		} {
+			for _, blockerId := range dedupeString(blocker.ids) {
+				key := resourceKey(blocker.resourceType, blockerId)
+				idx.blocked[key] = append(idx.blocked[key], id)
+			}
+		}
+	}
+	return &idx
+}
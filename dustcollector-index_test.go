@@ -0,0 +1,275 @@
+package dustcollector
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// syntheticAccount holds the resources of a generated account.
+type syntheticAccount struct {
+	snapshots []string
+	images    []*imageDescription
+	lcs       []*autoscaling.LaunchConfiguration
+	lts       []*ec2.LaunchTemplateVersion
+	asgs      []*autoscaling.Group
+}
+
+// newSyntheticAccount generates an account with the given number of
+// snapshots and AMIs and a launch configuration, launch template, and
+// ASG for every tenth AMI. The same sizes always give the same account.
+func newSyntheticAccount(snapshots, images int) *syntheticAccount {
+	r := rand.New(rand.NewSource(int64(snapshots*31 + images)))
+	acct := syntheticAccount{}
+	snap := func() *string {
+		return aws.String(fmt.Sprintf("snap-%d", r.Intn(snapshots)))
+	}
+	ami := func() *string {
+		return aws.String(fmt.Sprintf("ami-%d", r.Intn(images)))
+	}
+	for i := 0; i < snapshots; i++ {
+		acct.snapshots = append(acct.snapshots, fmt.Sprintf("snap-%d", i))
+	}
+	for i := 0; i < images; i++ {
+		img := &imageDescription{ImageId: aws.String(fmt.Sprintf("ami-%d", i))}
+		for j := 0; j < 1+r.Intn(3); j++ {
+			img.BlockDeviceMappings = append(img.BlockDeviceMappings, &ec2.BlockDeviceMapping{
+				Ebs: &ec2.EbsBlockDevice{SnapshotId: snap()},
+			})
+		}
+		// instance store volumes have no Ebs
+		img.BlockDeviceMappings = append(img.BlockDeviceMappings, &ec2.BlockDeviceMapping{})
+		acct.images = append(acct.images, img)
+	}
+	launches := images/10 + 1
+	for i := 0; i < launches; i++ {
+		lc := &autoscaling.LaunchConfiguration{
+			LaunchConfigurationName: aws.String(fmt.Sprintf("lc-%d", i)),
+			ImageId:                 ami(),
+		}
+		if r.Intn(3) == 0 {
+			lc.BlockDeviceMappings = []*autoscaling.BlockDeviceMapping{
+				{Ebs: &autoscaling.Ebs{SnapshotId: snap()}}, {},
+			}
+		}
+		acct.lcs = append(acct.lcs, lc)
+		lt := &ec2.LaunchTemplateVersion{
+			LaunchTemplateId:   aws.String(fmt.Sprintf("lt-0%d", i)),
+			LaunchTemplateName: aws.String(fmt.Sprintf("lt-%d", i)),
+			VersionNumber:      aws.Int64(1),
+			LaunchTemplateData: &ec2.ResponseLaunchTemplateData{ImageId: ami()},
+		}
+		if r.Intn(3) == 0 {
+			lt.LaunchTemplateData.BlockDeviceMappings = []*ec2.LaunchTemplateBlockDeviceMapping{
+				{Ebs: &ec2.LaunchTemplateEbsBlockDevice{SnapshotId: snap()}}, {},
+			}
+		}
+		acct.lts = append(acct.lts, lt)
+	}
+	for i := 0; i < launches; i++ {
+		asg := &autoscaling.Group{AutoScalingGroupName: aws.String(fmt.Sprintf("asg-%d", i))}
+		lt := r.Intn(launches)
+		switch r.Intn(4) {
+		case 0:
+			asg.LaunchConfigurationName = aws.String(fmt.Sprintf("lc-%d", r.Intn(launches)))
+		case 1:
+			asg.LaunchTemplate = &autoscaling.LaunchTemplateSpecification{
+				LaunchTemplateName: aws.String(fmt.Sprintf("lt-%d", lt)),
+			}
+		case 2:
+			asg.LaunchTemplate = &autoscaling.LaunchTemplateSpecification{
+				LaunchTemplateId: aws.String(fmt.Sprintf("lt-0%d", lt)),
+			}
+		case 3:
+			asg.MixedInstancesPolicy = &autoscaling.MixedInstancesPolicy{
+				LaunchTemplate: &autoscaling.LaunchTemplate{
+					LaunchTemplateSpecification: &autoscaling.LaunchTemplateSpecification{
+						LaunchTemplateId: aws.String(fmt.Sprintf("lt-0%d", lt)),
+					},
+				},
+			}
+		}
+		acct.asgs = append(acct.asgs, asg)
+	}
+	return &acct
+}
+
+// The functions below are the nested loops that resolved relationships
+// before the relationIndex, scanning every resource for each snapshot.
+
+func nestedImagesWithSnapshot(images []*imageDescription, snapshotId string) (imageIds []string) {
+	for _, img := range images {
+		for _, bdm := range img.BlockDeviceMappings {
+			if bdm.Ebs != nil && aws.StringValue(bdm.Ebs.SnapshotId) == snapshotId {
+				imageIds = append(imageIds, *img.ImageId)
+				break
+			}
+		}
+	}
+	return imageIds
+}
+
+func nestedLcsWithSnapImage(lcs []*autoscaling.LaunchConfiguration, snapshotId, imageId string) (lcNames []string) {
+	for _, lc := range lcs {
+		if aws.StringValue(lc.ImageId) == imageId {
+			lcNames = append(lcNames, *lc.LaunchConfigurationName)
+		}
+		for _, bdm := range lc.BlockDeviceMappings {
+			if bdm.Ebs != nil && aws.StringValue(bdm.Ebs.SnapshotId) == snapshotId {
+				lcNames = append(lcNames, *lc.LaunchConfigurationName)
+			}
+		}
+	}
+	return lcNames
+}
+
+func nestedLtsWithSnapImage(lts []*ec2.LaunchTemplateVersion, snapshotId, imageId string) (ltNames []string) {
+	for _, lt := range lts {
+		if aws.StringValue(lt.LaunchTemplateData.ImageId) == imageId {
+			ltNames = append(ltNames, *lt.LaunchTemplateName)
+		}
+		for _, bdm := range lt.LaunchTemplateData.BlockDeviceMappings {
+			if bdm.Ebs != nil && aws.StringValue(bdm.Ebs.SnapshotId) == snapshotId {
+				ltNames = append(ltNames, *lt.LaunchTemplateName)
+			}
+		}
+	}
+	return ltNames
+}
+
+// sorted returns the distinct strings of s in order.
+func sorted(s []string) []string {
+	s = dedupeString(s)
+	sort.Strings(s)
+	return s
+}
+
+func sameStrings(a, b []string) bool {
+	a, b = sorted(a), sorted(b)
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// TestRelationIndexMatchesNestedLoops resolves every snapshot of
+// synthetic accounts through the index and through the nested loops.
+func TestRelationIndexMatchesNestedLoops(t *testing.T) {
+	for _, size := range []struct{ snapshots, images int }{{50, 10}, {2000, 300}} {
+		acct := newSyntheticAccount(size.snapshots, size.images)
+		idx := newRelationIndex(acct.images, acct.lcs, acct.lts, acct.asgs)
+		for _, snapshotId := range acct.snapshots {
+			var indexed []string
+			for _, img := range idx.snapshotImages[snapshotId] {
+				indexed = append(indexed, *img.ImageId)
+			}
+			imageIds := nestedImagesWithSnapshot(acct.images, snapshotId)
+			if !sameStrings(indexed, imageIds) {
+				t.Fatalf("%s: index has images %v, nested loops %v", snapshotId, indexed, imageIds)
+			}
+			// "" stands for the launch configurations and templates
+			// with the snapshot in their own block device mappings
+			for _, imageId := range append(imageIds, "") {
+				lcs := idx.lcsWithSnapImage(snapshotId, imageId)
+				if want := nestedLcsWithSnapImage(acct.lcs, snapshotId, imageId); !sameStrings(lcs, want) {
+					t.Fatalf("%s %s: index has LCs %v, nested loops %v", snapshotId, imageId, lcs, want)
+				}
+				lts := idx.ltsWithSnapImage(snapshotId, imageId)
+				if want := nestedLtsWithSnapImage(acct.lts, snapshotId, imageId); !sameStrings(lts, want) {
+					t.Fatalf("%s %s: index has LTs %v, nested loops %v", snapshotId, imageId, lts, want)
+				}
+			}
+		}
+		for _, lc := range acct.lcs {
+			name := *lc.LaunchConfigurationName
+			if _, want := lcInASGs(name, acct.asgs); !sameStrings(idx.lcASGs[name], want) {
+				t.Fatalf("%s: index has ASGs %v, nested loops %v", name, idx.lcASGs[name], want)
+			}
+		}
+		for _, lt := range acct.lts {
+			name := *lt.LaunchTemplateName
+			if _, want := ltInASGs(name, *lt.LaunchTemplateId, acct.asgs); !sameStrings(idx.ltASGs[name], want) {
+				t.Fatalf("%s: index has ASGs %v, nested loops %v", name, idx.ltASGs[name], want)
+			}
+		}
+		found := resolveWithIndex(acct)
+		if want := resolveWithNestedLoops(acct); found == 0 || found != want {
+			t.Fatalf("index found %d ASG associations, nested loops %d", found, want)
+		}
+	}
+}
+
+// resolveWithIndex finds the launch configurations and templates of
+// every snapshot through its AMIs and counts the ASGs that use them.
+func resolveWithIndex(acct *syntheticAccount) (found int) {
+	idx := newRelationIndex(acct.images, acct.lcs, acct.lts, acct.asgs)
+	for _, snapshotId := range acct.snapshots {
+		for _, img := range idx.snapshotImages[snapshotId] {
+			for _, lc := range idx.lcsWithSnapImage(snapshotId, *img.ImageId) {
+				found += len(idx.lcASGs[lc])
+			}
+			for _, lt := range idx.ltsWithSnapImage(snapshotId, *img.ImageId) {
+				found += len(idx.ltASGs[lt])
+			}
+		}
+	}
+	return found
+}
+
+func resolveWithNestedLoops(acct *syntheticAccount) (found int) {
+	ltIds := make(map[string]string)
+	for _, lt := range acct.lts {
+		ltIds[*lt.LaunchTemplateName] = *lt.LaunchTemplateId
+	}
+	for _, snapshotId := range acct.snapshots {
+		for _, imageId := range nestedImagesWithSnapshot(acct.images, snapshotId) {
+			for _, lc := range nestedLcsWithSnapImage(acct.lcs, snapshotId, imageId) {
+				_, asgs := lcInASGs(lc, acct.asgs)
+				found += len(asgs)
+			}
+			for _, lt := range nestedLtsWithSnapImage(acct.lts, snapshotId, imageId) {
+				_, asgs := ltInASGs(lt, ltIds[lt], acct.asgs)
+				found += len(asgs)
+			}
+		}
+	}
+	return found
+}
+
+var benchmarkSizes = []struct{ snapshots, images int }{
+	{1000, 100},
+	{10000, 1000},
+	{50000, 3000},
+}
+
+func BenchmarkRelationIndex(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		acct := newSyntheticAccount(size.snapshots, size.images)
+		b.Run(fmt.Sprintf("snapshots=%d/images=%d", size.snapshots, size.images), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				resolveWithIndex(acct)
+			}
+		})
+	}
+}
+
+func BenchmarkNestedLoops(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		acct := newSyntheticAccount(size.snapshots, size.images)
+		b.Run(fmt.Sprintf("snapshots=%d/images=%d", size.snapshots, size.images), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				resolveWithNestedLoops(acct)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package dustcollector
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// journalEntry is a single line of the append-only apply journal. The
+// account and region make sure a journal is only resumed against the
+// account and region it was written for. The launch template version
+// seen by the analysis is recorded so that a plan rebuilt from the
+// journal still skips launch templates with new versions.
+type journalEntry struct {
+	Time         time.Time `json:"time"`
+	Account      string    `json:"account"`
+	Region       string    `json:"region"`
+	ResourceType string    `json:"resourceType"`
+	ResourceId   string    `json:"resourceId"`
+	Status       string    `json:"status"`
+	Reason       string    `json:"reason,omitempty"`
+
+	LaunchTemplateVersion int64 `json:"launchTemplateVersion,omitempty"`
+}
+
+// planOrder returns the position of the given resource type in the
+// deletion order: LaunchTemplates, LaunchConfigurations, AMIs, Snapshots.
+func planOrder(resourceType string) int {
+	switch resourceType {
+	case ResourceLaunchTemplate:
+		return 0
+	case ResourceLaunchConfiguration:
+		return 1
+	case ResourceAMI:
+		return 2
+	case ResourceSnapshot:
+		return 3
+	}
+	return 4
+}
+
+// sortPlan makes sure the plan honors the deletion order while keeping
+// the original order within each resource type.
+func sortPlan(plan []*PlanStep) {
+	sort.SliceStable(plan, func(i, j int) bool {
+		return planOrder(plan[i].ResourceType) < planOrder(plan[j].ResourceType)
+	})
+}
+
+// readJournal reads all entries from the journal file. A missing
+// journal is not an error and simply returns no entries. Only the last
+// line may be malformed, as it is when a run is killed while writing
+// it; anything else means the journal is corrupt.
+func readJournal(filename string) (entries []journalEntry, err error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return entries, nil
+		}
+		return entries, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	var lineno, badLine int
+	var badErr error
+	for scanner.Scan() {
+		lineno++
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		if badErr != nil {
+			return entries, fmt.Errorf("apply journal %s line %d: %s", filename, badLine, badErr)
+		}
+		var entry journalEntry
+		err = json.Unmarshal(line, &entry)
+		if err != nil {
+			// a partially written last line from an interrupted
+			// run is expected so it is ignored if nothing follows
+			badLine, badErr = lineno, err
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// resumeFromJournal reconciles the current plan with the apply journal.
+// Steps the journal records as deleted are marked deleted so they are
+// never re-attempted. If there is no plan in memory (i.e., Start was not
+// called in this process) the plan is rebuilt from the journal. It returns
+// the keys (see stepKey) of every step the journal already knows about.
+// A journal written for another account or region is rejected.
+func (exp *Expedition) resumeFromJournal() (known map[string]bool, err error) {
+	known = make(map[string]bool)
+	entries, err := readJournal(exp.applyJournal)
+	if err != nil {
+		return known, err
+	}
+	last := make(map[string]journalEntry)
+	var order []string
+	if exp.ltVersions == nil {
+		exp.ltVersions = make(map[string]int64)
+	}
+	for _, entry := range entries {
+		if entry.Account != exp.account || entry.Region != exp.region() {
+			return known, fmt.Errorf(
+				"apply journal %s is for account %q in region %q, not account %q in region %q",
+				exp.applyJournal, entry.Account, entry.Region, exp.account, exp.region(),
+			)
+		}
+		// the versions of this process's analysis take precedence
+		if _, ok := exp.ltVersions[entry.ResourceId]; !ok && entry.LaunchTemplateVersion != 0 &&
+			entry.ResourceType == ResourceLaunchTemplate {
+			exp.ltVersions[entry.ResourceId] = entry.LaunchTemplateVersion
+		}
+		key := resourceKey(entry.ResourceType, entry.ResourceId)
+		if !known[key] {
+			known[key] = true
+			order = append(order, key)
+		}
+		// once deleted always deleted
+		if last[key].Status != StepDeleted {
+			last[key] = entry
+		}
+	}
+	if exp.Plan == nil {
+		for _, key := range order {
+			entry := last[key]
+			exp.Plan = append(exp.Plan, &PlanStep{
+				ResourceType: entry.ResourceType,
+				ResourceId:   entry.ResourceId,
+				Status:       StepPending,
+			})
+		}
+	}
+	var resumed int
+	for _, step := range exp.Plan {
+		entry, ok := last[stepKey(step)]
+		if ok && entry.Status == StepDeleted {
+			step.Status = StepDeleted
+			step.Reason = ""
+			resumed++
+		}
+	}
+	if resumed > 0 {
+		exp.log.Info(
+			"resuming apply from journal", "journal", exp.applyJournal,
+			"alreadyDeleted", resumed, "planSteps", len(exp.Plan),
+		)
+	}
+	sortPlan(exp.Plan)
+	return known, err
+}
+
+// resourceKey uniquely identifies a resource of the given type.
+func resourceKey(resourceType, id string) string {
+	return resourceType + "/" + id
+}
+
+// stepKey uniquely identifies a step within the plan and the journal.
+func stepKey(step *PlanStep) string {
+	return resourceKey(step.ResourceType, step.ResourceId)
+}
+
+// openJournal opens the journal for appending, creating it if needed.
+func (exp *Expedition) openJournal() (file *os.File, err error) {
+	return os.OpenFile(exp.applyJournal, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+}
+
+// writeJournal appends the current state of the step to the journal and
+// syncs it to disk so it survives the process being killed.
+func (exp *Expedition) writeJournal(file *os.File, step *PlanStep) (err error) {
+	entry := journalEntry{
+		Time:         time.Now().UTC(),
+		Account:      exp.account,
+		Region:       exp.region(),
+		ResourceType: step.ResourceType,
+		ResourceId:   step.ResourceId,
+		Status:       step.Status,
+		Reason:       step.Reason,
+	}
+	if step.ResourceType == ResourceLaunchTemplate {
+		entry.LaunchTemplateVersion = exp.ltVersions[step.ResourceId]
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+	return file.Sync()
+}
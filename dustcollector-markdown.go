@@ -0,0 +1,92 @@
+package dustcollector
+
+import (
+	"io"
+	"os"
+	"text/template"
+)
+
+// DefaultMarkdownTemplate is the text/template used by WriteMarkdown
+// when ExpeditionInput.MarkdownTemplate is not set. It is executed
+// with a *Summary and is a good starting point for a custom template.
+// All of its text comes from the message catalog of the Expedition
+// language.
+const DefaultMarkdownTemplate = `## {{if .Owner}}{{t "md.title.owner" .Account .Owner}}{{else}}{{t "md.title" .Account}}{{end}}
+{{if .Truncated}}
+{{range .Truncations}}> **{{t "truncated" (t (print "listing." .Listing)) .Pages .Items}}**
+>
+{{end}}{{if .AssociationsTruncated}}> {{t "truncated.associations"}}
+{{end}}{{end}}
+{{t "md.intro" (len .Snapshots) .SnapshotCount .DateFilter}}
+{{if .Stages}}
+### {{t "report.plan"}}
+{{if or .LaunchTemplates .LaunchConfigurations .AMIs}}
+{{t "md.warning"}}
+{{end}}{{range $i, $stage := .Stages}}
+#### {{inc $i}}. {{t "md.stage" (len $stage.Steps) (t (print "type." $stage.ResourceType))}}
+
+| {{t "col.resource"}} | {{t "col.why"}} |
+| --- | --- |
+{{range $stage.Steps}}| {{md .ResourceId}} | {{md .Justification}} |
+{{end}}{{end}}{{else}}
+{{t "report.nothing"}}
+{{end}}{{if .Spared}}
+### {{t "report.sparedTitle"}}
+{{range .Spared}}
+<details>
+<summary>{{t "report.sparedGroup" (len .Nuggets) (reason .Reason)}}</summary>
+
+| {{t "col.snapshot"}} | {{t "col.volume"}} | {{t "col.created"}} | {{t "col.size"}} | {{t "col.amis"}} | {{t "col.asgs"}} | {{t "col.usedThrough"}} |
+| --- | --- | --- | ---: | --- | --- | --- |
+{{range .Nuggets}}| {{.Snap.SnapshotId}} | {{.Snap.VolumeId}} | {{date .Snap.StartTime}} | {{.Snap.VolumeSize}} | {{md (join .AMIIDs)}} | {{md (join .ASGs)}} | {{md (join .AssociationChains)}} |
+{{end}}
+</details>
+{{end}}{{end}}
+### {{t "report.totals"}}
+
+| | |
+| --- | ---: |
+| {{t "report.toDelete"}} | {{len .Snapshots}} |
+| {{t "report.spared"}} | {{.SparedCount}} |
+| {{t "report.size"}} | {{.TotalGbs}} GB |
+| {{t "report.savingsAt" .Rate}} | ${{printf "%.2f" .Savings}} |
+{{if .Throttled}}
+### {{t "report.throttling"}}
+
+| {{t "col.apiFamily"}} | {{t "col.requests"}} | {{t "col.throttled"}} | {{t "col.retries"}} | {{t "col.waited"}} |
+| --- | ---: | ---: | ---: | ---: |
+{{range .Throttling}}| {{.Family}} | {{.Requests}} | {{.Throttled}} | {{.Retries}} | {{printf "%.1f" .WaitSeconds}} |
+{{end}}{{end -}}
+`
+
+// WriteMarkdown renders the Summary of the Expedition to w as Markdown
+// suitable for pull requests and tickets using the MarkdownTemplate
+// provided in the ExpeditionInput or DefaultMarkdownTemplate.
+func (exp *Expedition) WriteMarkdown(w io.Writer) (err error) {
+	tmpl, err := exp.markdownTmpl()
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, exp.GetSummary())
+}
+
+// markdownTmpl parses the Markdown template of the Expedition.
+func (exp *Expedition) markdownTmpl() (*template.Template, error) {
+	return template.New("markdown").Funcs(exp.summaryFuncs()).Parse(exp.markdownTemplate)
+}
+
+// ExportMarkdown writes the Markdown summary (see WriteMarkdown) to
+// outfile.
+func (exp *Expedition) ExportMarkdown() (err error) {
+	file, err := os.Create(exp.outfileMarkdown)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	err = exp.WriteMarkdown(file)
+	if err != nil {
+		return err
+	}
+	exp.log.Info("wrote markdown summary to file", "filename", exp.outfileMarkdown)
+	return err
+}
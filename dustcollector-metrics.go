@@ -0,0 +1,239 @@
+package dustcollector
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// metricsContentType is the Prometheus text exposition format.
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// spareReasonLabels maps each Nugget.SpareReason to the value of the
+// reason label on the spared snapshot metrics.
+var spareReasonLabels = map[string]string{
+	SpareHasVolume:        "volume_exists",
+	SpareInASG:            "autoscaling_group",
+	SpareShared:           "shared",
+	SpareRecentlyLaunched: "recently_launched",
+	SpareExempt:           "exempt",
+}
+
+type metricSample struct {
+	labels []string
+	value  float64
+}
+
+// metricFamily is a single metric name with all of its samples.
+type metricFamily struct {
+	name    string
+	help    string
+	samples []metricSample
+}
+
+// MetricsCollector exposes the results of one or more Expeditions (e.g.,
+// one per account and region) as Prometheus gauges labeled by account
+// and region. It can serve them as a /metrics endpoint, write them to a
+// file for the node_exporter textfile collector, or push them to a
+// Prometheus Pushgateway.
+type MetricsCollector struct {
+	mu   sync.RWMutex
+	exps []*Expedition
+}
+
+// NewMetricsCollector returns a MetricsCollector for the given completed
+// Expeditions.
+func NewMetricsCollector(exps ...*Expedition) *MetricsCollector {
+	return &MetricsCollector{exps: exps}
+}
+
+// Set replaces the Expeditions exposed by the collector, e.g. after a
+// new scan has completed.
+func (c *MetricsCollector) Set(exps ...*Expedition) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.exps = exps
+}
+
+// families gathers the metric families for all Expeditions.
+func (c *MetricsCollector) families() []*metricFamily {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	gauge := func(name, help string) *metricFamily {
+		return &metricFamily{name: "dustcollector_" + name, help: help}
+	}
+	inScope := gauge("snapshots_in_scope", "Snapshots created before the date filter.")
+	orphaned := gauge("orphaned_snapshots", "Snapshots eligible for deletion.")
+	orphanedGb := gauge("orphaned_gigabytes", "Combined volume size of the snapshots eligible for deletion.")
+	waste := gauge("estimated_monthly_waste_dollars", "Estimated monthly cost of the snapshots eligible for deletion.")
+	spared := gauge("spared_snapshots", "Snapshots left out of the deletion plan by reason.")
+	plan := gauge("plan_steps", "Resources in the deletion plan by resource type.")
+	duration := gauge("scan_duration_seconds", "How long the last scan took.")
+	lastScan := gauge("last_scan_timestamp_seconds", "Unix time of the last scan.")
+	apiCalls := gauge("scan_api_calls", "AWS API calls made during the last scan.")
+	apiErrors := gauge("scan_api_errors", "AWS API calls that failed during the last scan.")
+	throttled := gauge("scan_throttled_requests", "AWS API attempts throttled during the last scan by API family.")
+	truncated := gauge("scan_truncated_listings", "Listings cut short by MaxPages or MaxSnapshots during the last scan by listing.")
+	rateWait := gauge("scan_rate_limit_wait_seconds", "Time spent waiting for the client-side rate limiter during the last scan by API family.")
+	for _, exp := range c.exps {
+		labels := []string{"account", exp.account, "region", exp.region()}
+		with := func(extra ...string) []string {
+			return append(append([]string{}, labels...), extra...)
+		}
+		summary := exp.GetSummary()
+		inScope.add(labels, float64(summary.SnapshotCount))
+		orphaned.add(labels, float64(len(summary.Snapshots)))
+		orphanedGb.add(labels, float64(summary.TotalGbs))
+		waste.add(labels, summary.Savings)
+		for _, reason := range spareReasons {
+			var count int
+			for _, group := range summary.Spared {
+				if group.Reason == reason {
+					count = len(group.Nuggets)
+				}
+			}
+			spared.add(with("reason", spareReasonLabels[reason]), float64(count))
+		}
+		for _, resourceType := range []string{
+			ResourceLaunchTemplate, ResourceLaunchConfiguration, ResourceAMI, ResourceSnapshot,
+		} {
+			var count int
+			for _, stage := range summary.Stages {
+				if stage.ResourceType == resourceType {
+					count = len(stage.Steps)
+				}
+			}
+			plan.add(with("resource_type", resourceType), float64(count))
+		}
+		duration.add(labels, exp.scanDuration.Seconds())
+		if !exp.scanTime.IsZero() {
+			lastScan.add(labels, float64(exp.scanTime.Unix()))
+		}
+		for _, count := range exp.GetAPICalls() {
+			op := with("service", count.Service, "operation", count.Operation)
+			apiCalls.add(op, float64(count.Calls))
+			apiErrors.add(op, float64(count.Errors))
+		}
+		for _, t := range exp.GetThrottleStats() {
+			family := with("family", t.Family)
+			throttled.add(family, float64(t.Throttled))
+			rateWait.add(family, t.WaitSeconds)
+		}
+		for _, t := range exp.Truncations {
+			truncated.add(with("listing", t.Listing), 1)
+		}
+	}
+	return []*metricFamily{
+		inScope, orphaned, orphanedGb, waste, spared, plan,
+		duration, lastScan, apiCalls, apiErrors, throttled, rateWait, truncated,
+	}
+}
+
+// add appends a sample with the given label name/value pairs.
+func (f *metricFamily) add(labels []string, value float64) {
+	f.samples = append(f.samples, metricSample{labels: labels, value: value})
+}
+
+// escapeLabelValue escapes a label value for the text exposition format.
+func escapeLabelValue(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return r.Replace(s)
+}
+
+// WriteMetrics writes all metrics to w in the Prometheus text exposition
+// format.
+func (c *MetricsCollector) WriteMetrics(w io.Writer) (err error) {
+	bw := bufio.NewWriter(w)
+	for _, f := range c.families() {
+		if len(f.samples) == 0 {
+			continue
+		}
+		fmt.Fprintf(bw, "# HELP %s %s\n", f.name, f.help)
+		fmt.Fprintf(bw, "# TYPE %s gauge\n", f.name)
+		for _, s := range f.samples {
+			var pairs []string
+			for i := 0; i+1 < len(s.labels); i += 2 {
+				pairs = append(pairs, fmt.Sprintf(`%s="%s"`, s.labels[i], escapeLabelValue(s.labels[i+1])))
+			}
+			fmt.Fprintf(
+				bw, "%s{%s} %s\n", f.name, strings.Join(pairs, ","),
+				strconv.FormatFloat(s.value, 'g', -1, 64),
+			)
+		}
+	}
+	return bw.Flush()
+}
+
+// ServeHTTP serves the metrics so the collector can be registered as a
+// /metrics handler.
+func (c *MetricsCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	err := c.WriteMetrics(&buf)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", metricsContentType)
+	w.Write(buf.Bytes())
+}
+
+// WriteTextfile writes the metrics to filename for the node_exporter
+// textfile collector. The file is written to a temporary file first and
+// renamed so the collector never reads a partial file.
+func (c *MetricsCollector) WriteTextfile(filename string) (err error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	err = c.WriteMetrics(tmp)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(tmp.Name(), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// Push sends the metrics to a Prometheus Pushgateway at gatewayURL
+// (e.g. http://pushgateway:9091) under the given job name, replacing
+// any metrics previously pushed for that job.
+func (c *MetricsCollector) Push(gatewayURL, job string) (err error) {
+	var buf bytes.Buffer
+	err = c.WriteMetrics(&buf)
+	if err != nil {
+		return err
+	}
+	endpoint := strings.TrimRight(gatewayURL, "/") + "/metrics/job/" + url.PathEscape(job)
+	req, err := http.NewRequest(http.MethodPut, endpoint, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", metricsContentType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("pushgateway returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return err
+}
@@ -0,0 +1,143 @@
+package dustcollector
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// newMetricsExpedition returns a scanned Expedition with one snapshot
+// to delete and one spared, throttling stats, and a truncated listing.
+func newMetricsExpedition() *Expedition {
+	planned := &Nugget{Snap: &ec2.Snapshot{SnapshotId: aws.String("snap-1"), VolumeSize: aws.Int64(10)}}
+	spared := &Nugget{
+		Snap:        &ec2.Snapshot{SnapshotId: aws.String("snap-2"), VolumeSize: aws.Int64(4)},
+		HasVol:      true,
+		SpareReason: SpareHasVolume,
+	}
+	exp := &Expedition{
+		account:      "123456789012",
+		stateRegion:  "us-east-1",
+		ebsSnapRate:  0.05,
+		scanTime:     time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC),
+		scanDuration: 90 * time.Second,
+		Nuggets:      []*Nugget{planned, spared},
+		SnapToDelete: []string{"snap-1"},
+		Bars: []*Bar{
+			{VolumeId: aws.String("vol-1"), Nuggets: []*Nugget{planned}},
+			{VolumeId: aws.String("vol-2"), Nuggets: []*Nugget{spared}, HasVol: true},
+		},
+		Plan: []*PlanStep{
+			{ResourceType: ResourceAMI, ResourceId: "ami-1"},
+			{ResourceType: ResourceSnapshot, ResourceId: "snap-1"},
+		},
+		Truncations: []Truncation{{Listing: ListingImages, Pages: 2, Items: 200}},
+	}
+	exp.limiter.buckets = map[string]*tokenBucket{
+		"ec2:read": {stats: ThrottleStats{Family: "ec2:read", Throttled: 3, WaitSeconds: 1.25}},
+	}
+	return exp
+}
+
+// metricsGolden is the exposition of newMetricsExpedition and an
+// Expedition that found nothing in a region with characters that must
+// be escaped. The families without samples (API calls and errors) are
+// left out.
+const metricsGolden = `# HELP dustcollector_snapshots_in_scope Snapshots created before the date filter.
+# TYPE dustcollector_snapshots_in_scope gauge
+dustcollector_snapshots_in_scope{account="123456789012",region="us-east-1"} 2
+dustcollector_snapshots_in_scope{account="210987654321",region="a\"b\\c\nd"} 0
+# HELP dustcollector_orphaned_snapshots Snapshots eligible for deletion.
+# TYPE dustcollector_orphaned_snapshots gauge
+dustcollector_orphaned_snapshots{account="123456789012",region="us-east-1"} 1
+dustcollector_orphaned_snapshots{account="210987654321",region="a\"b\\c\nd"} 0
+# HELP dustcollector_orphaned_gigabytes Combined volume size of the snapshots eligible for deletion.
+# TYPE dustcollector_orphaned_gigabytes gauge
+dustcollector_orphaned_gigabytes{account="123456789012",region="us-east-1"} 10
+dustcollector_orphaned_gigabytes{account="210987654321",region="a\"b\\c\nd"} 0
+# HELP dustcollector_estimated_monthly_waste_dollars Estimated monthly cost of the snapshots eligible for deletion.
+# TYPE dustcollector_estimated_monthly_waste_dollars gauge
+dustcollector_estimated_monthly_waste_dollars{account="123456789012",region="us-east-1"} 0.5
+dustcollector_estimated_monthly_waste_dollars{account="210987654321",region="a\"b\\c\nd"} 0
+# HELP dustcollector_spared_snapshots Snapshots left out of the deletion plan by reason.
+# TYPE dustcollector_spared_snapshots gauge
+dustcollector_spared_snapshots{account="123456789012",region="us-east-1",reason="volume_exists"} 1
+dustcollector_spared_snapshots{account="123456789012",region="us-east-1",reason="autoscaling_group"} 0
+dustcollector_spared_snapshots{account="123456789012",region="us-east-1",reason="shared"} 0
+dustcollector_spared_snapshots{account="123456789012",region="us-east-1",reason="recently_launched"} 0
+dustcollector_spared_snapshots{account="123456789012",region="us-east-1",reason="exempt"} 0
+dustcollector_spared_snapshots{account="210987654321",region="a\"b\\c\nd",reason="volume_exists"} 0
+dustcollector_spared_snapshots{account="210987654321",region="a\"b\\c\nd",reason="autoscaling_group"} 0
+dustcollector_spared_snapshots{account="210987654321",region="a\"b\\c\nd",reason="shared"} 0
+dustcollector_spared_snapshots{account="210987654321",region="a\"b\\c\nd",reason="recently_launched"} 0
+dustcollector_spared_snapshots{account="210987654321",region="a\"b\\c\nd",reason="exempt"} 0
+# HELP dustcollector_plan_steps Resources in the deletion plan by resource type.
+# TYPE dustcollector_plan_steps gauge
+dustcollector_plan_steps{account="123456789012",region="us-east-1",resource_type="LaunchTemplate"} 0
+dustcollector_plan_steps{account="123456789012",region="us-east-1",resource_type="LaunchConfiguration"} 0
+dustcollector_plan_steps{account="123456789012",region="us-east-1",resource_type="AMI"} 1
+dustcollector_plan_steps{account="123456789012",region="us-east-1",resource_type="Snapshot"} 1
+dustcollector_plan_steps{account="210987654321",region="a\"b\\c\nd",resource_type="LaunchTemplate"} 0
+dustcollector_plan_steps{account="210987654321",region="a\"b\\c\nd",resource_type="LaunchConfiguration"} 0
+dustcollector_plan_steps{account="210987654321",region="a\"b\\c\nd",resource_type="AMI"} 0
+dustcollector_plan_steps{account="210987654321",region="a\"b\\c\nd",resource_type="Snapshot"} 0
+# HELP dustcollector_scan_duration_seconds How long the last scan took.
+# TYPE dustcollector_scan_duration_seconds gauge
+dustcollector_scan_duration_seconds{account="123456789012",region="us-east-1"} 90
+dustcollector_scan_duration_seconds{account="210987654321",region="a\"b\\c\nd"} 0
+# HELP dustcollector_last_scan_timestamp_seconds Unix time of the last scan.
+# TYPE dustcollector_last_scan_timestamp_seconds gauge
+dustcollector_last_scan_timestamp_seconds{account="123456789012",region="us-east-1"} 1.5909696e+09
+# HELP dustcollector_scan_throttled_requests AWS API attempts throttled during the last scan by API family.
+# TYPE dustcollector_scan_throttled_requests gauge
+dustcollector_scan_throttled_requests{account="123456789012",region="us-east-1",family="ec2:read"} 3
+# HELP dustcollector_scan_rate_limit_wait_seconds Time spent waiting for the client-side rate limiter during the last scan by API family.
+# TYPE dustcollector_scan_rate_limit_wait_seconds gauge
+dustcollector_scan_rate_limit_wait_seconds{account="123456789012",region="us-east-1",family="ec2:read"} 1.25
+# HELP dustcollector_scan_truncated_listings Listings cut short by MaxPages or MaxSnapshots during the last scan by listing.
+# TYPE dustcollector_scan_truncated_listings gauge
+dustcollector_scan_truncated_listings{account="123456789012",region="us-east-1",listing="images"} 1
+`
+
+// TestWriteMetrics compares the exposition of two Expeditions with
+// metricsGolden.
+func TestWriteMetrics(t *testing.T) {
+	empty := &Expedition{account: "210987654321", stateRegion: "a\"b\\c\nd"}
+	var buf bytes.Buffer
+	err := NewMetricsCollector(newMetricsExpedition(), empty).WriteMetrics(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != metricsGolden {
+		gotLines, wantLines := strings.Split(got, "\n"), strings.Split(metricsGolden, "\n")
+		for i := 0; i < len(gotLines) || i < len(wantLines); i++ {
+			var g, w string
+			if i < len(gotLines) {
+				g = gotLines[i]
+			}
+			if i < len(wantLines) {
+				w = wantLines[i]
+			}
+			if g != w {
+				t.Fatalf("line %d:\ngot  %s\nwant %s\n\nfull output:\n%s", i+1, g, w, got)
+			}
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	NewMetricsCollector(newMetricsExpedition(), empty).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != metricsContentType || rec.Body.String() != metricsGolden {
+		t.Errorf("/metrics served %q with content type %q", rec.Body.String(), ct)
+	}
+
+	buf.Reset()
+	err = NewMetricsCollector().WriteMetrics(&buf)
+	if err != nil || buf.Len() != 0 {
+		t.Errorf("collector without Expeditions wrote %q, %v", buf.String(), err)
+	}
+}
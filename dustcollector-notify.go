@@ -0,0 +1,449 @@
+package dustcollector
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/inconshreveable/log15"
+)
+
+// Notification events
+const (
+	// A scan found at least NewOrphansThreshold orphaned snapshots
+	// that were not orphaned in the previous scan
+	EventScanFindings = "scan.findings"
+
+	// Apply finished, successfully or not
+	EventApplyFinished = "apply.finished"
+
+	// Sent by Notifier.Test to every webhook
+	EventTest = "test"
+)
+
+// Webhook payload formats
+const (
+	// {"text": "..."} as accepted by Slack incoming webhooks
+	WebhookSlack = "slack"
+
+	// a MessageCard as accepted by Microsoft Teams incoming webhooks
+	WebhookTeams = "teams"
+
+	// the Notification itself as json
+	WebhookGeneric = "generic"
+)
+
+// DefaultNotificationTemplate is the text/template used to render the
+// message of a Notification when the Webhook has no Template. It is
+// executed with a *Notification and has the same functions as the
+// recommendations template.
+const DefaultNotificationTemplate = `
+{{- if eq .Event "scan.findings" -}}
+{{t "notify.scan" .NewSnapshotCount .Account .Region .OrphanedSnapshots .OrphanedGbs .MonthlyWaste}}
+{{- else if eq .Event "apply.finished" -}}
+{{if .Error}}{{t "notify.apply.error" .Account .Region .Error}} {{end -}}
+{{t "notify.apply" .Account .Region .Deleted .Skipped .Failed .RealizedSavings}}
+{{- else -}}
+{{t "notify.test"}}
+{{- end}}`
+
+// Notification is the data sent to webhooks. Generic webhooks receive
+// it as json; for the other formats only Text is sent.
+type Notification struct {
+	Event   string    `json:"event"`
+	Time    time.Time `json:"time"`
+	RunId   string    `json:"runId,omitempty"`
+	Account string    `json:"account,omitempty"`
+	Region  string    `json:"region,omitempty"`
+
+	// Orphaned snapshots that were not orphaned in the previous scan
+	NewSnapshots     []string `json:"newSnapshots,omitempty"`
+	NewSnapshotCount int      `json:"newSnapshotCount"`
+
+	OrphanedSnapshots int     `json:"orphanedSnapshots"`
+	OrphanedGbs       int64   `json:"orphanedGbs"`
+	MonthlyWaste      float64 `json:"monthlyWaste"`
+
+	// Outcome of Apply
+	Deleted         int     `json:"deleted"`
+	Skipped         int     `json:"skipped"`
+	Failed          int     `json:"failed"`
+	RealizedSavings float64 `json:"realizedSavings"`
+	Error           string  `json:"error,omitempty"`
+
+	// The rendered message
+	Text string `json:"text"`
+}
+
+// Webhook is a single destination for notifications.
+type Webhook struct {
+	// Used in logs and errors; defaults to the URL
+	Name string
+
+	URL string
+
+	// One of WebhookSlack, WebhookTeams, or WebhookGeneric.
+	// Default: WebhookGeneric
+	Format string
+
+	// Events sent to this webhook. Default: all events
+	Events []string
+
+	// A text/template for the message. See
+	// DefaultNotificationTemplate for an example.
+	// Default: DefaultNotificationTemplate
+	Template string
+}
+
+// NotifierInput provides configuration inputs for a new Notifier.
+type NotifierInput struct {
+	// Webhooks to notify
+	Webhooks []Webhook
+
+	// A scan only sends EventScanFindings when it finds at least
+	// this many new orphaned snapshots.
+	// Default: 1
+	NewOrphansThreshold *int
+
+	// Number of times delivery to a webhook is attempted when it
+	// fails with a network error, a 429, or a 5xx response.
+	// Default: 4
+	MaxAttempts *int
+
+	// Wait before the first retry. It doubles on each retry and a
+	// Retry-After header from the webhook takes precedence.
+	// Default: 1s
+	RetryBackoff *time.Duration
+
+	// Client used to post to webhooks.
+	// Default: an http.Client with a 10 second timeout
+	Client *http.Client
+
+	// Notifier uses log15 (https://github.com/inconshreveable/log15)
+	// as an opinioned logging framework.
+	//
+	// Logger is a required field
+	Logger *log15.Logger
+}
+
+// Notifier posts Notifications about Expeditions to webhooks.
+type Notifier struct {
+	webhooks  []Webhook
+	templates []*template.Template
+	threshold int
+	attempts  int
+	backoff   time.Duration
+	client    *http.Client
+	log       log15.Logger
+}
+
+// NewNotifier returns a Notifier for the given NotifierInput. It checks
+// the webhook formats and templates up front.
+func NewNotifier(input *NotifierInput) (n *Notifier, err error) {
+	var nt Notifier
+
+	if input.Logger == nil {
+		return &nt, errors.New("log15 logger is required")
+	}
+	nt.log = *input.Logger
+
+	DefaultNewOrphansThreshold := 1
+	if input.NewOrphansThreshold == nil {
+		input.NewOrphansThreshold = &DefaultNewOrphansThreshold
+	}
+	nt.threshold = *input.NewOrphansThreshold
+
+	DefaultMaxAttempts := 4
+	if input.MaxAttempts == nil {
+		input.MaxAttempts = &DefaultMaxAttempts
+	}
+	nt.attempts = *input.MaxAttempts
+
+	DefaultRetryBackoff := time.Second
+	if input.RetryBackoff == nil {
+		input.RetryBackoff = &DefaultRetryBackoff
+	}
+	nt.backoff = *input.RetryBackoff
+
+	if input.Client == nil {
+		input.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	nt.client = input.Client
+
+	for _, hook := range input.Webhooks {
+		if hook.URL == "" {
+			return &nt, errors.New("webhook URL is required")
+		}
+		if hook.Name == "" {
+			hook.Name = hook.URL
+		}
+		switch hook.Format {
+		case "":
+			hook.Format = WebhookGeneric
+		case WebhookSlack, WebhookTeams, WebhookGeneric:
+		default:
+			return &nt, fmt.Errorf("webhook %s: unknown format %q", hook.Name, hook.Format)
+		}
+		if hook.Template == "" {
+			hook.Template = DefaultNotificationTemplate
+		}
+		// the functions are bound to an Expedition when rendering
+		tmpl, err := template.New(hook.Name).Funcs((&Expedition{}).summaryFuncs()).Parse(hook.Template)
+		if err != nil {
+			return &nt, fmt.Errorf("webhook %s: %s", hook.Name, err)
+		}
+		nt.webhooks = append(nt.webhooks, hook)
+		nt.templates = append(nt.templates, tmpl)
+	}
+	return &nt, nil
+}
+
+// newNotification fills in the results of the Expedition.
+func newNotification(event string, exp *Expedition, runId string) *Notification {
+	summary := exp.GetSummary()
+	note := Notification{
+		Event:             event,
+		Time:              time.Now().UTC(),
+		RunId:             runId,
+		Account:           summary.Account,
+		Region:            summary.Region,
+		OrphanedSnapshots: len(summary.Snapshots),
+		OrphanedGbs:       summary.TotalGbs,
+		MonthlyWaste:      summary.Savings,
+		RealizedSavings:   summary.RealizedSavings,
+	}
+	for _, step := range exp.Plan {
+		switch step.Status {
+		case StepDeleted:
+			note.Deleted++
+		case StepSkipped:
+			note.Skipped++
+		case StepFailed:
+			note.Failed++
+		}
+	}
+	return &note
+}
+
+// NotifyScan sends EventScanFindings if the Expedition found at least
+// NewOrphansThreshold orphaned snapshots that were not orphaned in the
+// previous Expedition of the same account and region. If previous is
+// nil every orphaned snapshot is new.
+func (n *Notifier) NotifyScan(exp, previous *Expedition, runId string) (err error) {
+	note := newNotification(EventScanFindings, exp, runId)
+	note.NewSnapshots = exp.Diff(previous).NewSnapshots
+	note.NewSnapshotCount = len(note.NewSnapshots)
+	if note.NewSnapshotCount == 0 || note.NewSnapshotCount < n.threshold {
+		n.log.Debug(
+			"not enough new orphaned snapshots to notify",
+			"new", note.NewSnapshotCount, "threshold", n.threshold,
+		)
+		return nil
+	}
+	return n.send(exp, note)
+}
+
+// NotifyApply sends EventApplyFinished with the outcome of Apply. Pass
+// the error returned by Apply as applyErr.
+func (n *Notifier) NotifyApply(exp *Expedition, runId string, applyErr error) (err error) {
+	note := newNotification(EventApplyFinished, exp, runId)
+	if applyErr != nil {
+		note.Error = applyErr.Error()
+	}
+	return n.send(exp, note)
+}
+
+// Test sends EventTest to every webhook regardless of its Events so the
+// webhook configuration can be checked.
+func (n *Notifier) Test() (err error) {
+	note := Notification{Event: EventTest, Time: time.Now().UTC()}
+	return n.send(&Expedition{}, &note)
+}
+
+// send delivers the Notification to every webhook subscribed to its
+// event. A failing webhook does not stop delivery to the others.
+func (n *Notifier) send(exp *Expedition, note *Notification) (err error) {
+	var failed []string
+	for i, hook := range n.webhooks {
+		if note.Event != EventTest && len(hook.Events) > 0 && !containsString(hook.Events, note.Event) {
+			continue
+		}
+		var buf bytes.Buffer
+		tmpl, err := n.templates[i].Clone()
+		if err == nil {
+			err = tmpl.Funcs(exp.summaryFuncs()).Execute(&buf, note)
+		}
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", hook.Name, err))
+			continue
+		}
+		hookNote := *note
+		hookNote.Text = strings.TrimSpace(buf.String())
+		body, err := webhookPayload(hook.Format, &hookNote)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", hook.Name, err))
+			continue
+		}
+		err = n.post(hook, body)
+		if err != nil {
+			n.log.Error("webhook delivery failed", "webhook", hook.Name, "event", note.Event, "error", err.Error())
+			failed = append(failed, fmt.Sprintf("%s: %s", hook.Name, err))
+			continue
+		}
+		n.log.Info("sent notification", "webhook", hook.Name, "event", note.Event)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("notification failed for %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// webhookPayload builds the json body for the given format.
+func webhookPayload(format string, note *Notification) ([]byte, error) {
+	switch format {
+	case WebhookSlack:
+		return json.Marshal(map[string]string{"text": note.Text})
+	case WebhookTeams:
+		color := "2E7D32"
+		if note.Error != "" || note.Failed > 0 {
+			color = "C62828"
+		} else if note.Event == EventScanFindings {
+			color = "F9A825"
+		}
+		return json.Marshal(map[string]string{
+			"@type":      "MessageCard",
+			"@context":   "https://schema.org/extensions",
+			"summary":    "dustcollector " + note.Event,
+			"themeColor": color,
+			"title":      "dustcollector",
+			"text":       note.Text,
+		})
+	}
+	return json.Marshal(note)
+}
+
+// post sends body to the webhook, retrying network errors, 429s, and
+// 5xx responses with exponential backoff.
+func (n *Notifier) post(hook Webhook, body []byte) (err error) {
+	wait := n.backoff
+	for attempt := 1; ; attempt++ {
+		var retryAfter time.Duration
+		retryAfter, err = n.postOnce(hook.URL, body)
+		if err == nil || retryAfter < 0 || attempt >= n.attempts {
+			return err
+		}
+		if retryAfter == 0 {
+			retryAfter = wait
+		}
+		n.log.Warn(
+			"retrying webhook", "webhook", hook.Name, "attempt", attempt,
+			"wait", retryAfter.String(), "error", err.Error(),
+		)
+		time.Sleep(retryAfter)
+		wait *= 2
+	}
+}
+
+// postOnce makes a single delivery attempt. On failure retryAfter is
+// negative if the error is permanent, positive if the webhook asked for
+// a specific wait, and zero otherwise.
+func (n *Notifier) postOnce(url string, body []byte) (retryAfter time.Duration, err error) {
+	resp, err := n.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode/100 == 2 {
+		return 0, nil
+	}
+	err = fmt.Errorf("webhook returned %s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode/100 != 5 {
+		return -1, err
+	}
+	if seconds, perr := strconv.Atoi(resp.Header.Get("Retry-After")); perr == nil && seconds > 0 {
+		retryAfter = time.Duration(seconds) * time.Second
+	}
+	return retryAfter, err
+}
+
+// WebhookDelivery is a request received by a WebhookStandIn.
+type WebhookDelivery struct {
+	Time        time.Time
+	Path        string
+	ContentType string
+	Body        []byte
+	Status      int
+}
+
+// WebhookStandIn is a local http server that accepts webhook posts and
+// records them so notifications can be tested without a real Slack,
+// Teams, or other endpoint. Point a Webhook at URL plus any path.
+type WebhookStandIn struct {
+	URL        string
+	listener   net.Listener
+	server     *http.Server
+	mu         sync.Mutex
+	deliveries []WebhookDelivery
+	failFirst  int
+}
+
+// StartWebhookStandIn starts a WebhookStandIn listening on addr
+// (e.g., "127.0.0.1:0" for any free port). The first failFirst requests
+// get a 503 response so retries can be exercised.
+func StartWebhookStandIn(addr string, failFirst int) (s *WebhookStandIn, err error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s = &WebhookStandIn{
+		URL:       "http://" + listener.Addr().String(),
+		listener:  listener,
+		failFirst: failFirst,
+	}
+	s.server = &http.Server{Handler: s}
+	go s.server.Serve(listener)
+	return s, nil
+}
+
+// ServeHTTP records the request.
+func (s *WebhookStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	s.mu.Lock()
+	status := http.StatusOK
+	if s.failFirst > 0 {
+		s.failFirst--
+		status = http.StatusServiceUnavailable
+	}
+	s.deliveries = append(s.deliveries, WebhookDelivery{
+		Time:        time.Now().UTC(),
+		Path:        r.URL.Path,
+		ContentType: r.Header.Get("Content-Type"),
+		Body:        body,
+		Status:      status,
+	})
+	s.mu.Unlock()
+	w.WriteHeader(status)
+}
+
+// Deliveries returns every request received so far.
+func (s *WebhookStandIn) Deliveries() []WebhookDelivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]WebhookDelivery{}, s.deliveries...)
+}
+
+// Close shuts the WebhookStandIn down.
+func (s *WebhookStandIn) Close() error {
+	return s.server.Close()
+}
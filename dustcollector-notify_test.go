@@ -0,0 +1,236 @@
+package dustcollector
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/log15"
+)
+
+// newTestNotifier returns a Notifier for hooks that retries quickly.
+func newTestNotifier(t *testing.T, hooks []Webhook, configure func(in *NotifierInput)) *Notifier {
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	backoff := 20 * time.Millisecond
+	in := &NotifierInput{Webhooks: hooks, RetryBackoff: &backoff, Logger: &logger}
+	if configure != nil {
+		configure(in)
+	}
+	n, err := NewNotifier(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// startTestStandIn starts a WebhookStandIn that is closed with the test.
+func startTestStandIn(t *testing.T, failFirst int) *WebhookStandIn {
+	stand, err := StartWebhookStandIn("127.0.0.1:0", failFirst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { stand.Close() })
+	return stand
+}
+
+// TestNotifierRetries makes sure 503s are retried with a doubling
+// backoff until MaxAttempts.
+func TestNotifierRetries(t *testing.T) {
+	stand := startTestStandIn(t, 2)
+	n := newTestNotifier(t, []Webhook{{URL: stand.URL + "/hook"}}, nil)
+	if err := n.Test(); err != nil {
+		t.Fatal(err)
+	}
+	deliveries := stand.Deliveries()
+	if len(deliveries) != 3 {
+		t.Fatalf("got %d deliveries, want 3", len(deliveries))
+	}
+	for i, want := range []int{503, 503, 200} {
+		if deliveries[i].Status != want {
+			t.Errorf("delivery %d got status %d, want %d", i, deliveries[i].Status, want)
+		}
+	}
+	if wait := deliveries[1].Time.Sub(deliveries[0].Time); wait < 20*time.Millisecond {
+		t.Errorf("first retry after %s, want at least 20ms", wait)
+	}
+	if wait := deliveries[2].Time.Sub(deliveries[1].Time); wait < 40*time.Millisecond {
+		t.Errorf("second retry after %s, want at least 40ms", wait)
+	}
+
+	stand = startTestStandIn(t, 5)
+	attempts := 2
+	n = newTestNotifier(t, []Webhook{{Name: "flaky", URL: stand.URL}}, func(in *NotifierInput) {
+		in.MaxAttempts = &attempts
+	})
+	err := n.Test()
+	if err == nil || !strings.Contains(err.Error(), "flaky: webhook returned 503") {
+		t.Errorf("got error %v, want a 503 from flaky", err)
+	}
+	if got := len(stand.Deliveries()); got != attempts {
+		t.Errorf("got %d deliveries, want %d", got, attempts)
+	}
+}
+
+// statusServer responds with the statuses in turn, then 200, and
+// records when each request arrived.
+type statusServer struct {
+	statuses   []int
+	retryAfter string
+	mu         sync.Mutex
+	times      []time.Time
+}
+
+func (s *statusServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := http.StatusOK
+	if len(s.times) < len(s.statuses) {
+		status = s.statuses[len(s.times)]
+		if s.retryAfter != "" {
+			w.Header().Set("Retry-After", s.retryAfter)
+		}
+	}
+	s.times = append(s.times, time.Now())
+	w.WriteHeader(status)
+}
+
+// requests returns when each request arrived.
+func (s *statusServer) requests() []time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]time.Time{}, s.times...)
+}
+
+// TestNotifierRetryAfter makes sure a Retry-After header takes
+// precedence over the backoff and that 4xx responses other than 429
+// are not retried.
+func TestNotifierRetryAfter(t *testing.T) {
+	s := &statusServer{statuses: []int{http.StatusTooManyRequests}, retryAfter: "1"}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	n := newTestNotifier(t, []Webhook{{URL: ts.URL}}, nil)
+	if err := n.Test(); err != nil {
+		t.Fatal(err)
+	}
+	times := s.requests()
+	if len(times) != 2 {
+		t.Fatalf("got %d requests, want 2", len(times))
+	}
+	if wait := times[1].Sub(times[0]); wait < time.Second {
+		t.Errorf("retried after %s, want the 1s of Retry-After", wait)
+	}
+
+	for _, status := range []int{http.StatusBadRequest, http.StatusNotFound, http.StatusGone} {
+		s := &statusServer{statuses: []int{status}}
+		ts := httptest.NewServer(s)
+		n := newTestNotifier(t, []Webhook{{URL: ts.URL}}, nil)
+		err := n.Test()
+		ts.Close()
+		if err == nil {
+			t.Errorf("%d: no error", status)
+		}
+		if got := len(s.requests()); got != 1 {
+			t.Errorf("%d: got %d requests, want 1", status, got)
+		}
+	}
+}
+
+// TestNotifierPayloads checks the body sent for each webhook format.
+func TestNotifierPayloads(t *testing.T) {
+	stand := startTestStandIn(t, 0)
+	n := newTestNotifier(t, []Webhook{
+		{URL: stand.URL + "/slack", Format: WebhookSlack},
+		{URL: stand.URL + "/teams", Format: WebhookTeams},
+		{URL: stand.URL + "/generic"},
+		{URL: stand.URL + "/custom", Format: WebhookSlack, Template: "event {{.Event}}"},
+	}, nil)
+	if err := n.Test(); err != nil {
+		t.Fatal(err)
+	}
+	bodies := make(map[string]map[string]interface{})
+	for _, d := range stand.Deliveries() {
+		if d.ContentType != "application/json" {
+			t.Errorf("%s: got content type %q", d.Path, d.ContentType)
+		}
+		var body map[string]interface{}
+		if err := json.Unmarshal(d.Body, &body); err != nil {
+			t.Fatalf("%s: %s: %s", d.Path, err, d.Body)
+		}
+		bodies[d.Path] = body
+	}
+	text := catalogs[DefaultLanguage]["notify.test"]
+	if slack := bodies["/slack"]; len(slack) != 1 || slack["text"] != text {
+		t.Errorf("got Slack payload %v, want only the text %q", slack, text)
+	}
+	teams := bodies["/teams"]
+	if teams["@type"] != "MessageCard" || teams["text"] != text || teams["themeColor"] != "2E7D32" {
+		t.Errorf("got Teams payload %v", teams)
+	}
+	generic := bodies["/generic"]
+	if generic["event"] != EventTest || generic["text"] != text || generic["time"] == nil {
+		t.Errorf("got generic payload %v", generic)
+	}
+	if custom := bodies["/custom"]; custom["text"] != "event test" {
+		t.Errorf("got custom template payload %v", custom)
+	}
+}
+
+// TestNotifyScanThreshold makes sure EventScanFindings is only sent
+// when there are at least NewOrphansThreshold new orphaned snapshots
+// and only to the webhooks subscribed to it.
+func TestNotifyScanThreshold(t *testing.T) {
+	exp := newFakeExpedition(t, &fakeAWS{snaps: 10, pageSize: 100}, nil)
+	if err := exp.Start(); err != nil {
+		t.Fatal(err)
+	}
+	orphans := len(exp.Diff(nil).NewSnapshots)
+	if orphans == 0 {
+		t.Fatal("the scan found no orphaned snapshots")
+	}
+	for _, tc := range []struct {
+		name      string
+		threshold int
+		previous  *Expedition
+		sent      bool
+	}{
+		{"below threshold", orphans + 1, nil, false},
+		{"at threshold", orphans, nil, true},
+		{"nothing new", 1, exp, false},
+		{"zero threshold, nothing new", 0, exp, false},
+	} {
+		stand := startTestStandIn(t, 0)
+		threshold := tc.threshold
+		n := newTestNotifier(t, []Webhook{
+			{URL: stand.URL + "/scan", Events: []string{EventScanFindings}},
+			{URL: stand.URL + "/apply", Events: []string{EventApplyFinished}},
+		}, func(in *NotifierInput) {
+			in.NewOrphansThreshold = &threshold
+		})
+		if err := n.NotifyScan(exp, tc.previous, "run-1"); err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		deliveries := stand.Deliveries()
+		if !tc.sent {
+			if len(deliveries) != 0 {
+				t.Errorf("%s: got %d deliveries, want none", tc.name, len(deliveries))
+			}
+			continue
+		}
+		if len(deliveries) != 1 || deliveries[0].Path != "/scan" {
+			t.Fatalf("%s: got %+v, want one delivery to /scan", tc.name, deliveries)
+		}
+		var note Notification
+		if err := json.Unmarshal(deliveries[0].Body, &note); err != nil {
+			t.Fatal(err)
+		}
+		if note.Event != EventScanFindings || note.RunId != "run-1" || note.NewSnapshotCount != orphans ||
+			len(note.NewSnapshots) != orphans || note.OrphanedSnapshots != orphans {
+			t.Errorf("%s: got %+v", tc.name, note)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package dustcollector
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Sources an OwnerRule can take the owner of a Nugget from
+const (
+	OwnerFromSnapshotTag = "snapshot-tag"
+	OwnerFromVolumeTag   = "volume-tag"
+	OwnerFromAMITag      = "ami-tag"
+	OwnerFromDescription = "description"
+)
+
+// UnassignedOwner is the owner used in reports for Nuggets that no
+// OwnerRule matched.
+const UnassignedOwner = "unassigned"
+
+// OwnerRule describes one way of finding the owner of a snapshot. Rules
+// are tried in order and the first one that finds an owner wins.
+type OwnerRule struct {
+	// One of OwnerFromSnapshotTag, OwnerFromVolumeTag (only for
+	// snapshots whose volume still exists), OwnerFromAMITag (tags of
+	// the AMIs the snapshot is registered as), or OwnerFromDescription.
+	Source string
+
+	// For the tag sources, the tag keys to look for in order. Keys
+	// are matched case-insensitively.
+	TagKeys []string
+
+	// For OwnerFromDescription, a regular expression matched against
+	// the snapshot description. The first capture group, or the
+	// whole match if there is none, is the owner (e.g.,
+	// `(?i)created by (\S+)`).
+	Pattern string
+
+	re *regexp.Regexp
+}
+
+// DefaultOwnerTagKeys are the tag keys used by DefaultOwnerRules.
+var DefaultOwnerTagKeys = []string{"Owner", "Team", "CostCenter"}
+
+// DefaultOwnerRules returns the rules used when
+// ExpeditionInput.OwnerRules is not set: the DefaultOwnerTagKeys on the
+// snapshot, then on its volume, then on its AMIs.
+func DefaultOwnerRules() []OwnerRule {
+	return TagOwnerRules(DefaultOwnerTagKeys, "")
+}
+
+// TagOwnerRules returns rules looking for the tag keys on the snapshot,
+// then on its volume, then on its AMIs, followed by a rule matching
+// pattern against the description if pattern isn't empty.
+func TagOwnerRules(keys []string, pattern string) (rules []OwnerRule) {
+	rules = []OwnerRule{
+		{Source: OwnerFromSnapshotTag, TagKeys: keys},
+		{Source: OwnerFromVolumeTag, TagKeys: keys},
+		{Source: OwnerFromAMITag, TagKeys: keys},
+	}
+	if pattern != "" {
+		rules = append(rules, OwnerRule{Source: OwnerFromDescription, Pattern: pattern})
+	}
+	return rules
+}
+
+// compileOwnerRules validates the rules and compiles their patterns.
+func compileOwnerRules(rules []OwnerRule) (compiled []OwnerRule, err error) {
+	for i, rule := range rules {
+		switch rule.Source {
+		case OwnerFromSnapshotTag, OwnerFromVolumeTag, OwnerFromAMITag:
+			if len(rule.TagKeys) == 0 {
+				return nil, fmt.Errorf("owner rule %d: TagKeys are required for %s", i, rule.Source)
+			}
+		case OwnerFromDescription:
+			rule.re, err = regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("owner rule %d: %s", i, err)
+			}
+		default:
+			return nil, fmt.Errorf("owner rule %d: unknown source %q", i, rule.Source)
+		}
+		compiled = append(compiled, rule)
+	}
+	return compiled, nil
+}
+
+// tagValue returns the value of the first of keys found in tags.
+func tagValue(tags []*ec2.Tag, keys []string) string {
+	for _, key := range keys {
+		for _, tag := range tags {
+			if tag.Key != nil && tag.Value != nil && strings.EqualFold(*tag.Key, key) {
+				if v := strings.TrimSpace(*tag.Value); v != "" {
+					return v
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// owner applies the rule to the Nugget and returns the owner it finds.
+func (rule *OwnerRule) owner(nug *Nugget) string {
+	switch rule.Source {
+	case OwnerFromSnapshotTag:
+		return tagValue(nug.Snap.Tags, rule.TagKeys)
+	case OwnerFromVolumeTag:
+		return tagValue(nug.volumeTags, rule.TagKeys)
+	case OwnerFromAMITag:
+		return tagValue(nug.amiTags, rule.TagKeys)
+	case OwnerFromDescription:
+		if nug.Snap.Description == nil {
+			return ""
+		}
+		m := rule.re.FindStringSubmatch(*nug.Snap.Description)
+		if len(m) > 1 {
+			return strings.TrimSpace(m[1])
+		}
+		if len(m) == 1 {
+			return strings.TrimSpace(m[0])
+		}
+	}
+	return ""
+}
+
+// attributeOwners sets the Owner of every Nugget using the first
+// OwnerRule that finds one.
+func (exp *Expedition) attributeOwners() {
+	var countOwned int
+	for _, nug := range exp.Nuggets {
+		nug.Owner, nug.OwnerSource = "", ""
+		for i := range exp.ownerRules {
+			if owner := exp.ownerRules[i].owner(nug); owner != "" {
+				nug.Owner = owner
+				nug.OwnerSource = exp.ownerRules[i].Source
+				countOwned++
+				break
+			}
+		}
+	}
+	exp.log.Info("attributed snapshots to owners", "attributed", countOwned, "total", len(exp.Nuggets))
+}
+
+// OwnerReport is the part of the deletion plan and savings that
+// belongs to one owner.
+type OwnerReport struct {
+	// The owner, or UnassignedOwner
+	Owner string
+
+	// Summary of the owner's snapshots. Its plan holds the owner's
+	// snapshots along with the AMIs and Launch Templates/Configs
+	// blocking them, so a blocker shared by several owners shows up
+	// in each of their reports.
+	Summary *Summary
+}
+
+// GetOwnerReports splits the results of the Expedition by the Owner of
+// each Nugget, ordered by savings with the largest first.
+func (exp *Expedition) GetOwnerReports() (reports []*OwnerReport) {
+	byOwner := make(map[string][]*Nugget)
+	for _, nug := range exp.Nuggets {
+		owner := nug.Owner
+		if owner == "" {
+			owner = UnassignedOwner
+		}
+		byOwner[owner] = append(byOwner[owner], nug)
+	}
+	for owner, nuggets := range byOwner {
+		inPlan := make(map[string]bool)
+		for _, nug := range nuggets {
+			if nug.SpareReason != "" {
+				continue
+			}
+			inPlan[resourceKey(ResourceSnapshot, *nug.Snap.SnapshotId)] = true
+			for _, id := range nug.AMIIDs {
+				inPlan[resourceKey(ResourceAMI, id)] = true
+			}
+			for _, id := range nug.LCs {
+				inPlan[resourceKey(ResourceLaunchConfiguration, id)] = true
+			}
+			for _, id := range nug.LTs {
+				inPlan[resourceKey(ResourceLaunchTemplate, id)] = true
+			}
+		}
+		var plan []*PlanStep
+		for _, step := range exp.Plan {
+			if inPlan[stepKey(step)] {
+				plan = append(plan, step)
+			}
+		}
+		summary := exp.summarize(plan, nuggets)
+		summary.Owner = owner
+		reports = append(reports, &OwnerReport{Owner: owner, Summary: summary})
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		if reports[i].Summary.Savings != reports[j].Summary.Savings {
+			return reports[i].Summary.Savings > reports[j].Summary.Savings
+		}
+		return reports[i].Owner < reports[j].Owner
+	})
+	return reports
+}
+
+// ownerFilename turns an owner into a safe file name.
+func ownerFilename(owner string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9',
+			r == '.', r == '-', r == '_', r == '@':
+			return r
+		}
+		return '_'
+	}, owner)
+}
+
+// ExportOwnerReports writes one Markdown report per owner (rendered with
+// the Markdown template, see ExpeditionInput.MarkdownTemplate) to the
+// OutfileOwnerReports directory so each team can be sent its own part
+// of the plan. It also writes owners.csv with the totals per owner.
+func (exp *Expedition) ExportOwnerReports() (err error) {
+	err = os.MkdirAll(exp.outfileOwnerReports, 0755)
+	if err != nil {
+		return err
+	}
+	tmpl, err := exp.markdownTmpl()
+	if err != nil {
+		return err
+	}
+	csvfile, err := os.Create(filepath.Join(exp.outfileOwnerReports, "owners.csv"))
+	if err != nil {
+		return err
+	}
+	defer csvfile.Close()
+	csvwriter := csv.NewWriter(csvfile)
+	csvwriter.Write([]string{
+		"Owner", "Report", "Snapshots", "SnapshotsToDelete", "PlanSteps",
+		"TotalGbs", "Savings", "Spared",
+	})
+	for _, report := range exp.GetOwnerReports() {
+		name := ownerFilename(report.Owner) + ".md"
+		file, err := os.Create(filepath.Join(exp.outfileOwnerReports, name))
+		if err != nil {
+			return err
+		}
+		err = tmpl.Execute(file, report.Summary)
+		file.Close()
+		if err != nil {
+			return err
+		}
+		s := report.Summary
+		var steps int
+		for _, stage := range s.Stages {
+			steps += len(stage.Steps)
+		}
+		csvwriter.Write([]string{
+			report.Owner, name,
+			strconv.Itoa(s.SnapshotCount),
+			strconv.Itoa(len(s.Snapshots)),
+			strconv.Itoa(steps),
+			strconv.FormatInt(s.TotalGbs, 10),
+			strconv.FormatFloat(s.Savings, 'f', 2, 64),
+			strconv.Itoa(s.SparedCount),
+		})
+	}
+	csvwriter.Flush()
+	err = csvwriter.Error()
+	if err != nil {
+		return err
+	}
+	exp.log.Info("wrote owner reports to directory", "directory", exp.outfileOwnerReports)
+	return err
+}
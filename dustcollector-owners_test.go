@@ -0,0 +1,176 @@
+package dustcollector
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/inconshreveable/log15"
+)
+
+// testTags turns key, value pairs into tags.
+func testTags(pairs ...string) (tags []*ec2.Tag) {
+	for i := 0; i < len(pairs); i += 2 {
+		tags = append(tags, &ec2.Tag{Key: aws.String(pairs[i]), Value: aws.String(pairs[i+1])})
+	}
+	return tags
+}
+
+// newOwnersExpedition returns an Expedition with the given owner rules
+// and Nuggets, with a Bar for each Nugget.
+func newOwnersExpedition(t *testing.T, rules []OwnerRule, nuggets ...*Nugget) *Expedition {
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	compiled, err := compileOwnerRules(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := &Expedition{
+		log: logger, ownerRules: compiled, ebsSnapRate: 0.05, Nuggets: nuggets, stateRegion: "us-east-1",
+	}
+	for _, nug := range nuggets {
+		if nug.Snap.VolumeId == nil {
+			nug.Snap.VolumeId = aws.String("vol-" + *nug.Snap.SnapshotId)
+		}
+		exp.Bars = append(exp.Bars, &Bar{VolumeId: nug.Snap.VolumeId, Nuggets: []*Nugget{nug}, HasVol: nug.HasVol})
+	}
+	return exp
+}
+
+// TestOwnerAttribution checks the fallback from the snapshot tags to
+// the volume tags, the AMI tags, and the description.
+func TestOwnerAttribution(t *testing.T) {
+	for _, c := range []struct {
+		name        string
+		rules       []OwnerRule
+		nug         Nugget
+		owner, from string
+	}{
+		{
+			"snapshot tag wins", nil,
+			Nugget{Snap: &ec2.Snapshot{Tags: testTags("Owner", "alice")}, volumeTags: testTags("Owner", "ops")},
+			"alice", OwnerFromSnapshotTag,
+		},
+		{
+			"keys match case-insensitively", nil,
+			Nugget{Snap: &ec2.Snapshot{Tags: testTags("owner", "alice")}},
+			"alice", OwnerFromSnapshotTag,
+		},
+		{
+			"keys are tried in order", nil,
+			Nugget{Snap: &ec2.Snapshot{Tags: testTags("Team", "ops", "Owner", "alice")}},
+			"alice", OwnerFromSnapshotTag,
+		},
+		{
+			"blank snapshot tag falls back to the volume", nil,
+			Nugget{Snap: &ec2.Snapshot{Tags: testTags("Owner", " ")}, volumeTags: testTags("Team", "ops")},
+			"ops", OwnerFromVolumeTag,
+		},
+		{
+			"volume falls back to the AMI", nil,
+			Nugget{Snap: &ec2.Snapshot{Tags: testTags("Name", "x")}, amiTags: testTags("Team", "images")},
+			"images", OwnerFromAMITag,
+		},
+		{
+			"AMI falls back to the description", nil,
+			Nugget{Snap: &ec2.Snapshot{Description: aws.String("backup owner: carol nightly")}},
+			"carol", OwnerFromDescription,
+		},
+		{
+			"description without a group", []OwnerRule{{Source: OwnerFromDescription, Pattern: `team-[a-z]+`}},
+			Nugget{Snap: &ec2.Snapshot{Description: aws.String("made by team-x")}},
+			"team-x", OwnerFromDescription,
+		},
+		{
+			"nothing matches", nil,
+			Nugget{Snap: &ec2.Snapshot{Description: aws.String("nightly")}, amiTags: testTags("Name", "base")},
+			"", "",
+		},
+		{
+			"rules only use their source", []OwnerRule{{Source: OwnerFromVolumeTag, TagKeys: []string{"Owner"}}},
+			Nugget{Snap: &ec2.Snapshot{Tags: testTags("Owner", "alice")}},
+			"", "",
+		},
+	} {
+		rules := c.rules
+		if rules == nil {
+			rules = TagOwnerRules([]string{"Owner", "Team"}, `owner: (\w+)`)
+		}
+		nug := c.nug
+		nug.Snap.SnapshotId = aws.String("snap-1")
+		exp := newOwnersExpedition(t, rules, &nug)
+		exp.attributeOwners()
+		if nug.Owner != c.owner || nug.OwnerSource != c.from {
+			t.Errorf("%s: got %q from %q, want %q from %q", c.name, nug.Owner, nug.OwnerSource, c.owner, c.from)
+		}
+	}
+}
+
+// TestCompileOwnerRules checks that invalid rules are rejected.
+func TestCompileOwnerRules(t *testing.T) {
+	for _, rule := range []OwnerRule{
+		{Source: OwnerFromSnapshotTag},
+		{Source: OwnerFromDescription, Pattern: "("},
+		{Source: "instance-tag", TagKeys: []string{"Owner"}},
+	} {
+		if _, err := compileOwnerRules([]OwnerRule{rule}); err == nil {
+			t.Errorf("rule %+v was accepted", rule)
+		}
+	}
+}
+
+// TestOwnerReports checks the split of the plan and savings by owner.
+// ami-1 blocks the snapshots of alice and bob so it is in both plans.
+func TestOwnerReports(t *testing.T) {
+	snap := func(id, owner string, size int64) *ec2.Snapshot {
+		return &ec2.Snapshot{SnapshotId: aws.String(id), VolumeSize: aws.Int64(size), Tags: testTags("Owner", owner)}
+	}
+	exp := newOwnersExpedition(t, DefaultOwnerRules(),
+		&Nugget{Snap: snap("snap-1", "alice", 10), AMIIDs: []string{"ami-1"}, LTs: []string{"lt-1"}},
+		&Nugget{Snap: snap("snap-2", "bob", 20), AMIIDs: []string{"ami-1"}},
+		&Nugget{Snap: snap("snap-3", "alice", 5), HasVol: true, SpareReason: SpareHasVolume},
+		&Nugget{Snap: &ec2.Snapshot{SnapshotId: aws.String("snap-4"), VolumeSize: aws.Int64(1)}},
+	)
+	exp.attributeOwners()
+	for _, step := range []struct{ resourceType, id string }{
+		{ResourceLaunchTemplate, "lt-1"},
+		{ResourceAMI, "ami-1"},
+		{ResourceSnapshot, "snap-1"},
+		{ResourceSnapshot, "snap-2"},
+		{ResourceSnapshot, "snap-4"},
+	} {
+		exp.Plan = append(exp.Plan, &PlanStep{ResourceType: step.resourceType, ResourceId: step.id})
+	}
+
+	type report struct {
+		owner             string
+		plan              []string
+		snapshots, spared int
+		gbs               int64
+		savings           float64
+	}
+	want := []report{
+		{"bob", []string{"AMI/ami-1", "Snapshot/snap-2"}, 1, 0, 20, 1},
+		{"alice", []string{"LaunchTemplate/lt-1", "AMI/ami-1", "Snapshot/snap-1"}, 2, 1, 10, 0.5},
+		{UnassignedOwner, []string{"Snapshot/snap-4"}, 1, 0, 1, 0.05},
+	}
+	var got []report
+	for _, r := range exp.GetOwnerReports() {
+		s := r.Summary
+		if s.Owner != r.Owner {
+			t.Errorf("report for %q has summary owner %q", r.Owner, s.Owner)
+		}
+		var plan []string
+		for _, stage := range s.Stages {
+			for _, step := range stage.Steps {
+				plan = append(plan, stepKey(step))
+			}
+		}
+		got = append(got, report{r.Owner, plan, s.SnapshotCount, s.SparedCount, s.TotalGbs, math.Round(s.Savings*100) / 100})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got reports %+v, want %+v", got, want)
+	}
+}
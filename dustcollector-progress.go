@@ -0,0 +1,178 @@
+package dustcollector
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Progress is a point in time view of an Expedition as seen by a
+// ProgressReporter.
+type Progress struct {
+	Phase        string
+	Started      time.Time
+	PhaseStarted time.Time
+
+	Pages          int
+	TotalSnapshots int
+	InScope        int
+
+	BatchesDone   int
+	BatchesQueued int
+
+	StepsDone  int
+	StepsTotal int
+
+	// Estimated time left in the current phase, zero if unknown.
+	// While snapshots are fetched it covers the volume batches
+	// queued so far, during Apply the remaining steps.
+	ETA time.Duration
+}
+
+// Percent returns how much of the known work of the current phase is
+// done, or -1 if it is unknown.
+func (p Progress) Percent() float64 {
+	switch {
+	case p.Phase == PhaseSnapshots && p.BatchesQueued > 0:
+		return 100 * float64(p.BatchesDone) / float64(p.BatchesQueued)
+	case p.Phase == PhaseApply && p.StepsTotal > 0:
+		return 100 * float64(p.StepsDone) / float64(p.StepsTotal)
+	}
+	return -1
+}
+
+// String renders the Progress as a single status line.
+func (p Progress) String() string {
+	var status string
+	switch p.Phase {
+	case PhaseSnapshots:
+		status = fmt.Sprintf(
+			"%d pages, %d snapshots, %d in scope, volume batches %d/%d",
+			p.Pages, p.TotalSnapshots, p.InScope, p.BatchesDone, p.BatchesQueued,
+		)
+	case PhaseApply:
+		status = fmt.Sprintf("steps %d/%d", p.StepsDone, p.StepsTotal)
+	case PhaseDone:
+		status = fmt.Sprintf("finished in %s", time.Since(p.Started).Round(time.Second))
+	default:
+		status = fmt.Sprintf("%s elapsed", time.Since(p.PhaseStarted).Round(time.Second))
+	}
+	if pct := p.Percent(); pct >= 0 {
+		status += fmt.Sprintf(" (%.0f%%)", pct)
+	}
+	if p.ETA > 0 {
+		status += fmt.Sprintf(", eta %s", p.ETA.Round(time.Second))
+	}
+	return p.Phase + ": " + status
+}
+
+// ProgressReporter tracks the progress of an Expedition through its
+// Hooks and estimates the time left from the rate at which work has
+// been completed so far in the phase. Set ExpeditionInput.Hooks to the
+// result of its Hooks method.
+type ProgressReporter struct {
+	mu       sync.Mutex
+	progress Progress
+	w        io.Writer
+	interval time.Duration
+	lastLine time.Time
+	next     Hooks
+}
+
+// NewProgressReporter returns a ProgressReporter that writes a status
+// line to w at most once every interval and at each phase change. w may
+// be nil to only use Progress.
+func NewProgressReporter(w io.Writer, interval time.Duration) *ProgressReporter {
+	return &ProgressReporter{w: w, interval: interval}
+}
+
+// Progress returns the current Progress.
+func (r *ProgressReporter) Progress() Progress {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p := r.progress
+	p.ETA = r.eta()
+	return p
+}
+
+// eta estimates the time left in the current phase.
+func (r *ProgressReporter) eta() time.Duration {
+	p := r.progress
+	var done, total int
+	switch p.Phase {
+	case PhaseSnapshots:
+		done, total = p.BatchesDone, p.BatchesQueued
+	case PhaseApply:
+		done, total = p.StepsDone, p.StepsTotal
+	}
+	if done == 0 || total <= done {
+		return 0
+	}
+	elapsed := time.Since(p.PhaseStarted)
+	return time.Duration(float64(elapsed) / float64(done) * float64(total-done))
+}
+
+// Hooks returns the Hooks that feed the ProgressReporter. Any hooks in
+// next are called after the reporter's so both can be used together.
+func (r *ProgressReporter) Hooks(next Hooks) Hooks {
+	r.next = next
+	return Hooks{
+		OnPhaseChange: func(e PhaseEvent) {
+			r.update(true, func(p *Progress) {
+				if p.Started.IsZero() {
+					p.Started = e.Time
+				}
+				p.Phase = e.Phase
+				p.PhaseStarted = e.Time
+			})
+			if r.next.OnPhaseChange != nil {
+				r.next.OnPhaseChange(e)
+			}
+		},
+		OnPageFetched: func(e PageEvent) {
+			r.update(false, func(p *Progress) {
+				p.Pages = e.Page
+				p.TotalSnapshots = e.TotalSnapshots
+				p.InScope = e.TotalInScope
+			})
+			if r.next.OnPageFetched != nil {
+				r.next.OnPageFetched(e)
+			}
+		},
+		OnVolumeBatchDone: func(e VolumeBatchEvent) {
+			r.update(false, func(p *Progress) {
+				p.BatchesDone = e.BatchesDone
+				p.BatchesQueued = e.BatchesQueued
+			})
+			if r.next.OnVolumeBatchDone != nil {
+				r.next.OnVolumeBatchDone(e)
+			}
+		},
+		OnNuggetBuilt: r.next.OnNuggetBuilt,
+		OnPlanStep: func(e PlanStepEvent) {
+			r.update(false, func(p *Progress) {
+				p.StepsDone = e.Done
+				p.StepsTotal = e.Total
+			})
+			if r.next.OnPlanStep != nil {
+				r.next.OnPlanStep(e)
+			}
+		},
+	}
+}
+
+// update applies change to the Progress and writes a status line if
+// force is set or the interval has passed.
+func (r *ProgressReporter) update(force bool, change func(p *Progress)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	change(&r.progress)
+	if r.w == nil || (!force && time.Since(r.lastLine) < r.interval) {
+		return
+	}
+	r.lastLine = time.Now()
+	p := r.progress
+	p.ETA = r.eta()
+	fmt.Fprintln(r.w, p.String())
+}
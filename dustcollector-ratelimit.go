@@ -0,0 +1,229 @@
+package dustcollector
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// DefaultRateLimits are the budgets, in requests per second, used for
+// the API families that ExpeditionInput.RateLimits doesn't set. A family
+// is the service name and either "read" for Describe, Get, and List
+// operations or "write" for the rest, e.g. "ec2:read". They are kept
+// well below the documented EC2 and AutoScaling throttling limits so a
+// scan leaves room for the other tools using the account.
+var DefaultRateLimits = map[string]float64{
+	"ec2:read":          20,
+	"ec2:write":         5,
+	"autoscaling:read":  10,
+	"autoscaling:write": 5,
+	"sts:read":          10,
+}
+
+// DefaultRateLimit is the budget of API families that are neither in
+// ExpeditionInput.RateLimits nor in DefaultRateLimits.
+const DefaultRateLimit = 10
+
+// minRate is the lowest rate a throttled family is slowed down to.
+const minRate = 0.5
+
+// apiFamily returns the rate limit family of an operation.
+func apiFamily(service, operation string) string {
+	for _, prefix := range []string{"Describe", "Get", "List"} {
+		if strings.HasPrefix(operation, prefix) {
+			return service + ":read"
+		}
+	}
+	return service + ":write"
+}
+
+// ThrottleStats describes the client-side rate limiting and the
+// throttling responses of one API family during an Expedition.
+type ThrottleStats struct {
+	Family string `json:"family"`
+
+	// Configured requests per second and the rate the family was
+	// slowed down to after throttling responses
+	Budget float64 `json:"budget"`
+	Rate   float64 `json:"rate"`
+
+	// Completed requests, retried attempts, and attempts that AWS
+	// throttled
+	Requests  int64 `json:"requests"`
+	Retries   int64 `json:"retries"`
+	Throttled int64 `json:"throttled"`
+
+	// Time spent waiting for the rate limiter, summed over the
+	// requests made at the same time
+	WaitSeconds float64 `json:"waitSeconds"`
+}
+
+// tokenBucket limits the rate of one API family. The rate is cut in
+// half whenever AWS throttles a request and grows back towards the
+// budget with every request that succeeds.
+type tokenBucket struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	stats  ThrottleStats
+}
+
+// reserve takes a token and returns how long to wait before using it.
+// The bucket holds up to one second's worth of tokens.
+func (b *tokenBucket) reserve(now time.Time) (wait time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	rate := b.stats.Rate
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * rate
+	}
+	b.tokens = math.Min(b.tokens, math.Max(rate, 1))
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	wait = time.Duration(-b.tokens / rate * float64(time.Second))
+	b.stats.WaitSeconds += wait.Seconds()
+	return wait
+}
+
+func (b *tokenBucket) throttled() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stats.Throttled++
+	b.stats.Rate = math.Max(minRate, b.stats.Rate/2)
+}
+
+func (b *tokenBucket) completed(failed bool, retries int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stats.Requests++
+	b.stats.Retries += int64(retries)
+	if !failed {
+		b.stats.Rate = math.Min(b.stats.Budget, b.stats.Rate+b.stats.Budget/20)
+	}
+}
+
+// rateLimiter holds a tokenBucket for every API family used so far.
+type rateLimiter struct {
+	mu      sync.Mutex
+	budgets map[string]float64
+	buckets map[string]*tokenBucket
+}
+
+// bucket returns the bucket of the family of the request, or nil if
+// the family isn't limited.
+func (l *rateLimiter) bucket(r *request.Request) *tokenBucket {
+	family := apiFamily(r.ClientInfo.ServiceName, r.Operation.Name)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[family]
+	if ok {
+		return b
+	}
+	budget, ok := l.budgets[family]
+	if !ok {
+		budget = DefaultRateLimit
+	}
+	if budget > 0 {
+		b = &tokenBucket{
+			tokens: math.Max(budget, 1),
+			stats:  ThrottleStats{Family: family, Budget: budget, Rate: budget},
+		}
+	}
+	l.buckets[family] = b
+	return b
+}
+
+// limitSession replaces the Expedition session with a copy whose
+// requests go through the rate limiter and are retried with jittered
+// exponential backoff up to maxRetries times.
+func (exp *Expedition) limitSession(budgets map[string]float64, maxRetries int) {
+	exp.limiter.budgets = make(map[string]float64)
+	for family, budget := range DefaultRateLimits {
+		exp.limiter.budgets[family] = budget
+	}
+	for family, budget := range budgets {
+		exp.limiter.budgets[family] = budget
+	}
+	exp.limiter.buckets = make(map[string]*tokenBucket)
+	exp.session = exp.session.Copy(&aws.Config{
+		Retryer: client.DefaultRetryer{
+			NumMaxRetries:    maxRetries,
+			MinRetryDelay:    client.DefaultRetryerMinRetryDelay,
+			MinThrottleDelay: client.DefaultRetryerMinThrottleDelay,
+			MaxRetryDelay:    20 * time.Second,
+			MaxThrottleDelay: 20 * time.Second,
+		},
+	})
+	// every attempt, including retries, waits for a token
+	exp.session.Handlers.Sign.PushFrontNamed(request.NamedHandler{
+		Name: "dustcollector.rateLimit",
+		Fn: func(r *request.Request) {
+			b := exp.limiter.bucket(r)
+			if b == nil {
+				return
+			}
+			wait := b.reserve(time.Now())
+			if wait == 0 {
+				return
+			}
+			if err := aws.SleepWithContext(r.Context(), wait); err != nil {
+				r.Error = awserr.New(request.CanceledErrorCode, "request context canceled", err)
+			}
+		},
+	})
+	exp.session.Handlers.Retry.PushBackNamed(request.NamedHandler{
+		Name: "dustcollector.throttled",
+		Fn: func(r *request.Request) {
+			if b := exp.limiter.bucket(r); b != nil && r.IsErrorThrottle() {
+				b.throttled()
+			}
+		},
+	})
+	exp.session.Handlers.Complete.PushBackNamed(request.NamedHandler{
+		Name: "dustcollector.throttleStats",
+		Fn: func(r *request.Request) {
+			if b := exp.limiter.bucket(r); b != nil {
+				b.completed(r.Error != nil, r.RetryCount)
+			}
+		},
+	})
+}
+
+// GetThrottleStats returns the rate limiting and throttling statistics
+// of every API family used by the Expedition, sorted by family.
+func (exp *Expedition) GetThrottleStats() (stats []ThrottleStats) {
+	exp.limiter.mu.Lock()
+	defer exp.limiter.mu.Unlock()
+	for _, b := range exp.limiter.buckets {
+		if b == nil {
+			continue
+		}
+		b.mu.Lock()
+		stats = append(stats, b.stats)
+		b.mu.Unlock()
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Family < stats[j].Family
+	})
+	return stats
+}
+
+// setThrottleStats restores the statistics from a saved state.
+func (exp *Expedition) setThrottleStats(stats []ThrottleStats) {
+	exp.limiter.mu.Lock()
+	defer exp.limiter.mu.Unlock()
+	exp.limiter.buckets = make(map[string]*tokenBucket)
+	for _, s := range stats {
+		exp.limiter.buckets[s.Family] = &tokenBucket{stats: s}
+	}
+}
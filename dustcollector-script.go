@@ -0,0 +1,186 @@
+package dustcollector
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// scriptHeader sets up argument parsing and the helper functions used by
+// every step of the generated script. It is formatted with the default
+// region.
+const scriptHeader = `set -euo pipefail
+
+REGION=%s
+PROFILE="${AWS_PROFILE:-}"
+DRY_RUN=0
+
+usage() {
+  echo "usage: $0 [--region REGION] [--profile PROFILE] [--dry-run]"
+}
+
+while [ $# -gt 0 ]; do
+  case "$1" in
+    --region) REGION="$2"; shift 2 ;;
+    --profile) PROFILE="$2"; shift 2 ;;
+    --dry-run) DRY_RUN=1; shift ;;
+    -h|--help) usage; exit 0 ;;
+    *) usage >&2; exit 1 ;;
+  esac
+done
+
+AWS=(aws --region "$REGION")
+if [ -n "$PROFILE" ]; then
+  AWS+=(--profile "$PROFILE")
+fi
+
+# run prints the command and executes it unless --dry-run was given
+run() {
+  echo "+ $*"
+  if [ "$DRY_RUN" -eq 0 ]; then
+    "$@"
+  fi
+}
+
+# exists succeeds if the describe command passed to it counts at least
+# one resource so that re-running the script skips completed deletions.
+# Only a not found error means the resource is gone; any other error
+# (e.g. throttling or missing permissions) stops the script.
+exists() {
+  local count errfile
+  errfile=$(mktemp)
+  if count=$("$@" --output text 2>"$errfile"); then
+    rm -f "$errfile"
+    [ -n "$count" ] && [ "$count" != "0" ] && [ "$count" != "None" ]
+    return
+  fi
+  if grep -qE '\(Invalid[A-Za-z]+\.NotFound(Exception)?\)' "$errfile"; then
+    rm -f "$errfile"
+    return 1
+  fi
+  cat "$errfile" >&2
+  rm -f "$errfile"
+  echo "unable to check whether the resource exists, aborting" >&2
+  exit 1
+}
+`
+
+// scriptCommands holds the describe and delete aws cli commands for
+// each resource type in the plan. The resource ID is appended to both.
+var scriptCommands = map[string]struct {
+	describe string
+	query    string
+	delete   string
+}{
+	ResourceLaunchTemplate: {
+		describe: "ec2 describe-launch-templates --launch-template-names",
+		query:    "length(LaunchTemplates)",
+		delete:   "ec2 delete-launch-template --launch-template-name",
+	},
+	ResourceLaunchConfiguration: {
+		describe: "autoscaling describe-launch-configurations --launch-configuration-names",
+		query:    "length(LaunchConfigurations)",
+		delete:   "autoscaling delete-launch-configuration --launch-configuration-name",
+	},
+	ResourceAMI: {
+		describe: "ec2 describe-images --image-ids",
+		query:    "length(Images)",
+		delete:   "ec2 deregister-image --image-id",
+	},
+	ResourceSnapshot: {
+		describe: "ec2 describe-snapshots --snapshot-ids",
+		query:    "length(Snapshots)",
+		delete:   "ec2 delete-snapshot --snapshot-id",
+	},
+}
+
+// shellQuote wraps s in single quotes so it is passed to the shell
+// as a single literal word.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// shellComment turns s into a single line that is safe to use in
+// a shell comment.
+func shellComment(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// WriteScript renders the deletion plan to w as an ordered, idempotent
+// bash script of aws cli commands. Each resource is checked for existence
+// before it is deleted so the script can safely be run more than once.
+func (exp *Expedition) WriteScript(w io.Writer) (err error) {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "#!/usr/bin/env bash")
+	fmt.Fprintf(
+		bw, "# Deletion plan generated by dustcollector on %s for account %s.\n",
+		time.Now().UTC().Format("2006-01-02"), exp.account,
+	)
+	fmt.Fprintf(
+		bw, "# Snapshots created before %s with no EBS volume that are not\n"+
+			"# used by any autoscaling group or shared to another account are\n"+
+			"# removed along with the AMIs and Launch Templates/Configs blocking\n"+
+			"# their deletion. Pass --dry-run to print commands without running them.\n\n",
+		exp.dateFilter,
+	)
+	for _, t := range exp.Truncations {
+		fmt.Fprintf(
+			bw, "# WARNING: the %s listing was cut short after %d pages (%d items).\n",
+			t.Listing, t.Pages, t.Items,
+		)
+	}
+	if exp.associationsTruncated() {
+		fmt.Fprint(bw, "# Snapshots below may be used by resources that were not listed.\n\n")
+	}
+	fmt.Fprintf(bw, scriptHeader, shellQuote(exp.region()))
+	var lastType string
+	for _, step := range exp.Plan {
+		cmds, ok := scriptCommands[step.ResourceType]
+		if !ok {
+			return fmt.Errorf("unknown resource type %q", step.ResourceType)
+		}
+		if step.ResourceType != lastType {
+			fmt.Fprintf(bw, "\n### %ss\n", step.ResourceType)
+			lastType = step.ResourceType
+		}
+		id := shellQuote(step.ResourceId)
+		fmt.Fprintf(bw, "\n# %s %s", step.ResourceType, shellComment(step.ResourceId))
+		if step.Justification != "" {
+			fmt.Fprintf(bw, ": %s", shellComment(step.Justification))
+		}
+		fmt.Fprintln(bw)
+		fmt.Fprintf(
+			bw, "if exists \"${AWS[@]}\" %s %s --query %s; then\n",
+			cmds.describe, id, shellQuote(cmds.query),
+		)
+		fmt.Fprintf(bw, "  run \"${AWS[@]}\" %s %s\n", cmds.delete, id)
+		fmt.Fprintln(bw, "else")
+		fmt.Fprintf(
+			bw, "  echo %s\n",
+			shellQuote(step.ResourceType+" "+step.ResourceId+" already deleted, skipping"),
+		)
+		fmt.Fprintln(bw, "fi")
+	}
+	return bw.Flush()
+}
+
+// ExportScript takes the current deletion plan and writes it to
+// outfile as an executable bash script that runs the plan using
+// the aws cli. The script accepts --region, --profile, and --dry-run
+// flags.
+func (exp *Expedition) ExportScript() (err error) {
+	file, err := os.OpenFile(exp.outfileScript, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	err = exp.WriteScript(file)
+	if err != nil {
+		return err
+	}
+	exp.log.Info("wrote deletion script to file", "filename", exp.outfileScript)
+	return err
+}
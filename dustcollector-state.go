@@ -0,0 +1,117 @@
+package dustcollector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// stateVersion is bumped whenever the state file format changes in a
+// way that older versions of this package can't read.
+const stateVersion = 1
+
+// expeditionState is the json document written by ExportState. Bars,
+// recommendations, and the *ToDelete slices are rebuilt from the Nuggets
+// on load.
+type expeditionState struct {
+	Version     int              `json:"version"`
+	Account     string           `json:"account"`
+	ScanTime    time.Time        `json:"scanTime"`
+	ScanSeconds float64          `json:"scanSeconds"`
+	Region      string           `json:"region"`
+	APICalls    []APICallCount   `json:"apiCalls"`
+	Throttling  []ThrottleStats  `json:"throttling"`
+	Truncations []Truncation     `json:"truncations,omitempty"`
+	DateFilter  string           `json:"dateFilter"`
+	EbsSnapRate float64          `json:"ebsSnapRate"`
+	Nuggets     []*Nugget        `json:"nuggets"`
+	Plan        []*PlanStep      `json:"plan"`
+	LtVersions  map[string]int64 `json:"ltVersions"`
+}
+
+// ExportState saves the results of the Expedition to outfile as json
+// so they can be loaded later with LoadState.
+func (exp *Expedition) ExportState() (err error) {
+	state := expeditionState{
+		Version:     stateVersion,
+		Account:     exp.account,
+		ScanTime:    exp.scanTime,
+		ScanSeconds: exp.scanDuration.Seconds(),
+		Region:      exp.region(),
+		APICalls:    exp.GetAPICalls(),
+		Throttling:  exp.GetThrottleStats(),
+		Truncations: exp.Truncations,
+		DateFilter:  exp.dateFilter,
+		EbsSnapRate: exp.ebsSnapRate,
+		Nuggets:     exp.Nuggets,
+		Plan:        exp.Plan,
+		LtVersions:  exp.ltVersions,
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(exp.outfileState, data, 0644)
+	if err != nil {
+		return err
+	}
+	exp.log.Info("wrote state to file", "filename", exp.outfileState)
+	return err
+}
+
+// StatePath returns the file ExportState writes to, see
+// ExpeditionInput.OutfileState.
+func (exp *Expedition) StatePath() string {
+	return exp.outfileState
+}
+
+// LoadState populates the Expedition from a state file written by
+// ExportState instead of scanning the account with Start. After it
+// completes the data can be exported just like after Start and the
+// saved plan, including the status of any applied steps, is restored.
+func (exp *Expedition) LoadState(filename string) (err error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var state expeditionState
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return fmt.Errorf("parsing state %s: %s", filename, err)
+	}
+	if state.Version != stateVersion {
+		return fmt.Errorf("unsupported state version %d in %s", state.Version, filename)
+	}
+	exp.account = state.Account
+	exp.scanTime = state.ScanTime
+	exp.scanDuration = time.Duration(state.ScanSeconds * float64(time.Second))
+	exp.stateRegion = state.Region
+	exp.setAPICalls(state.APICalls)
+	exp.setThrottleStats(state.Throttling)
+	exp.Truncations = state.Truncations
+	exp.Truncated = len(state.Truncations) > 0
+	exp.dateFilter = state.DateFilter
+	exp.ebsSnapRate = state.EbsSnapRate
+	exp.ltVersions = state.LtVersions
+	err = exp.setDateFilter(exp.dateFilter)
+	if err != nil {
+		return err
+	}
+	exp.Nuggets = state.Nuggets
+	exp.Bars = nil
+	exp.LtsToDelete = nil
+	exp.LcsToDelete = nil
+	exp.AmiToDelete = nil
+	exp.SnapToDelete = nil
+	exp.addBars()
+	err = exp.setRecommendations()
+	if err != nil {
+		return err
+	}
+	if state.Plan != nil {
+		exp.Plan = state.Plan
+	}
+	exp.log.Info("loaded state from file", "filename", filename, "nuggets", len(exp.Nuggets))
+	return err
+}
@@ -0,0 +1,243 @@
+package dustcollector
+
+import (
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Summary holds the results of an Expedition in a form that is easy to
+// use from a text/template. It is the data passed to the Markdown
+// template (see ExpeditionInput.MarkdownTemplate).
+type Summary struct {
+	// Account number that was analyzed
+	Account string
+
+	// AWS region that was analyzed
+	Region string
+
+	// Set when the Summary only covers the snapshots of one owner
+	// (see GetOwnerReports)
+	Owner string
+
+	// Snapshots created on or after DateFilter were ignored
+	DateFilter string
+
+	// When the account was scanned
+	ScanTime time.Time
+
+	// EBS snapshot storage rate per GB-month used for the savings
+	Rate float64
+
+	// Number of snapshots created before DateFilter
+	SnapshotCount int
+
+	// Combined volume size of the Bars in the plan and the
+	// estimated monthly savings at Rate
+	TotalGbs int64
+	Savings  float64
+
+	// Number of snapshots Apply has deleted so far, the combined
+	// volume size of their Bars, and the monthly savings realized
+	DeletedSnapshots int
+	DeletedGbs       int64
+	RealizedSavings  float64
+
+	// Plan steps for each resource type in deletion order
+	LaunchTemplates      []*PlanStep
+	LaunchConfigurations []*PlanStep
+	AMIs                 []*PlanStep
+	Snapshots            []*PlanStep
+
+	// The non-empty stages of the plan in deletion order
+	Stages []*SummaryStage
+
+	// Snapshots left out of the plan grouped by Nugget.SpareReason
+	Spared      []*SparedGroup
+	SparedCount int
+
+	// Number of spared snapshots whose volume still exists, number
+	// spared because they are used by an ASG or shared, number whose
+	// AMI was launched within ExpeditionInput.SpareLaunchedWithinDays,
+	// and number exempted by ExpeditionInput.ExemptSnapshots or
+	// ExemptTags
+	SparedHasVolume        int
+	SparedInUse            int
+	SparedRecentlyLaunched int
+	SparedExempt           int
+
+	// Client-side rate limiting and AWS throttling during the scan
+	// for each API family, and the total number of throttled
+	// attempts
+	Throttling []ThrottleStats
+	Throttled  int64
+
+	// Listings cut short by MaxPages or MaxSnapshots, and whether
+	// any listing other than the snapshots was, in which case
+	// snapshots in the plan may still be in use
+	Truncated             bool
+	Truncations           []Truncation
+	AssociationsTruncated bool
+}
+
+// SummaryStage is the set of plan steps for one resource type.
+type SummaryStage struct {
+	ResourceType string
+	Steps        []*PlanStep
+
+	// Where the stage falls in the plan: "only", "first", "then",
+	// or "finally"
+	Position string
+}
+
+// SparedGroup is the set of Nuggets left out of the plan for the
+// same reason.
+type SparedGroup struct {
+	Reason  string
+	Nuggets []*Nugget
+}
+
+// spareReasonKeys maps each Nugget.SpareReason to its catalog message.
+var spareReasonKeys = map[string]string{
+	SpareHasVolume:        "reason.volume",
+	SpareInASG:            "reason.asg",
+	SpareShared:           "reason.shared",
+	SpareRecentlyLaunched: "reason.launched",
+	SpareExempt:           "reason.exempt",
+}
+
+// GetSummary gathers the results of the Expedition into a Summary.
+func (exp *Expedition) GetSummary() *Summary {
+	return exp.summarize(exp.Plan, exp.Nuggets)
+}
+
+// summarize builds a Summary of the given plan steps and Nuggets, which
+// may be a subset of the Expedition's.
+func (exp *Expedition) summarize(plan []*PlanStep, nuggets []*Nugget) *Summary {
+	s := Summary{
+		Account:       exp.account,
+		Region:        exp.region(),
+		DateFilter:    exp.dateFilter,
+		ScanTime:      exp.scanTime,
+		Rate:          exp.ebsSnapRate,
+		SnapshotCount: len(nuggets),
+		Throttling:    exp.GetThrottleStats(),
+
+		Truncated:             exp.Truncated,
+		Truncations:           exp.Truncations,
+		AssociationsTruncated: exp.associationsTruncated(),
+	}
+	for _, t := range s.Throttling {
+		s.Throttled += t.Throttled
+	}
+	stages := make(map[string]*SummaryStage)
+	var snapshots, deleted []string
+	for _, step := range plan {
+		stage, ok := stages[step.ResourceType]
+		if !ok {
+			stage = &SummaryStage{ResourceType: step.ResourceType}
+			stages[step.ResourceType] = stage
+			s.Stages = append(s.Stages, stage)
+		}
+		stage.Steps = append(stage.Steps, step)
+		switch step.ResourceType {
+		case ResourceLaunchTemplate:
+			s.LaunchTemplates = append(s.LaunchTemplates, step)
+		case ResourceLaunchConfiguration:
+			s.LaunchConfigurations = append(s.LaunchConfigurations, step)
+		case ResourceAMI:
+			s.AMIs = append(s.AMIs, step)
+		case ResourceSnapshot:
+			s.Snapshots = append(s.Snapshots, step)
+			snapshots = append(snapshots, step.ResourceId)
+			if step.Status == StepDeleted {
+				s.DeletedSnapshots++
+				deleted = append(deleted, step.ResourceId)
+			}
+		}
+	}
+	_, s.TotalGbs = exp.barsWithSnapshots(snapshots)
+	s.Savings = float64(s.TotalGbs) * exp.ebsSnapRate
+	_, s.DeletedGbs = exp.barsWithSnapshots(deleted)
+	s.RealizedSavings = float64(s.DeletedGbs) * exp.ebsSnapRate
+	groups := make(map[string]*SparedGroup)
+	for _, nug := range nuggets {
+		if nug.SpareReason == "" {
+			continue
+		}
+		s.SparedCount++
+		g, ok := groups[nug.SpareReason]
+		if !ok {
+			g = &SparedGroup{Reason: nug.SpareReason}
+			groups[nug.SpareReason] = g
+			s.Spared = append(s.Spared, g)
+		}
+		g.Nuggets = append(g.Nuggets, nug)
+		switch nug.SpareReason {
+		case SpareHasVolume:
+			s.SparedHasVolume++
+		case SpareRecentlyLaunched:
+			s.SparedRecentlyLaunched++
+		case SpareExempt:
+			s.SparedExempt++
+		default:
+			s.SparedInUse++
+		}
+	}
+	for i, stage := range s.Stages {
+		switch {
+		case len(s.Stages) == 1:
+			stage.Position = "only"
+		case i == 0:
+			stage.Position = "first"
+		case i == len(s.Stages)-1:
+			stage.Position = "finally"
+		default:
+			stage.Position = "then"
+		}
+	}
+	return &s
+}
+
+// summaryFuncs are the functions available to summary templates in
+// addition to the text/template builtins:
+//
+//	t      formats the message with the given key from the message
+//	       catalog of the Expedition language with the remaining args
+//	join   joins a string slice with ", "
+//	date   formats a *time.Time or time.Time as YYYY-MM-DD
+//	md     escapes a string for use in a Markdown table cell
+//	inc    adds one to an int, handy for numbering from a range index
+//	reason translates a Nugget.SpareReason with the message catalog
+func (exp *Expedition) summaryFuncs() template.FuncMap {
+	return template.FuncMap{
+		"t": exp.message,
+		"reason": func(reason string) string {
+			if key, ok := spareReasonKeys[reason]; ok {
+				return exp.message(key)
+			}
+			return reason
+		},
+		"inc": func(i int) int {
+			return i + 1
+		},
+		"join": func(s []string) string {
+			return strings.Join(dedupeString(s), ", ")
+		},
+		"date": func(t interface{}) string {
+			switch t := t.(type) {
+			case *time.Time:
+				if t != nil {
+					return t.Format("2006-01-02")
+				}
+			case time.Time:
+				return t.Format("2006-01-02")
+			}
+			return ""
+		},
+		"md": func(s string) string {
+			s = strings.Replace(s, "|", `\|`, -1)
+			return strings.Join(strings.Fields(s), " ")
+		},
+	}
+}
@@ -0,0 +1,407 @@
+package dustcollector
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// tomlToJSON converts a TOML config to JSON so that it is decoded and
+// validated exactly like a JSON config. Only the subset of TOML needed
+// to write a Config is supported: [tables], [[arrays of tables]], bare,
+// quoted, and dotted keys, strings, numbers, booleans, arrays, and
+// inline tables. Dates are kept as strings; multi-line strings are
+// rejected.
+func tomlToJSON(data []byte) ([]byte, error) {
+	p := tomlParser{s: strings.Replace(string(data), "\r\n", "\n", -1), line: 1}
+	root, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(root)
+}
+
+type tomlParser struct {
+	s    string
+	pos  int
+	line int
+}
+
+func (p *tomlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: "+format, append([]interface{}{p.line}, args...)...)
+}
+
+// skip skips spaces and tabs, and newlines and comments too if
+// newlines is set.
+func (p *tomlParser) skip(newlines bool) {
+	for p.pos < len(p.s) {
+		switch c := p.s[p.pos]; {
+		case c == ' ' || c == '\t':
+			p.pos++
+		case c == '#':
+			for p.pos < len(p.s) && p.s[p.pos] != '\n' {
+				p.pos++
+			}
+		case c == '\n' && newlines:
+			p.pos++
+			p.line++
+		default:
+			return
+		}
+	}
+}
+
+// endLine expects the rest of the line to be empty or a comment.
+func (p *tomlParser) endLine() error {
+	p.skip(false)
+	if p.pos < len(p.s) && p.s[p.pos] != '\n' {
+		return p.errorf("unexpected %q at the end of the line", p.rest())
+	}
+	return nil
+}
+
+// rest returns the rest of the current line for error messages.
+func (p *tomlParser) rest() string {
+	end := strings.IndexByte(p.s[p.pos:], '\n')
+	if end < 0 {
+		return p.s[p.pos:]
+	}
+	return p.s[p.pos : p.pos+end]
+}
+
+func (p *tomlParser) parse() (map[string]interface{}, error) {
+	root := map[string]interface{}{}
+	current := root
+	// tables defined by a [header] so they can't be defined twice
+	defined := make(map[string]bool)
+	for {
+		p.skip(true)
+		if p.pos >= len(p.s) {
+			return root, nil
+		}
+		if p.s[p.pos] != '[' {
+			err := p.parseKeyValue(current)
+			if err != nil {
+				return nil, err
+			}
+			err = p.endLine()
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		array := strings.HasPrefix(p.s[p.pos:], "[[")
+		if array {
+			p.pos += 2
+		} else {
+			p.pos++
+		}
+		path, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		closing := "]"
+		if array {
+			closing = "]]"
+		}
+		p.skip(false)
+		if !strings.HasPrefix(p.s[p.pos:], closing) {
+			return nil, p.errorf("expected %q after table name", closing)
+		}
+		p.pos += len(closing)
+		name := strings.Join(path, ".")
+		parent, err := p.table(root, path[:len(path)-1])
+		if err != nil {
+			return nil, err
+		}
+		last := path[len(path)-1]
+		if array {
+			tables, ok := parent[last].([]interface{})
+			if _, exists := parent[last]; exists && !ok {
+				return nil, p.errorf("%s is not an array of tables", name)
+			}
+			current = map[string]interface{}{}
+			parent[last] = append(tables, current)
+		} else {
+			if defined[name] {
+				return nil, p.errorf("table %s is defined twice", name)
+			}
+			defined[name] = true
+			current, err = p.table(root, path)
+			if err != nil {
+				return nil, err
+			}
+		}
+		err = p.endLine()
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// table returns the table at path below t, creating missing tables.
+// A path through an array of tables uses its last table.
+func (p *tomlParser) table(t map[string]interface{}, path []string) (map[string]interface{}, error) {
+	for i, key := range path {
+		switch v := t[key].(type) {
+		case nil:
+			next := map[string]interface{}{}
+			t[key] = next
+			t = next
+		case map[string]interface{}:
+			t = v
+		case []interface{}:
+			next, ok := v[len(v)-1].(map[string]interface{})
+			if !ok {
+				return nil, p.errorf("%s is not a table", strings.Join(path[:i+1], "."))
+			}
+			t = next
+		default:
+			return nil, p.errorf("%s is not a table", strings.Join(path[:i+1], "."))
+		}
+	}
+	return t, nil
+}
+
+// parseKeyValue parses "key = value" into t.
+func (p *tomlParser) parseKeyValue(t map[string]interface{}) error {
+	path, err := p.parseKey()
+	if err != nil {
+		return err
+	}
+	p.skip(false)
+	if p.pos >= len(p.s) || p.s[p.pos] != '=' {
+		return p.errorf("expected \"=\" after key %s", strings.Join(path, "."))
+	}
+	p.pos++
+	p.skip(false)
+	value, err := p.parseValue()
+	if err != nil {
+		return err
+	}
+	t, err = p.table(t, path[:len(path)-1])
+	if err != nil {
+		return err
+	}
+	last := path[len(path)-1]
+	if _, ok := t[last]; ok {
+		return p.errorf("duplicate key %s", strings.Join(path, "."))
+	}
+	t[last] = value
+	return nil
+}
+
+// parseKey parses a bare, quoted, or dotted key.
+func (p *tomlParser) parseKey() (path []string, err error) {
+	for {
+		p.skip(false)
+		if p.pos >= len(p.s) {
+			return nil, p.errorf("expected a key")
+		}
+		var key string
+		switch p.s[p.pos] {
+		case '"', '\'':
+			key, err = p.parseString()
+			if err != nil {
+				return nil, err
+			}
+		default:
+			start := p.pos
+			for p.pos < len(p.s) && isBareKeyChar(p.s[p.pos]) {
+				p.pos++
+			}
+			if start == p.pos {
+				return nil, p.errorf("invalid key %q", p.rest())
+			}
+			key = p.s[start:p.pos]
+		}
+		path = append(path, key)
+		p.skip(false)
+		if p.pos >= len(p.s) || p.s[p.pos] != '.' {
+			return path, nil
+		}
+		p.pos++
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func (p *tomlParser) parseValue() (interface{}, error) {
+	if p.pos >= len(p.s) || p.s[p.pos] == '\n' {
+		return nil, p.errorf("missing value")
+	}
+	switch p.s[p.pos] {
+	case '"', '\'':
+		return p.parseString()
+	case '[':
+		return p.parseArray()
+	case '{':
+		return p.parseInlineTable()
+	}
+	start := p.pos
+	for p.pos < len(p.s) && strings.IndexByte(" \t\n#,]}", p.s[p.pos]) < 0 {
+		p.pos++
+	}
+	token := p.s[start:p.pos]
+	// a date followed by a time is separated by a space
+	if tomlDate.MatchString(token) && p.pos+1 < len(p.s) && p.s[p.pos] == ' ' {
+		end := p.pos + 1
+		for end < len(p.s) && strings.IndexByte(" \t\n#,]}", p.s[end]) < 0 {
+			end++
+		}
+		if tomlDateTime.MatchString(token + " " + p.s[p.pos+1:end]) {
+			token += " " + p.s[p.pos+1:end]
+			p.pos = end
+		}
+	}
+	switch {
+	case token == "true":
+		return true, nil
+	case token == "false":
+		return false, nil
+	case tomlDate.MatchString(token) || tomlTime.MatchString(token) || tomlDateTime.MatchString(token):
+		return token, nil
+	case tomlInt.MatchString(token):
+		return json.Number(strings.TrimPrefix(strings.Replace(token, "_", "", -1), "+")), nil
+	case tomlFloat.MatchString(token):
+		return json.Number(strings.TrimPrefix(strings.Replace(token, "_", "", -1), "+")), nil
+	}
+	return nil, p.errorf("invalid value %q", token)
+}
+
+var (
+	tomlInt      = regexp.MustCompile(`^[-+]?(0|[1-9](_?[0-9])*)$`)
+	tomlFloat    = regexp.MustCompile(`^[-+]?(0|[1-9](_?[0-9])*)(\.[0-9](_?[0-9])*)?([eE][-+]?[0-9](_?[0-9])*)?$`)
+	tomlDate     = regexp.MustCompile(`^[0-9]{4}-[0-9]{2}-[0-9]{2}$`)
+	tomlTime     = regexp.MustCompile(`^[0-9]{2}:[0-9]{2}:[0-9]{2}(\.[0-9]+)?$`)
+	tomlDateTime = regexp.MustCompile(`^[0-9]{4}-[0-9]{2}-[0-9]{2}[Tt ][0-9]{2}:[0-9]{2}:[0-9]{2}(\.[0-9]+)?([Zz]|[-+][0-9]{2}:[0-9]{2})?$`)
+)
+
+// parseString parses a basic "string" or a literal 'string'.
+func (p *tomlParser) parseString() (string, error) {
+	quote := p.s[p.pos]
+	if strings.HasPrefix(p.s[p.pos:], strings.Repeat(string(quote), 3)) {
+		return "", p.errorf("multi-line strings are not supported")
+	}
+	p.pos++
+	var b strings.Builder
+	for {
+		if p.pos >= len(p.s) || p.s[p.pos] == '\n' {
+			return "", p.errorf("unterminated string")
+		}
+		c := p.s[p.pos]
+		p.pos++
+		switch {
+		case c == quote:
+			return b.String(), nil
+		case c == '\\' && quote == '"':
+			r, err := p.parseEscape()
+			if err != nil {
+				return "", err
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+func (p *tomlParser) parseEscape() (rune, error) {
+	if p.pos >= len(p.s) {
+		return 0, p.errorf("unterminated string")
+	}
+	c := p.s[p.pos]
+	p.pos++
+	switch c {
+	case 'b':
+		return '\b', nil
+	case 't':
+		return '\t', nil
+	case 'n':
+		return '\n', nil
+	case 'f':
+		return '\f', nil
+	case 'r':
+		return '\r', nil
+	case '"', '\\':
+		return rune(c), nil
+	case 'u', 'U':
+		size := 4
+		if c == 'U' {
+			size = 8
+		}
+		if p.pos+size > len(p.s) {
+			return 0, p.errorf("invalid escape \\%c", c)
+		}
+		code, err := strconv.ParseUint(p.s[p.pos:p.pos+size], 16, 32)
+		if err != nil {
+			return 0, p.errorf("invalid escape \\%c%s", c, p.s[p.pos:p.pos+size])
+		}
+		p.pos += size
+		return rune(code), nil
+	}
+	return 0, p.errorf("invalid escape \\%c", c)
+}
+
+// parseArray parses an array, which may span lines.
+func (p *tomlParser) parseArray() (interface{}, error) {
+	p.pos++
+	values := []interface{}{}
+	for {
+		p.skip(true)
+		if p.pos < len(p.s) && p.s[p.pos] == ']' {
+			p.pos++
+			return values, nil
+		}
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		p.skip(true)
+		if p.pos >= len(p.s) {
+			return nil, p.errorf("unterminated array")
+		}
+		switch p.s[p.pos] {
+		case ',':
+			p.pos++
+		case ']':
+		default:
+			return nil, p.errorf("expected \",\" or \"]\", found %q", p.rest())
+		}
+	}
+}
+
+// parseInlineTable parses an inline table, which must fit on one line.
+func (p *tomlParser) parseInlineTable() (interface{}, error) {
+	p.pos++
+	t := map[string]interface{}{}
+	p.skip(false)
+	if p.pos < len(p.s) && p.s[p.pos] == '}' {
+		p.pos++
+		return t, nil
+	}
+	for {
+		err := p.parseKeyValue(t)
+		if err != nil {
+			return nil, err
+		}
+		p.skip(false)
+		if p.pos >= len(p.s) || p.s[p.pos] == '\n' {
+			return nil, p.errorf("unterminated inline table")
+		}
+		switch p.s[p.pos] {
+		case ',':
+			p.pos++
+		case '}':
+			p.pos++
+			return t, nil
+		default:
+			return nil, p.errorf("expected \",\" or \"}\", found %q", p.rest())
+		}
+	}
+}
@@ -0,0 +1,375 @@
+package dustcollector
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Kinds of TrendRecord
+const (
+	TrendScan  = "scan"
+	TrendApply = "apply"
+)
+
+// Intervals accepted by TrendQuery
+const (
+	IntervalRun   = "run"
+	IntervalDay   = "day"
+	IntervalWeek  = "week"
+	IntervalMonth = "month"
+)
+
+// TrendRecord is the summary of a single scan, or of an apply of its
+// plan, as kept in a TrendStore.
+type TrendRecord struct {
+	Kind    string    `json:"kind"`
+	RunId   string    `json:"runId,omitempty"`
+	Time    time.Time `json:"time"`
+	Account string    `json:"account"`
+	Region  string    `json:"region"`
+
+	SnapshotsInScope  int     `json:"snapshotsInScope"`
+	OrphanedSnapshots int     `json:"orphanedSnapshots"`
+	OrphanedGbs       int64   `json:"orphanedGbs"`
+	MonthlyWaste      float64 `json:"monthlyWaste"`
+	PlanSteps         int     `json:"planSteps"`
+
+	// Spared snapshots by reason (see the reason label of the
+	// dustcollector_spared_snapshots metric)
+	Spared map[string]int `json:"spared"`
+
+	DeletedSnapshots int     `json:"deletedSnapshots"`
+	RealizedGbs      int64   `json:"realizedGbs"`
+	RealizedSavings  float64 `json:"realizedSavings"`
+}
+
+// TrendRecord summarizes the results of the Expedition for a
+// TrendStore. Use kind TrendScan after Start and TrendApply after Apply
+// so the realized savings are dated when the deletions happened. The
+// runId ties the scan and apply records of the same plan together and
+// may be empty.
+func (exp *Expedition) TrendRecord(kind, runId string) TrendRecord {
+	summary := exp.GetSummary()
+	rec := TrendRecord{
+		Kind:              kind,
+		RunId:             runId,
+		Time:              exp.scanTime,
+		Account:           summary.Account,
+		Region:            summary.Region,
+		SnapshotsInScope:  summary.SnapshotCount,
+		OrphanedSnapshots: len(summary.Snapshots),
+		OrphanedGbs:       summary.TotalGbs,
+		MonthlyWaste:      summary.Savings,
+		PlanSteps:         len(exp.Plan),
+		Spared:            make(map[string]int),
+		DeletedSnapshots:  summary.DeletedSnapshots,
+		RealizedGbs:       summary.DeletedGbs,
+		RealizedSavings:   summary.RealizedSavings,
+	}
+	for _, group := range summary.Spared {
+		rec.Spared[spareReasonLabels[group.Reason]] = len(group.Nuggets)
+	}
+	if kind == TrendApply || rec.Time.IsZero() {
+		rec.Time = time.Now().UTC()
+	}
+	return rec
+}
+
+// TrendStore keeps the TrendRecords of every run in an append-only
+// json lines file so waste and realized savings can be followed over
+// time. It is safe for concurrent use within a process.
+type TrendStore struct {
+	filename string
+	mu       sync.Mutex
+}
+
+// OpenTrendStore returns a TrendStore backed by filename, creating the
+// file and its directory if needed.
+func OpenTrendStore(filename string) (ts *TrendStore, err error) {
+	err = os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &TrendStore{filename: filename}, file.Close()
+}
+
+// Record appends rec to the store.
+func (ts *TrendStore) Record(rec TrendRecord) (err error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	file, err := os.OpenFile(ts.filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(data, '\n'))
+	if err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// Records returns every record in the store ordered by time.
+func (ts *TrendStore) Records() (recs []TrendRecord, err error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	file, err := os.Open(ts.filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var rec TrendRecord
+		err = json.Unmarshal(line, &rec)
+		if err != nil {
+			// a partially written last line from an interrupted
+			// process is expected so just ignore it
+			continue
+		}
+		recs = append(recs, rec)
+	}
+	sort.SliceStable(recs, func(i, j int) bool {
+		return recs[i].Time.Before(recs[j].Time)
+	})
+	return recs, scanner.Err()
+}
+
+// TrendQuery selects the records and the interval of a trend.
+type TrendQuery struct {
+	// Only include these if set
+	Account string
+	Region  string
+
+	// Only include points from Since up to but not including Until
+	// if they are set
+	Since time.Time
+	Until time.Time
+
+	// One of IntervalRun, IntervalDay, IntervalWeek (starting
+	// Monday), or IntervalMonth.
+	// Default: IntervalDay
+	Interval string
+}
+
+// TrendPoint is the state of all selected accounts and regions at the
+// end of one interval. The waste is taken from the latest scan of each
+// account and region up to that time and the realized savings are the
+// sum of every plan applied up to that time.
+type TrendPoint struct {
+	Time              time.Time      `json:"time"`
+	Scans             int            `json:"scans"`
+	SnapshotsInScope  int            `json:"snapshotsInScope"`
+	OrphanedSnapshots int            `json:"orphanedSnapshots"`
+	OrphanedGbs       int64          `json:"orphanedGbs"`
+	MonthlyWaste      float64        `json:"monthlyWaste"`
+	Spared            map[string]int `json:"spared"`
+	DeletedSnapshots  int            `json:"deletedSnapshots"`
+	RealizedGbs       int64          `json:"realizedGbs"`
+	RealizedSavings   float64        `json:"realizedSavings"`
+}
+
+// intervalStart returns the start of the interval containing t.
+func intervalStart(t time.Time, interval string) time.Time {
+	t = t.UTC()
+	switch interval {
+	case IntervalRun:
+		return t
+	case IntervalWeek:
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case IntervalMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// intervalEnd returns the start of the interval following the one
+// that starts at start.
+func intervalEnd(start time.Time, interval string) time.Time {
+	switch interval {
+	case IntervalRun:
+		return start.Add(time.Nanosecond)
+	case IntervalWeek:
+		return start.AddDate(0, 0, 7)
+	case IntervalMonth:
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// Query returns one TrendPoint for each interval that has at least one
+// record matching q, oldest first.
+func (ts *TrendStore) Query(q TrendQuery) (points []TrendPoint, err error) {
+	if q.Interval == "" {
+		q.Interval = IntervalDay
+	}
+	switch q.Interval {
+	case IntervalRun, IntervalDay, IntervalWeek, IntervalMonth:
+	default:
+		return nil, fmt.Errorf("unknown trend interval %q", q.Interval)
+	}
+	all, err := ts.Records()
+	if err != nil {
+		return nil, err
+	}
+	var recs []TrendRecord
+	for _, rec := range all {
+		if q.Account != "" && rec.Account != q.Account {
+			continue
+		}
+		if q.Region != "" && rec.Region != q.Region {
+			continue
+		}
+		recs = append(recs, rec)
+	}
+	var starts []time.Time
+	seen := make(map[time.Time]bool)
+	for _, rec := range recs {
+		start := intervalStart(rec.Time, q.Interval)
+		if !q.Since.IsZero() && start.Before(intervalStart(q.Since, q.Interval)) {
+			continue
+		}
+		if !q.Until.IsZero() && !rec.Time.Before(q.Until) {
+			continue
+		}
+		if !seen[start] {
+			seen[start] = true
+			starts = append(starts, start)
+		}
+	}
+	for _, start := range starts {
+		points = append(points, trendPointAt(recs, start, intervalEnd(start, q.Interval)))
+	}
+	return points, nil
+}
+
+// trendPointAt builds the TrendPoint for the interval from start to
+// end out of the records (ordered by time).
+func trendPointAt(recs []TrendRecord, start, end time.Time) (point TrendPoint) {
+	point = TrendPoint{Time: start, Spared: make(map[string]int)}
+	latestScan := make(map[string]TrendRecord)
+	latestApply := make(map[string]TrendRecord)
+	var scopes []string
+	for _, rec := range recs {
+		if !rec.Time.Before(end) {
+			break
+		}
+		scope := rec.Account + "/" + rec.Region
+		switch rec.Kind {
+		case TrendApply:
+			// re-applying a run replaces its earlier outcome
+			key := scope + "/" + rec.RunId
+			if rec.RunId == "" {
+				key += rec.Time.String()
+			}
+			latestApply[key] = rec
+		default:
+			if _, ok := latestScan[scope]; !ok {
+				scopes = append(scopes, scope)
+			}
+			latestScan[scope] = rec
+			if !rec.Time.Before(start) {
+				point.Scans++
+			}
+		}
+	}
+	for _, scope := range scopes {
+		rec := latestScan[scope]
+		point.SnapshotsInScope += rec.SnapshotsInScope
+		point.OrphanedSnapshots += rec.OrphanedSnapshots
+		point.OrphanedGbs += rec.OrphanedGbs
+		point.MonthlyWaste += rec.MonthlyWaste
+		for reason, count := range rec.Spared {
+			point.Spared[reason] += count
+		}
+	}
+	for _, rec := range latestApply {
+		point.DeletedSnapshots += rec.DeletedSnapshots
+		point.RealizedGbs += rec.RealizedGbs
+		point.RealizedSavings += rec.RealizedSavings
+	}
+	return point
+}
+
+// WriteTrendsTable renders the points as a text table with a bar for
+// the monthly waste of each point so the trend is visible at a glance.
+func WriteTrendsTable(w io.Writer, points []TrendPoint) (err error) {
+	const barWidth = 40
+	var max float64
+	for _, p := range points {
+		if p.MonthlyWaste > max {
+			max = p.MonthlyWaste
+		}
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tORPHANED\tGB\tWASTE/MONTH\tREALIZED/MONTH\t")
+	for _, p := range points {
+		var bar string
+		if max > 0 {
+			bar = strings.Repeat("#", int(p.MonthlyWaste/max*barWidth+0.5))
+		}
+		fmt.Fprintf(
+			tw, "%s\t%d\t%d\t$%.2f\t$%.2f\t%s\n",
+			p.Time.Format("2006-01-02 15:04"), p.OrphanedSnapshots, p.OrphanedGbs,
+			p.MonthlyWaste, p.RealizedSavings, bar,
+		)
+	}
+	return tw.Flush()
+}
+
+// WriteTrendsCSV writes the points as csv.
+func WriteTrendsCSV(w io.Writer, points []TrendPoint) (err error) {
+	csvwriter := csv.NewWriter(w)
+	header := []string{
+		"Time", "Scans", "SnapshotsInScope", "OrphanedSnapshots", "OrphanedGbs",
+		"MonthlyWaste", "DeletedSnapshots", "RealizedGbs", "RealizedSavings",
+	}
+	for _, reason := range spareReasons {
+		header = append(header, "Spared-"+spareReasonLabels[reason])
+	}
+	csvwriter.Write(header)
+	for _, p := range points {
+		row := []string{
+			p.Time.Format(time.RFC3339),
+			strconv.Itoa(p.Scans),
+			strconv.Itoa(p.SnapshotsInScope),
+			strconv.Itoa(p.OrphanedSnapshots),
+			strconv.FormatInt(p.OrphanedGbs, 10),
+			strconv.FormatFloat(p.MonthlyWaste, 'f', 2, 64),
+			strconv.Itoa(p.DeletedSnapshots),
+			strconv.FormatInt(p.RealizedGbs, 10),
+			strconv.FormatFloat(p.RealizedSavings, 'f', 2, 64),
+		}
+		for _, reason := range spareReasons {
+			row = append(row, strconv.Itoa(p.Spared[spareReasonLabels[reason]]))
+		}
+		csvwriter.Write(row)
+	}
+	csvwriter.Flush()
+	return csvwriter.Error()
+}
@@ -0,0 +1,144 @@
+package dustcollector
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// trendTime returns the UTC time of day hour on 2020-month-day.
+func trendTime(month time.Month, day, hour int) time.Time {
+	return time.Date(2020, month, day, hour, 0, 0, 0, time.UTC)
+}
+
+// newTestTrendStore returns a TrendStore in a temporary directory with
+// the records written in the given order.
+func newTestTrendStore(t *testing.T, recs []TrendRecord) *TrendStore {
+	dir, err := ioutil.TempDir("", "dustcollector-trends")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	ts, err := OpenTrendStore(filepath.Join(dir, "trends", "trends.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range recs {
+		err = ts.Record(rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ts
+}
+
+// TestTrendQuery runs queries over two accounts. 2020-06-01 is a
+// Monday so the Sunday before it is in the previous week.
+func TestTrendQuery(t *testing.T) {
+	scan := func(account string, at time.Time, orphaned int) TrendRecord {
+		return TrendRecord{
+			Kind: TrendScan, Time: at, Account: account, Region: "us-east-1",
+			SnapshotsInScope: orphaned * 2, OrphanedSnapshots: orphaned, OrphanedGbs: int64(orphaned * 10),
+			MonthlyWaste: float64(orphaned) / 2, Spared: map[string]int{"volume": 1},
+		}
+	}
+	apply := func(runId string, at time.Time, deleted int) TrendRecord {
+		return TrendRecord{
+			Kind: TrendApply, RunId: runId, Time: at, Account: "a", Region: "us-east-1",
+			DeletedSnapshots: deleted, RealizedGbs: int64(deleted * 10), RealizedSavings: float64(deleted) / 2,
+		}
+	}
+	// written out of order to make sure the store orders them by time
+	ts := newTestTrendStore(t, []TrendRecord{
+		scan("a", trendTime(6, 3, 9), 8),
+		scan("a", trendTime(5, 31, 12), 10),
+		scan("b", trendTime(6, 1, 0), 5),
+		apply("run-1", trendTime(6, 3, 13), 2),
+		// re-applying run-1 replaces its outcome
+		apply("run-1", trendTime(6, 4, 8), 3),
+		scan("b", trendTime(6, 9, 10), 1),
+		// applies without a run ID are all counted
+		apply("", trendTime(6, 10, 8), 1),
+		apply("", trendTime(6, 11, 8), 1),
+	})
+
+	type point struct {
+		time                     time.Time
+		scans, orphaned, deleted int
+		waste, realized          float64
+		spared                   int
+	}
+	for _, c := range []struct {
+		name  string
+		query TrendQuery
+		want  []point
+	}{
+		{"weeks", TrendQuery{Interval: IntervalWeek}, []point{
+			{trendTime(5, 25, 0), 1, 10, 0, 5, 0, 1},
+			// a's 10 orphans are replaced by its later scan
+			{trendTime(6, 1, 0), 2, 13, 3, 6.5, 1.5, 2},
+			// a's scan is carried forward
+			{trendTime(6, 8, 0), 1, 9, 5, 4.5, 2.5, 2},
+		}},
+		{"months", TrendQuery{Interval: IntervalMonth}, []point{
+			{trendTime(5, 1, 0), 1, 10, 0, 5, 0, 1},
+			{trendTime(6, 1, 0), 3, 9, 5, 4.5, 2.5, 2},
+		}},
+		{"account", TrendQuery{Account: "b", Interval: IntervalWeek}, []point{
+			{trendTime(6, 1, 0), 1, 5, 0, 2.5, 0, 1},
+			{trendTime(6, 8, 0), 1, 1, 0, 0.5, 0, 1},
+		}},
+		{"since and until", TrendQuery{Since: trendTime(6, 3, 0), Until: trendTime(6, 4, 0)}, []point{
+			{trendTime(6, 3, 0), 1, 13, 2, 6.5, 1, 2},
+		}},
+		{"runs", TrendQuery{Account: "b", Interval: IntervalRun}, []point{
+			{trendTime(6, 1, 0), 1, 5, 0, 2.5, 0, 1},
+			{trendTime(6, 9, 10), 1, 1, 0, 0.5, 0, 1},
+		}},
+	} {
+		points, err := ts.Query(c.query)
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		if len(points) != len(c.want) {
+			t.Fatalf("%s: got %d points %+v, want %d", c.name, len(points), points, len(c.want))
+		}
+		for i, want := range c.want {
+			p := points[i]
+			got := point{
+				p.Time, p.Scans, p.OrphanedSnapshots, p.DeletedSnapshots,
+				p.MonthlyWaste, p.RealizedSavings, p.Spared["volume"],
+			}
+			if got != want {
+				t.Errorf("%s: point %d got %+v, want %+v", c.name, i, got, want)
+			}
+			if p.OrphanedGbs != int64(want.orphaned*10) || p.RealizedGbs != int64(want.deleted*10) {
+				t.Errorf("%s: point %d got %d GB orphaned and %d GB realized", c.name, i, p.OrphanedGbs, p.RealizedGbs)
+			}
+		}
+	}
+	if _, err := ts.Query(TrendQuery{Interval: "year"}); err == nil {
+		t.Error("unknown interval was accepted")
+	}
+}
+
+// TestIntervalStart checks that weeks start on Monday.
+func TestIntervalStart(t *testing.T) {
+	monday := trendTime(6, 1, 0)
+	for day := 1; day <= 7; day++ {
+		at := trendTime(6, day, 23)
+		if start := intervalStart(at, IntervalWeek); !start.Equal(monday) {
+			t.Errorf("week of %s starts %s, want %s", at, start, monday)
+		}
+	}
+	if start := intervalStart(trendTime(5, 31, 23), IntervalWeek); !start.Equal(trendTime(5, 25, 0)) {
+		t.Errorf("week of Sunday 2020-05-31 starts %s", start)
+	}
+	// times in other zones are bucketed by their UTC time
+	est := time.FixedZone("EST", -5*60*60)
+	if start := intervalStart(time.Date(2020, 5, 31, 20, 0, 0, 0, est), IntervalWeek); !start.Equal(monday) {
+		t.Errorf("week of Sunday 20:00 EST starts %s, want %s", start, monday)
+	}
+}
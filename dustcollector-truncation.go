@@ -0,0 +1,73 @@
+package dustcollector
+
+// Listings that an Expedition paginates through and that can be cut
+// short by ExpeditionInput.MaxPages or ExpeditionInput.MaxSnapshots
+const (
+	ListingSnapshots            = "snapshots"
+	ListingLaunchTemplates      = "launchTemplates"
+	ListingLaunchConfigurations = "launchConfigurations"
+	ListingAutoScalingGroups    = "autoScalingGroups"
+	ListingImages               = "images"
+)
+
+// Truncation records a listing that was stopped before its last page.
+// A truncated snapshot listing only means some snapshots weren't
+// analyzed, but any other truncated listing means a snapshot could be
+// in use by a resource that was never seen so the plan must be reviewed
+// before it is applied.
+type Truncation struct {
+	Listing string `json:"listing"`
+
+	// Pages fetched and items kept before the listing was stopped
+	Pages int `json:"pages"`
+	Items int `json:"items"`
+}
+
+// pager decides whether a paginator fetches the page of listing after
+// pageNum once it holds items results.
+type pager func(listing string, pageNum, items int, lastPage bool) bool
+
+// morePages is the pager of the analysis. It records a Truncation if
+// MaxPages stops a listing that has more pages.
+func (exp *Expedition) morePages(listing string, pageNum, items int, lastPage bool) bool {
+	if lastPage || exp.maxPages <= 0 || pageNum < exp.maxPages {
+		return true
+	}
+	exp.truncate(listing, pageNum, items)
+	return false
+}
+
+// truncate records that listing was cut short. A listing that is
+// truncated more than once is only recorded once with the latest counts.
+func (exp *Expedition) truncate(listing string, pages, items int) {
+	exp.log.Warn(
+		"listing cut short, results are incomplete",
+		"listing", listing, "pages", pages, "items", items,
+	)
+	exp.Truncated = true
+	for i := range exp.Truncations {
+		if exp.Truncations[i].Listing == listing {
+			exp.Truncations[i] = Truncation{Listing: listing, Pages: pages, Items: items}
+			return
+		}
+	}
+	exp.Truncations = append(exp.Truncations, Truncation{Listing: listing, Pages: pages, Items: items})
+}
+
+// allPages is the pager of Apply's pre-flight checks, which must see
+// every resource regardless of MaxPages and leave the Truncations of
+// the analysis alone.
+func allPages(listing string, pageNum, items int, lastPage bool) bool {
+	return true
+}
+
+// associationsTruncated reports whether any listing other than the
+// snapshots was cut short.
+func (exp *Expedition) associationsTruncated() bool {
+	for _, t := range exp.Truncations {
+		if t.Listing != ListingSnapshots {
+			return true
+		}
+	}
+	return false
+}
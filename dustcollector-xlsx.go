@@ -0,0 +1,314 @@
+package dustcollector
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// xlsxCell is a single cell of a worksheet. The value may be a string,
+// int, int64, float64, or bool. If formula is set the value is written
+// as the cached result of the formula.
+type xlsxCell struct {
+	value   interface{}
+	formula string
+	bold    bool
+}
+
+// xlsxSheet is a named worksheet made up of rows of cells.
+type xlsxSheet struct {
+	name string
+	rows [][]xlsxCell
+}
+
+// addRow appends a row of plain values to the sheet.
+func (s *xlsxSheet) addRow(values ...interface{}) {
+	var row []xlsxCell
+	for _, v := range values {
+		row = append(row, xlsxCell{value: v})
+	}
+	s.rows = append(s.rows, row)
+}
+
+// addHeader appends a row of bold column headings to the sheet.
+func (s *xlsxSheet) addHeader(names ...string) {
+	var row []xlsxCell
+	for _, name := range names {
+		row = append(row, xlsxCell{value: name, bold: true})
+	}
+	s.rows = append(s.rows, row)
+}
+
+// xlsxColumn converts a zero based column index to its letter name,
+// e.g. 0 is A and 27 is AB.
+func xlsxColumn(i int) string {
+	name := ""
+	for i >= 0 {
+		name = string(rune('A'+i%26)) + name
+		i = i/26 - 1
+	}
+	return name
+}
+
+// xlsxEscape escapes s for use as XML text.
+func xlsxEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// writeSheet writes the worksheet XML for s to w.
+func (s *xlsxSheet) writeSheet(w io.Writer) (err error) {
+	bw := bufio.NewWriter(w)
+	fmt.Fprint(bw, xml.Header)
+	fmt.Fprint(bw, `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	for r, row := range s.rows {
+		fmt.Fprintf(bw, `<row r="%d">`, r+1)
+		for c, cell := range row {
+			ref := fmt.Sprintf("%s%d", xlsxColumn(c), r+1)
+			style := ""
+			if cell.bold {
+				style = ` s="1"`
+			}
+			formula := ""
+			if cell.formula != "" {
+				formula = "<f>" + xlsxEscape(cell.formula) + "</f>"
+			}
+			switch v := cell.value.(type) {
+			case string:
+				if formula != "" {
+					fmt.Fprintf(bw, `<c r="%s" t="str"%s>%s<v>%s</v></c>`, ref, style, formula, xlsxEscape(v))
+				} else {
+					fmt.Fprintf(bw, `<c r="%s" t="inlineStr"%s><is><t xml:space="preserve">%s</t></is></c>`, ref, style, xlsxEscape(v))
+				}
+			case bool:
+				b := 0
+				if v {
+					b = 1
+				}
+				fmt.Fprintf(bw, `<c r="%s" t="b"%s>%s<v>%d</v></c>`, ref, style, formula, b)
+			case int:
+				fmt.Fprintf(bw, `<c r="%s"%s>%s<v>%d</v></c>`, ref, style, formula, v)
+			case int64:
+				fmt.Fprintf(bw, `<c r="%s"%s>%s<v>%d</v></c>`, ref, style, formula, v)
+			case float64:
+				fmt.Fprintf(bw, `<c r="%s"%s>%s<v>%s</v></c>`, ref, style, formula, strconv.FormatFloat(v, 'f', -1, 64))
+			case nil:
+				if formula != "" {
+					fmt.Fprintf(bw, `<c r="%s"%s>%s</c>`, ref, style, formula)
+				}
+			default:
+				return fmt.Errorf("unsupported cell value type %T", v)
+			}
+		}
+		fmt.Fprint(bw, `</row>`)
+	}
+	fmt.Fprint(bw, `</sheetData></worksheet>`)
+	return bw.Flush()
+}
+
+// writeXLSX writes the sheets as an Office Open XML workbook to w.
+func writeXLSX(w io.Writer, sheets []*xlsxSheet) (err error) {
+	z := zip.NewWriter(w)
+	add := func(name, content string) error {
+		f, err := z.Create(name)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(f, content)
+		return err
+	}
+	var overrides, sheetList, rels strings.Builder
+	for i := range sheets {
+		fmt.Fprintf(
+			&overrides,
+			`<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`,
+			i+1,
+		)
+		fmt.Fprintf(
+			&sheetList, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`,
+			xlsxEscape(sheets[i].name), i+1, i+1,
+		)
+		fmt.Fprintf(
+			&rels,
+			`<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`,
+			i+1, i+1,
+		)
+	}
+	fmt.Fprintf(
+		&rels,
+		`<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`,
+		len(sheets)+1,
+	)
+	parts := []struct{ name, content string }{
+		{"[Content_Types].xml", xml.Header +
+			`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+			`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+			overrides.String() + `</Types>`},
+		{"_rels/.rels", xml.Header +
+			`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`},
+		{"xl/workbook.xml", xml.Header +
+			`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+			`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets>` + sheetList.String() + `</sheets>` +
+			`<calcPr fullCalcOnLoad="1"/></workbook>`},
+		{"xl/_rels/workbook.xml.rels", xml.Header +
+			`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			rels.String() + `</Relationships>`},
+		{"xl/styles.xml", xml.Header +
+			`<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+			`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font>` +
+			`<font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+			`<fills count="2"><fill><patternFill patternType="none"/></fill>` +
+			`<fill><patternFill patternType="gray125"/></fill></fills>` +
+			`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+			`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+			`<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+			`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs>` +
+			`</styleSheet>`},
+	}
+	for _, part := range parts {
+		err = add(part.name, part.content)
+		if err != nil {
+			return err
+		}
+	}
+	for i, sheet := range sheets {
+		f, err := z.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1))
+		if err != nil {
+			return err
+		}
+		err = sheet.writeSheet(f)
+		if err != nil {
+			return err
+		}
+	}
+	return z.Close()
+}
+
+// buildWorkbook lays out the sheets of the workbook: a Summary sheet
+// whose totals are formulas over the other sheets, and one sheet each
+// for the Nuggets, Bars, ordered Plan, and spared snapshots.
+func (exp *Expedition) buildWorkbook() []*xlsxSheet {
+	nuggets := &xlsxSheet{name: "Nuggets"}
+	nuggets.addHeader(
+		"OwnerId", "SnapshotId", "ImageIds", "LaunchConfigNames",
+		"LaunchTemplateNames", "ASGNames", "AMISharedWith", "HasVolume",
+		"StartTime", "Tags", "VolumeSize", "Description", "SpareReason",
+		"Owner",
+	)
+	for _, nug := range exp.Nuggets {
+		s := nug.dumpString()
+		nuggets.addRow(
+			s[0], s[1], s[2], s[3], s[4], s[5], s[6], nug.HasVol, s[8], s[9],
+			*nug.Snap.VolumeSize, s[11], nug.SpareReason, nug.Owner,
+		)
+	}
+
+	deletable, totalGbs := exp.deletableBars()
+	inPlan := make(map[*Bar]bool)
+	for _, bar := range deletable {
+		inPlan[bar] = true
+	}
+	bars := &xlsxSheet{name: "Bars"}
+	bars.addHeader(
+		"VolumeId", "SnapshotIds", "HasVolume", "StartTime", "VolumeSize",
+		"InPlan", "MonthlyCost",
+	)
+	for i, bar := range exp.Bars {
+		s := bar.dumpString()
+		size := *bar.Nuggets[0].Snap.VolumeSize
+		row := []xlsxCell{
+			{value: *bar.VolumeId}, {value: s[1]}, {value: bar.HasVol},
+			{value: s[3]}, {value: size}, {value: inPlan[bar]},
+			{
+				value:   float64(size) * exp.ebsSnapRate,
+				formula: fmt.Sprintf("E%d*Summary!$B$2", i+2),
+			},
+		}
+		bars.rows = append(bars.rows, row)
+	}
+
+	plan := &xlsxSheet{name: "Plan"}
+	plan.addHeader("Order", "ResourceType", "ResourceId", "Status", "Reason", "Justification")
+	for i, step := range exp.Plan {
+		plan.addRow(i+1, step.ResourceType, step.ResourceId, step.Status, step.Reason, step.Justification)
+	}
+
+	spared := &xlsxSheet{name: "Spared"}
+	spared.addHeader("SnapshotId", "VolumeId", "SpareReason", "StartTime", "VolumeSize", "ASGNames", "AMISharedWith")
+	summary := exp.GetSummary()
+	for _, group := range summary.Spared {
+		for _, nug := range group.Nuggets {
+			spared.addRow(
+				*nug.Snap.SnapshotId, *nug.Snap.VolumeId, nug.SpareReason,
+				nug.Snap.StartTime.Format("2006-01-02"), *nug.Snap.VolumeSize,
+				strings.Join(dedupeString(nug.ASGs), "|"),
+				strings.Join(dedupeString(nug.AMISharedWith), "|"),
+			)
+		}
+	}
+
+	sum := &xlsxSheet{name: "Summary"}
+	sum.rows = [][]xlsxCell{
+		{{value: "Account", bold: true}, {value: exp.account}},
+		{{value: "Per GB-month rate", bold: true}, {value: exp.ebsSnapRate}},
+		{{value: "Date filter", bold: true}, {value: exp.dateFilter}},
+		{{value: "Snapshots analyzed", bold: true}, {value: len(exp.Nuggets), formula: "COUNTA(Nuggets!B:B)-1"}},
+		{{value: "Snapshots to delete", bold: true}, {value: len(summary.Snapshots), formula: `COUNTIF(Plan!B:B,"Snapshot")`}},
+		{{value: "Snapshots spared", bold: true}, {value: summary.SparedCount, formula: "COUNTA(Spared!A:A)-1"}},
+		{{value: "Size eligible for deletion (GB)", bold: true}, {value: totalGbs, formula: "SUMIF(Bars!F:F,TRUE,Bars!E:E)"}},
+		{{value: "Potential monthly savings", bold: true}, {value: float64(totalGbs) * exp.ebsSnapRate, formula: "B7*B2"}},
+		{{value: "Potential yearly savings", bold: true}, {value: float64(totalGbs) * exp.ebsSnapRate * 12, formula: "B8*12"}},
+	}
+	// warnings go below the totals so the formulas keep their cells
+	for i, t := range exp.Truncations {
+		label := ""
+		if i == 0 {
+			label = "Incomplete results"
+		}
+		sum.rows = append(sum.rows, []xlsxCell{
+			{value: label, bold: true},
+			{value: exp.message("truncated", exp.message("listing."+t.Listing), t.Pages, t.Items)},
+		})
+	}
+	if exp.associationsTruncated() {
+		sum.rows = append(sum.rows, []xlsxCell{{}, {value: exp.message("truncated.associations"), bold: true}})
+	}
+	return []*xlsxSheet{sum, nuggets, bars, plan, spared}
+}
+
+// WriteWorkbook writes the results of the Expedition to w as an .xlsx
+// workbook with sheets for the Summary, Nuggets, Bars, the ordered Plan,
+// and the spared snapshots with their reasons. The Summary totals are
+// formulas so changing the rate in the workbook recalculates the savings.
+// Listings cut short by MaxPages or MaxSnapshots are warned about below
+// the totals.
+func (exp *Expedition) WriteWorkbook(w io.Writer) (err error) {
+	return writeXLSX(w, exp.buildWorkbook())
+}
+
+// ExportWorkbook writes the workbook (see WriteWorkbook) to outfile.
+func (exp *Expedition) ExportWorkbook() (err error) {
+	file, err := os.Create(exp.outfileWorkbook)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	err = exp.WriteWorkbook(file)
+	if err != nil {
+		return err
+	}
+	exp.log.Info("wrote workbook to file", "filename", exp.outfileWorkbook)
+	return err
+}
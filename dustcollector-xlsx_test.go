@@ -0,0 +1,242 @@
+package dustcollector
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// xlsxTestCell is a cell of the worksheet XML.
+type xlsxTestCell struct {
+	Ref     string `xml:"r,attr"`
+	Type    string `xml:"t,attr"`
+	Formula string `xml:"f"`
+	Value   string `xml:"v"`
+	Inline  string `xml:"is>t"`
+}
+
+// text returns the cached or inline value of the cell.
+func (c xlsxTestCell) text() string {
+	if c.Type == "inlineStr" {
+		return c.Inline
+	}
+	return c.Value
+}
+
+type xlsxTestSheet struct {
+	Rows []struct {
+		Cells []xlsxTestCell `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// formulaToken matches a single token of the formulas the workbook uses.
+var formulaToken = regexp.MustCompile(
+	`^(?:"[^"]*"|([A-Za-z]+)!|\$?([A-Z]+)\$?([0-9]+)|[A-Z]+:[A-Z]+|([A-Z]+)\(|TRUE|FALSE|[0-9]+(?:\.[0-9]+)?|[-+*/,:()])`,
+)
+
+// checkFormula tokenizes formula and checks that its parentheses
+// balance, its functions are known, and the sheets and cells it refers
+// to exist.
+func checkFormula(formula string, sheets map[string]bool) error {
+	var depth int
+	for rest := formula; rest != ""; {
+		m := formulaToken.FindStringSubmatch(rest)
+		if m == nil {
+			return fmt.Errorf("can't parse %q", rest)
+		}
+		switch {
+		case m[1] != "" && !sheets[m[1]]:
+			return fmt.Errorf("unknown sheet %s", m[1])
+		case m[2] != "" && m[3] == "0":
+			return fmt.Errorf("invalid cell %s", m[0])
+		case m[4] != "":
+			switch m[4] {
+			case "COUNTA", "COUNTIF", "SUMIF":
+			default:
+				return fmt.Errorf("unknown function %s", m[4])
+			}
+			depth++
+		case m[0] == "(":
+			depth++
+		case m[0] == ")":
+			depth--
+			if depth < 0 {
+				return fmt.Errorf("unbalanced )")
+			}
+		}
+		rest = rest[len(m[0]):]
+	}
+	if depth != 0 {
+		return fmt.Errorf("unbalanced (")
+	}
+	return nil
+}
+
+// TestWorkbook unzips the workbook of a scan and checks the package
+// parts, the sheet XML, and the formulas.
+func TestWorkbook(t *testing.T) {
+	exp := newFakeExpedition(t, &fakeAWS{snaps: 20, pageSize: 100, images: 3}, nil)
+	if err := exp.Start(); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := exp.WriteWorkbook(&buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[f.Name] = data
+		// every part must be well-formed XML
+		dec := xml.NewDecoder(bytes.NewReader(data))
+		for {
+			_, err := dec.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: %s", f.Name, err)
+			}
+		}
+	}
+
+	var types struct {
+		Defaults []struct {
+			Extension string `xml:",attr"`
+		} `xml:"Default"`
+		Overrides []struct {
+			PartName    string `xml:",attr"`
+			ContentType string `xml:",attr"`
+		} `xml:"Override"`
+	}
+	if err := xml.Unmarshal(parts["[Content_Types].xml"], &types); err != nil {
+		t.Fatalf("[Content_Types].xml: %s", err)
+	}
+	declared := make(map[string]string)
+	for _, o := range types.Overrides {
+		declared[strings.TrimPrefix(o.PartName, "/")] = o.ContentType
+	}
+	for _, d := range types.Defaults {
+		declared["*."+d.Extension] = "default"
+	}
+	for name := range parts {
+		if name == "[Content_Types].xml" {
+			continue
+		}
+		ext := name[strings.LastIndex(name, ".")+1:]
+		if declared[name] == "" && declared["*."+ext] == "" {
+			t.Errorf("%s has no content type", name)
+		}
+	}
+	for name := range declared {
+		if !strings.HasPrefix(name, "*.") && parts[name] == nil {
+			t.Errorf("[Content_Types].xml declares missing part %s", name)
+		}
+	}
+	if ct := declared["xl/workbook.xml"]; !strings.HasSuffix(ct, "sheet.main+xml") {
+		t.Errorf("xl/workbook.xml has content type %q", ct)
+	}
+
+	var workbook struct {
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := xml.Unmarshal(parts["xl/workbook.xml"], &workbook); err != nil {
+		t.Fatal(err)
+	}
+	sheetNames := make(map[string]bool)
+	var names []string
+	for _, s := range workbook.Sheets {
+		sheetNames[s.Name] = true
+		names = append(names, s.Name)
+	}
+	if got := strings.Join(names, ","); got != "Summary,Nuggets,Bars,Plan,Spared" {
+		t.Fatalf("got sheets %s", got)
+	}
+
+	cells := make(map[string]xlsxTestCell)
+	var formulas int
+	for i, name := range names {
+		part := fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1)
+		if !strings.HasSuffix(declared[part], "worksheet+xml") {
+			t.Errorf("%s has content type %q", part, declared[part])
+		}
+		var sheet xlsxTestSheet
+		if err := xml.Unmarshal(parts[part], &sheet); err != nil {
+			t.Fatalf("%s: %s", part, err)
+		}
+		for r, row := range sheet.Rows {
+			// empty cells are left out so only the row is known
+			for _, cell := range row.Cells {
+				column := strings.TrimRight(cell.Ref, "0123456789")
+				if column == "" || strings.Trim(column, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" ||
+					cell.Ref[len(column):] != strconv.Itoa(r+1) {
+					t.Errorf("%s: cell %s in row %d", name, cell.Ref, r+1)
+				}
+				cells[name+"!"+cell.Ref] = cell
+				if cell.Formula == "" {
+					continue
+				}
+				formulas++
+				if err := checkFormula(cell.Formula, sheetNames); err != nil {
+					t.Errorf("%s!%s: formula %q: %s", name, cell.Ref, cell.Formula, err)
+				}
+			}
+		}
+	}
+	if formulas < 6 {
+		t.Errorf("found %d formulas, want the Summary totals and the Bars costs", formulas)
+	}
+
+	// the cached values match the results
+	summary := exp.GetSummary()
+	for ref, want := range map[string]string{
+		"Summary!A1": "Account",
+		"Summary!B1": "123456789012",
+		"Summary!B4": strconv.Itoa(len(exp.Nuggets)),
+		"Summary!B5": strconv.Itoa(len(summary.Snapshots)),
+		"Summary!B6": strconv.Itoa(summary.SparedCount),
+		"Summary!B7": strconv.FormatInt(summary.TotalGbs, 10),
+		"Bars!G1":    "MonthlyCost",
+	} {
+		if got := cells[ref].text(); got != want {
+			t.Errorf("%s is %q, want %q", ref, got, want)
+		}
+	}
+	if f := cells["Bars!G2"].Formula; f != "E2*Summary!$B$2" {
+		t.Errorf("Bars!G2 has formula %q", f)
+	}
+}
+
+// TestCheckFormula makes sure checkFormula rejects broken formulas.
+func TestCheckFormula(t *testing.T) {
+	sheets := map[string]bool{"Summary": true}
+	for _, f := range []string{"SUM(A1", "B0*2", "Missing!A1", "NOW()", "A1)", "A1 & B1"} {
+		if checkFormula(f, sheets) == nil {
+			t.Errorf("%q was accepted", f)
+		}
+	}
+	if err := checkFormula(`COUNTIF(Summary!B:B,"x")*Summary!$B$2`, sheets); err != nil {
+		t.Error(err)
+	}
+}
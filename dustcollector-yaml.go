@@ -0,0 +1,398 @@
+package dustcollector
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// yamlToJSON converts a YAML config to JSON so that it is decoded and
+// validated exactly like a JSON config. Only the subset of YAML needed
+// to write a Config is supported: block mappings and sequences, flow
+// [sequences] and {mappings}, quoted and plain scalars, and comments.
+// Anchors, aliases, tags, multi-line strings, and multiple documents
+// are rejected.
+func yamlToJSON(data []byte) ([]byte, error) {
+	p := yamlParser{}
+	for i, text := range strings.Split(strings.Replace(string(data), "\r\n", "\n", -1), "\n") {
+		num := i + 1
+		text = strings.TrimRight(stripYAMLComment(text), " \t")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || (trimmed == "---" && len(p.lines) == 0) {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: tabs can't be used for indentation", num)
+		}
+		if trimmed == "---" || trimmed == "..." {
+			return nil, fmt.Errorf("line %d: only one YAML document is supported", num)
+		}
+		p.lines = append(p.lines, yamlLine{num: num, indent: len(text) - len(trimmed), text: trimmed})
+	}
+	var root interface{} = map[string]interface{}{}
+	if len(p.lines) > 0 {
+		var err error
+		root, err = p.parseBlock(p.lines[0].indent)
+		if err != nil {
+			return nil, err
+		}
+		if p.pos < len(p.lines) {
+			return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].num)
+		}
+	}
+	return json.Marshal(root)
+}
+
+// yamlLine is a non-empty line of YAML without its comment.
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// stripYAMLComment removes a # comment that starts the line or follows
+// whitespace outside of quotes.
+func stripYAMLComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t'):
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// isSequenceItem reports whether text starts a block sequence item.
+func isSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// parseBlock parses the mapping or sequence whose lines start at indent.
+func (p *yamlParser) parseBlock(indent int) (interface{}, error) {
+	if isSequenceItem(p.lines[p.pos].text) {
+		return p.parseSequence(indent)
+	}
+	return p.parseMapping(indent)
+}
+
+// parseNested parses the value of a mapping key or sequence item whose
+// inline value is empty: a block indented further than indent, a
+// sequence at the same indent as a mapping key, or null.
+func (p *yamlParser) parseNested(indent int, key bool) (interface{}, error) {
+	if p.pos >= len(p.lines) {
+		return nil, nil
+	}
+	next := p.lines[p.pos]
+	if next.indent > indent || (key && next.indent == indent && isSequenceItem(next.text)) {
+		return p.parseBlock(next.indent)
+	}
+	return nil, nil
+}
+
+func (p *yamlParser) parseMapping(indent int) (interface{}, error) {
+	m := map[string]interface{}{}
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent {
+		line := p.lines[p.pos]
+		if isSequenceItem(line.text) {
+			return nil, fmt.Errorf("line %d: expected a mapping key, found a sequence item", line.num)
+		}
+		colon := yamlKeyEnd(line.text)
+		if colon < 0 {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", line.num)
+		}
+		key, err := yamlScalarString(strings.TrimSpace(line.text[:colon]), line.num)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := m[key]; ok {
+			return nil, fmt.Errorf("line %d: duplicate key %q", line.num, key)
+		}
+		rest := strings.TrimSpace(line.text[colon+1:])
+		p.pos++
+		if rest == "" {
+			m[key], err = p.parseNested(indent, true)
+		} else {
+			m[key], err = parseYAMLInline(rest, line.num)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].num)
+	}
+	return m, nil
+}
+
+func (p *yamlParser) parseSequence(indent int) (interface{}, error) {
+	s := []interface{}{}
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isSequenceItem(p.lines[p.pos].text) {
+		line := p.lines[p.pos]
+		item := strings.TrimLeft(line.text[1:], " ")
+		if item == "" {
+			p.pos++
+			value, err := p.parseNested(indent, false)
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, value)
+			continue
+		}
+		if isSequenceItem(item) || yamlKeyEnd(item) >= 0 {
+			// "- key: value" starts a mapping whose keys are
+			// aligned with the first one, "- - item" a sequence
+			p.lines[p.pos] = yamlLine{num: line.num, indent: indent + len(line.text) - len(item), text: item}
+			value, err := p.parseBlock(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, value)
+			continue
+		}
+		p.pos++
+		value, err := parseYAMLInline(item, line.num)
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, value)
+	}
+	if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].num)
+	}
+	return s, nil
+}
+
+// yamlKeyEnd returns the index of the colon ending the mapping key at
+// the start of text, or -1 if text isn't a "key: value" pair.
+func yamlKeyEnd(text string) int {
+	if text == "" || strings.ContainsRune("[{", rune(text[0])) {
+		return -1
+	}
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && i == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == ':' && (i+1 == len(text) || text[i+1] == ' '):
+			return i
+		}
+	}
+	return -1
+}
+
+// parseYAMLInline parses a value written on the same line as its key
+// or sequence dash.
+func parseYAMLInline(s string, num int) (interface{}, error) {
+	if strings.ContainsRune("|>&*!%@`?", rune(s[0])) {
+		return nil, fmt.Errorf("line %d: unsupported YAML syntax %q", num, s)
+	}
+	if s[0] == '[' || s[0] == '{' {
+		f := yamlFlow{s: s, num: num}
+		value, err := f.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		f.skipSpace()
+		if f.pos < len(f.s) {
+			return nil, fmt.Errorf("line %d: unexpected %q after %c", num, f.s[f.pos:], s[0])
+		}
+		return value, nil
+	}
+	return yamlScalar(s, num)
+}
+
+var (
+	yamlInt   = regexp.MustCompile(`^[-+]?(0|[1-9][0-9]*)$`)
+	yamlFloat = regexp.MustCompile(`^[-+]?([0-9]+\.[0-9]*|\.[0-9]+|[0-9]+)([eE][-+]?[0-9]+)?$`)
+)
+
+// yamlScalar resolves a quoted or plain scalar to a string, number,
+// bool, or nil.
+func yamlScalar(s string, num int) (interface{}, error) {
+	if s[0] == '"' || s[0] == '\'' {
+		return yamlScalarString(s, num)
+	}
+	switch s {
+	case "~", "null", "Null", "NULL":
+		return nil, nil
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	}
+	if yamlInt.MatchString(s) {
+		return json.Number(strings.TrimPrefix(s, "+")), nil
+	}
+	if yamlFloat.MatchString(s) {
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f, nil
+		}
+	}
+	return s, nil
+}
+
+// yamlScalarString returns the string value of a quoted or plain
+// scalar, e.g. a mapping key.
+func yamlScalarString(s string, num int) (string, error) {
+	if s == "" {
+		return "", fmt.Errorf("line %d: empty key", num)
+	}
+	switch s[0] {
+	case '\'':
+		if len(s) < 2 || s[len(s)-1] != '\'' {
+			return "", fmt.Errorf("line %d: unterminated string %s", num, s)
+		}
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1), nil
+	case '"':
+		var value string
+		err := json.Unmarshal([]byte(s), &value)
+		if err != nil {
+			return "", fmt.Errorf("line %d: invalid string %s", num, s)
+		}
+		return value, nil
+	}
+	return s, nil
+}
+
+// yamlFlow parses a flow sequence or mapping written on one line.
+type yamlFlow struct {
+	s   string
+	pos int
+	num int
+}
+
+func (f *yamlFlow) skipSpace() {
+	for f.pos < len(f.s) && f.s[f.pos] == ' ' {
+		f.pos++
+	}
+}
+
+func (f *yamlFlow) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: "+format, append([]interface{}{f.num}, args...)...)
+}
+
+func (f *yamlFlow) parseValue() (interface{}, error) {
+	f.skipSpace()
+	if f.pos >= len(f.s) {
+		return nil, f.errorf("unterminated flow collection")
+	}
+	switch f.s[f.pos] {
+	case '[':
+		return f.parseCollection(']')
+	case '{':
+		return f.parseCollection('}')
+	}
+	return f.parseScalar(",]}")
+}
+
+// parseScalar parses a quoted scalar or a plain one ending before any
+// of the stop characters.
+func (f *yamlFlow) parseScalar(stop string) (interface{}, error) {
+	start := f.pos
+	if c := f.s[f.pos]; c == '"' || c == '\'' {
+		for f.pos++; f.pos < len(f.s) && f.s[f.pos] != c; f.pos++ {
+			if c == '"' && f.s[f.pos] == '\\' {
+				f.pos++
+			} else if c == '\'' && f.pos+1 < len(f.s) && f.s[f.pos:f.pos+2] == "''" {
+				f.pos++
+			}
+		}
+		if f.pos >= len(f.s) {
+			return nil, f.errorf("unterminated string %s", f.s[start:])
+		}
+		f.pos++
+		return yamlScalarString(f.s[start:f.pos], f.num)
+	}
+	for f.pos < len(f.s) && !strings.ContainsRune(stop, rune(f.s[f.pos])) {
+		if f.s[f.pos] == ':' && strings.ContainsRune(stop, ':') && (f.pos+1 == len(f.s) || f.s[f.pos+1] == ' ') {
+			break
+		}
+		f.pos++
+	}
+	plain := strings.TrimSpace(f.s[start:f.pos])
+	if plain == "" {
+		return nil, f.errorf("missing value in %s", f.s)
+	}
+	return yamlScalar(plain, f.num)
+}
+
+// parseCollection parses a flow sequence ending with ']' or a flow
+// mapping ending with '}'.
+func (f *yamlFlow) parseCollection(end byte) (interface{}, error) {
+	f.pos++
+	var s []interface{}
+	m := map[string]interface{}{}
+	for {
+		f.skipSpace()
+		if f.pos < len(f.s) && f.s[f.pos] == end {
+			f.pos++
+			break
+		}
+		if end == ']' {
+			value, err := f.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, value)
+		} else {
+			key, err := f.parseScalar(",:}")
+			if err != nil {
+				return nil, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				name = fmt.Sprint(key)
+			}
+			f.skipSpace()
+			if f.pos >= len(f.s) || f.s[f.pos] != ':' {
+				return nil, f.errorf("expected \":\" after key %q", name)
+			}
+			f.pos++
+			if _, ok := m[name]; ok {
+				return nil, f.errorf("duplicate key %q", name)
+			}
+			m[name], err = f.parseValue()
+			if err != nil {
+				return nil, err
+			}
+		}
+		f.skipSpace()
+		if f.pos >= len(f.s) {
+			return nil, f.errorf("unterminated flow collection")
+		}
+		switch f.s[f.pos] {
+		case ',':
+			f.pos++
+		case end:
+		default:
+			return nil, f.errorf("expected \",\" or %q, found %q", end, f.s[f.pos:])
+		}
+	}
+	if end == ']' {
+		if s == nil {
+			s = []interface{}{}
+		}
+		return s, nil
+	}
+	return m, nil
+}
@@ -1,15 +1,21 @@
 package dustcollector
 
 import (
+	"bytes"
+	"context"
 	"encoding/csv"
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/ec2"
@@ -18,9 +24,10 @@ import (
 )
 
 // describeLaunchTemplates describes all launch templates for the given session
-// including pagination handling. It returns a slice of LaunchTemplateVersion pointers
-// for easy processing in other functions as well as any errors.
-func (exp *Expedition) describeLaunchTemplates() (lts []*ec2.LaunchTemplateVersion, err error) {
+// including pagination handling, fetching pages for as long as more allows. It returns
+// a slice of LaunchTemplateVersion pointers for easy processing in other functions as
+// well as any errors.
+func (exp *Expedition) describeLaunchTemplates(ctx context.Context, more pager) (lts []*ec2.LaunchTemplateVersion, err error) {
 	exp.log.Info("grabbing all latest launch template versions")
 	svc := ec2.New(exp.session)
 	ltVersionLatest := "$Latest"
@@ -29,62 +36,33 @@ func (exp *Expedition) describeLaunchTemplates() (lts []*ec2.LaunchTemplateVersi
 	input := ec2.DescribeLaunchTemplateVersionsInput{
 		Versions: versions,
 	}
-	results, err := svc.DescribeLaunchTemplateVersions(&input)
-	if err != nil {
-		return lts, err
-	}
-	lts = results.LaunchTemplateVersions
-	i := 2
-	max := 50
-	for i < max {
-		exp.log.Debug("handling launchtemplate results", "page", i)
-		if results.NextToken != nil {
-			input = ec2.DescribeLaunchTemplateVersionsInput{
-				NextToken: results.NextToken,
-			}
-			results, err = svc.DescribeLaunchTemplateVersions(&input)
-			if err != nil {
-				return lts, err
-			}
-			lts = append(lts, results.LaunchTemplateVersions...)
-		} else {
-			break
-		}
-		i += 1
-	}
+	pageNum := 0
+	err = svc.DescribeLaunchTemplateVersionsPagesWithContext(ctx, &input,
+		func(page *ec2.DescribeLaunchTemplateVersionsOutput, lastPage bool) bool {
+			pageNum++
+			exp.log.Debug("handling launchtemplate results", "page", pageNum)
+			lts = append(lts, page.LaunchTemplateVersions...)
+			return more(ListingLaunchTemplates, pageNum, len(lts), lastPage)
+		})
 	return lts, err
 }
 
 // describeLaunchConfigurations describes all launch configurations for the given session
-// including pagination handling. It returns a slice of LaunchConfiguration pointers
-// for easy processing in other functions as well as any errors.
-func (exp *Expedition) describeLaunchConfigurations() (lcs []*autoscaling.LaunchConfiguration, err error) {
+// including pagination handling, fetching pages for as long as more allows. It returns
+// a slice of LaunchConfiguration pointers for easy processing in other functions as
+// well as any errors.
+func (exp *Expedition) describeLaunchConfigurations(ctx context.Context, more pager) (lcs []*autoscaling.LaunchConfiguration, err error) {
 	exp.log.Debug("grabbing all launch configurations")
 	svc := autoscaling.New(exp.session)
 	input := autoscaling.DescribeLaunchConfigurationsInput{}
-	results, err := svc.DescribeLaunchConfigurations(&input)
-	if err != nil {
-		return lcs, err
-	}
-	lcs = results.LaunchConfigurations
-	i := 2
-	max := 50
-	for i < max {
-		exp.log.Debug("handling launchconfig results", "page", i)
-		if results.NextToken != nil {
-			input = autoscaling.DescribeLaunchConfigurationsInput{
-				NextToken: results.NextToken,
-			}
-			results, err = svc.DescribeLaunchConfigurations(&input)
-			if err != nil {
-				return lcs, err
-			}
-			lcs = append(lcs, results.LaunchConfigurations...)
-		} else {
-			break
-		}
-		i += 1
-	}
+	pageNum := 0
+	err = svc.DescribeLaunchConfigurationsPagesWithContext(ctx, &input,
+		func(page *autoscaling.DescribeLaunchConfigurationsOutput, lastPage bool) bool {
+			pageNum++
+			exp.log.Debug("handling launchconfig results", "page", pageNum)
+			lcs = append(lcs, page.LaunchConfigurations...)
+			return more(ListingLaunchConfigurations, pageNum, len(lcs), lastPage)
+		})
 	return lcs, err
 }
 
@@ -92,7 +70,7 @@ func (exp *Expedition) describeLaunchConfigurations() (lcs []*autoscaling.Launch
 // properties of that AMI. It returns a slice of account number strings where
 // the image is shared and any error. If the image is "public" it returns a slice of length
 // 1 with the only item being "all".
-func (exp *Expedition) imageSharedTo(ami string) (accts []string, err error) {
+func (exp *Expedition) imageSharedTo(ctx context.Context, ami string) (accts []string, err error) {
 	exp.log.Debug("describing image attributes for sharing", "ami", ami)
 	svc := ec2.New(exp.session)
 	launchPermissionAttr := "launchPermission"
@@ -100,7 +78,7 @@ func (exp *Expedition) imageSharedTo(ami string) (accts []string, err error) {
 		Attribute: &launchPermissionAttr,
 		ImageId:   &ami,
 	}
-	results, err := svc.DescribeImageAttribute(&input)
+	results, err := svc.DescribeImageAttributeWithContext(ctx, &input)
 	if err != nil {
 		return accts, err
 	}
@@ -123,92 +101,103 @@ func (exp *Expedition) imageSharedTo(ami string) (accts []string, err error) {
 // are being used for other AWS services including: LaunchTemplates, LaunchConfigurations,
 // AMIs, CreateVolumePermissions, and AutoScalingGroups. It adds this additional
 // metadata to each nugget object and returns any errors.
-func (exp *Expedition) populateNuggets() (err error) {
+func (exp *Expedition) populateNuggets(ctx context.Context) (err error) {
 
 	// grab all launch configs for later lookup
-	lcs, err := exp.describeLaunchConfigurations()
+	lcs, err := exp.describeLaunchConfigurations(ctx, exp.morePages)
 	if err != nil {
 		return err
 	}
 	// grab all launch templates for later lookup
-	lts, err := exp.describeLaunchTemplates()
+	lts, err := exp.describeLaunchTemplates(ctx, exp.morePages)
 	if err != nil {
 		return err
 	}
+	// remember which version was latest so Apply can detect
+	// templates that changed after the analysis
+	exp.ltVersions = make(map[string]int64)
+	for _, lt := range lts {
+		exp.ltVersions[*lt.LaunchTemplateName] = *lt.VersionNumber
+	}
 
-	images, err := describeImagesOwnedByThisAccount(exp.session)
+	images, err := exp.describeImages(ctx, exp.morePages)
 	if err != nil {
 		return err
 	}
 	// grab autoscaling groups so we can find launchconfigs in use
-	asgs, err := describeASGs(exp.session)
+	asgs, err := exp.describeASGs(ctx, exp.morePages)
 	if err != nil {
 		return err
 	}
-	// loop through images result and find out if there is an orphaned
-	// snapshot with same ID
-	for _, image := range images {
-		for _, snap := range exp.Nuggets {
-			for _, bdm := range image.BlockDeviceMappings {
-				if bdm.Ebs != nil {
-					if bdm.Ebs.SnapshotId != nil {
-						if *snap.Snap.SnapshotId == *bdm.Ebs.SnapshotId {
-							msg := fmt.Sprintf(
-								"mapping %s to %s", *snap.Snap.SnapshotId, *image.ImageId,
-							)
-							exp.log.Debug(msg)
-							snap.AMIIDs = append(snap.AMIIDs, *image.ImageId)
-							// now find out if any launch configs use this AMI or snapshot ID
-							snap.LCs = lcsWithSnapImage(lcs, *snap.Snap.SnapshotId, *image.ImageId)
-							// now find out if any launch templates use this AMI or snapshot ID
-							snap.LTs = ltsWithSnapImage(lts, *snap.Snap.SnapshotId, *image.ImageId)
-							// now find out if any AGS use this launch template/config
-							for _, lc := range snap.LCs {
-								_, snap.ASGs = lcInASGs(lc, asgs)
-							}
-							for _, lt := range snap.LTs {
-								_, snap.ASGs = ltInASGs(lt, asgs)
-							}
-							// now find out where image is shared to
-							snap.AMISharedWith, err = exp.imageSharedTo(*image.ImageId)
-							if err != nil {
-								return err
-							}
-						}
-					}
-				}
-			}
+	idx := newRelationIndex(images, lcs, lts, asgs)
+	amis := make(map[string]*AMI)
+	shared := make(map[string][]string)
+	for _, nug := range exp.Nuggets {
+		err = exp.associate(ctx, idx, nug, amis, shared)
+		if err != nil {
+			return err
 		}
 	}
 	return err
 }
 
 func (exp *Expedition) addBars() {
+	// index the existing bars by volume so each Nugget is grouped
+	// with a single lookup
+	bars := make(map[string]*Bar, len(exp.Bars))
+	for _, bar := range exp.Bars {
+		bars[*bar.VolumeId] = bar
+	}
 	for _, nug := range exp.Nuggets {
-		exp.addBar(nug)
+		exp.addBar(bars, nug)
 	}
 }
 
-func (exp *Expedition) addBar(n *Nugget) {
-	found := false
+func (exp *Expedition) addBar(bars map[string]*Bar, n *Nugget) {
 	// group snapshots by volumeID
+	if bar, ok := bars[*n.Snap.VolumeId]; ok {
+		n.parentBar = bar
+		bar.Nuggets = append(bar.Nuggets, n)
+		return
+	}
+	// make new bar
+	b := Bar{
+		VolumeId: n.Snap.VolumeId,
+	}
+	n.parentBar = &b
+	b.Nuggets = append(b.Nuggets, n)
+	b.HasVol = n.HasVol
+	exp.Bars = append(exp.Bars, &b)
+	bars[*b.VolumeId] = &b
+}
+
+// deletableBars returns the Bars with at least one snapshot in the
+// deletion plan along with their combined volume size in GB which is
+// used to estimate the potential savings.
+func (exp *Expedition) deletableBars() (bars []*Bar, totalGbs int64) {
+	return exp.barsWithSnapshots(exp.SnapToDelete)
+}
+
+// barsWithSnapshots returns the Bars without a volume that contain any
+// of the given snapshots, counting each Bar once.
+func (exp *Expedition) barsWithSnapshots(snapshotIds []string) (bars []*Bar, totalGbs int64) {
+	toDelete := make(map[string]bool)
+	for _, snap := range snapshotIds {
+		toDelete[snap] = true
+	}
 	for _, bar := range exp.Bars {
-		if *bar.VolumeId == *n.Snap.VolumeId {
-			n.parentBar = bar
-			bar.Nuggets = append(bar.Nuggets, n)
-			found = true
+		if bar.HasVol {
+			continue
 		}
-	}
-	if !found {
-		// make new bar
-		b := Bar{
-			VolumeId: n.Snap.VolumeId,
+		for _, nug := range bar.Nuggets {
+			if toDelete[*nug.Snap.SnapshotId] {
+				bars = append(bars, bar)
+				totalGbs += *bar.Nuggets[0].Snap.VolumeSize
+				break
+			}
 		}
-		n.parentBar = &b
-		b.Nuggets = append(b.Nuggets, n)
-		b.HasVol = n.HasVol
-		exp.Bars = append(exp.Bars, &b)
 	}
+	return bars, totalGbs
 }
 
 // GetRecommendations takes all of the information acquired during the
@@ -218,97 +207,105 @@ func (exp *Expedition) GetRecommendations() (msg []string) {
 	return exp.recommendations
 }
 
+// DefaultRecommendationsTemplate is the text/template used to build the
+// recommendations returned by GetRecommendations when
+// ExpeditionInput.RecommendationsTemplate is not set. It is executed
+// with a *Summary and each line of the output becomes one
+// recommendation. The wording comes from the message catalog of the
+// Expedition language through the "t" function.
+const DefaultRecommendationsTemplate = `
+{{- range .Truncations}}{{t "truncated" (t (print "listing." .Listing)) .Pages .Items}}
+{{end -}}
+{{if .AssociationsTruncated}}{{t "truncated.associations"}}
+{{end -}}
+{{if .Snapshots -}}
+{{t "intro" (len .Snapshots) .DateFilter}}
+{{- if or .LaunchTemplates .LaunchConfigurations .AMIs}} {{t "intro.blockers"}}
+
+{{t "warning"}}
+
+{{t "warning.plan"}}
+{{- else}} {{t "intro.plan"}}
+{{- end}}
+{{range .Stages}}{{t (print "stage." .Position) (t (print "type." .ResourceType))}}
+{{range .Steps}}	{{.ResourceId}}
+{{end}}{{end}}
+{{- else -}}
+{{t "nothing" .DateFilter}}
+{{end -}}
+{{t "spared.volume" .SparedHasVolume}}
+{{t "spared.inuse" .SparedInUse}}
+{{if .SparedRecentlyLaunched}}{{t "spared.launched" .SparedRecentlyLaunched}}
+{{end -}}
+{{if .SparedExempt}}{{t "spared.exempt" .SparedExempt}}
+{{end -}}
+{{t "savings" .TotalGbs .Rate .Savings}}
+`
+
 // setRecommendations takes all of the information acquired during the
 // Expedition and sets a string slice containing recommendations
 // for an ordered action plan for removing orphaned resources
-func (exp *Expedition) setRecommendations() {
-	var msg []string
-	var countAsgShare int
-	var countHasVol int
-	var barsToDelete []*Bar
+func (exp *Expedition) setRecommendations() (err error) {
 	for _, bar := range exp.Bars {
 		if !bar.HasVol {
 			for _, nug := range bar.Nuggets {
-				if len(nug.ASGs) == 0 && len(nug.AMISharedWith) == 0 {
+				if exp.isExempt(nug) {
+					nug.SpareReason = SpareExempt
+				} else if len(nug.ASGs) > 0 {
+					nug.SpareReason = SpareInASG
+				} else if len(nug.AMISharedWith) > 0 {
+					nug.SpareReason = SpareShared
+				} else if exp.launchedRecently(nug.AMIs...) {
+					nug.SpareReason = SpareRecentlyLaunched
+				} else {
 					// safe to delete
 					exp.LtsToDelete = append(exp.LtsToDelete, nug.LTs...)
 					exp.LcsToDelete = append(exp.LcsToDelete, nug.LCs...)
 					exp.AmiToDelete = append(exp.AmiToDelete, nug.AMIIDs...)
 					exp.SnapToDelete = append(exp.SnapToDelete, *nug.Snap.SnapshotId)
-					barsToDelete = append(barsToDelete, nug.parentBar)
-				} else {
-					countAsgShare++
 				}
 			}
 		} else {
-			countHasVol++
+			for _, nug := range bar.Nuggets {
+				nug.SpareReason = SpareHasVolume
+			}
 		}
 	}
 	exp.LtsToDelete = dedupeString(exp.LtsToDelete)
 	exp.LcsToDelete = dedupeString(exp.LcsToDelete)
 	exp.AmiToDelete = dedupeString(exp.AmiToDelete)
 	exp.SnapToDelete = dedupeString(exp.SnapToDelete)
-	intro := fmt.Sprintf("After analyzing the account we can see that there "+
-		"are %d snapshots that can be deleted because they were created "+
-		"before %s and are not used in any AutoScaling group or AMI sharing "+
-		"capacity. However, before these snapshots can be deleted several "+
-		"other resources need to be deleted first. Below you can find the "+
-		"ordered deletion plan:\n\n", len(exp.SnapToDelete), exp.dateFilter)
-	msg = append(msg, intro)
-	msg = append(msg, "Some of the snapshots we need to delete are "+
-		"currently registered as AMIs or used in Launch Templates/Configs. "+
-		"However we've detected that those AMI's and Launch Templates/Configs "+
-		"are not used in any autoscaling group. This doesn't mean they're "+
-		"not being used by someone (e.g., referenced in a cloudformation "+
-		"template). You should be safe to delete them but you should always "+
-		"check to be sure\n\nIf you feel comfortable then here's the plan:\n")
-	msg = append(msg, "Delete the following LaunchTemplates first:")
-	for _, lt := range exp.LtsToDelete {
-		msg = append(msg, "\t"+lt)
-	}
-	msg = append(msg, "then delete the following LaunchConfigurations:")
-	for _, lc := range exp.LcsToDelete {
-		msg = append(msg, "\t"+lc)
-	}
-	msg = append(msg, "then delete the following AMIs:")
-	for _, ami := range exp.AmiToDelete {
-		msg = append(msg, "\t"+ami)
-	}
-	msg = append(msg, "then finally delete the following Snapshots:")
-	for _, snap := range exp.SnapToDelete {
-		msg = append(msg, "\t"+snap)
-	}
-	msg = append(
-		msg,
-		fmt.Sprintf(
-			"%d snapshots were spared because their EBS volume still exists",
-			countHasVol,
-		),
+	exp.setPlan()
+	tmpl, err := template.New("recommendations").Funcs(exp.summaryFuncs()).Parse(
+		exp.recTemplate,
 	)
-	msg = append(
-		msg,
-		fmt.Sprintf(
-			"%d snapshots were spared because they were associated with an "+
-				"autoscaling group, were shared directly to another account, "+
-				"or were registered as an AMI that was shared to another account.",
-			countAsgShare,
-		),
-	)
-	// now add cost analysis
-	var totalGbs int64
-	for _, b := range barsToDelete {
-		if !b.HasVol {
-			totalGbs += *b.Nuggets[0].Snap.VolumeSize
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, exp.GetSummary())
+	if err != nil {
+		return err
+	}
+	exp.recommendations = strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	return err
+}
+
+// isExempt reports whether the ExemptSnapshots or ExemptTags keep the
+// snapshot of the Nugget out of the deletion plan.
+func (exp *Expedition) isExempt(nug *Nugget) bool {
+	if exp.exemptSnapshots[*nug.Snap.SnapshotId] {
+		return true
+	}
+	for _, tag := range nug.Snap.Tags {
+		if tag.Key == nil || tag.Value == nil {
+			continue
+		}
+		if v, ok := exp.exemptTags[*tag.Key]; ok && (v == "" || v == *tag.Value) {
+			return true
 		}
 	}
-	totalSavings := float64(totalGbs) * exp.ebsSnapRate
-	s := fmt.Sprintf(
-		"Total size of eligible for deletion "+
-			"is %d GB. At a per GB-month rate of $%f "+
-			"there is a potential savings of $%f",
-		totalGbs, exp.ebsSnapRate, totalSavings)
-	msg = append(msg, s)
-	exp.recommendations = msg
+	return false
 }
 
 // An Expedition contains the properties and methods necessary
@@ -357,16 +354,27 @@ type Expedition struct {
 	// resources in AmiToDelete, LcToDelete, LtToDelete first.
 	SnapToDelete []string
 
+	// After the Start method is complete Plan will contain
+	// the ordered deletion plan built from LtsToDelete, LcsToDelete,
+	// AmiToDelete, and SnapToDelete. When Apply is called the
+	// outcome of each step is recorded on the step itself.
+	Plan []*PlanStep
+
+	// Truncated is set when MaxPages or MaxSnapshots stopped any
+	// listing before its last page and Truncations lists which ones
+	// were cut short. The plan is built from incomplete data and
+	// must be reviewed before it is applied.
+	Truncated   bool
+	Truncations []Truncation
+
 	account                string
 	cutoffDate             time.Time
 	maxPages               int
+	maxSnapshots           int
 	pageSize               int
 	volBatchSize           int
+	volWorkers             int
 	session                *session.Session
-	wgq                    sync.WaitGroup
-	wgv                    sync.WaitGroup
-	queue                  chan []*ec2.Snapshot
-	realVols               []*ec2.Volume
 	log                    log15.Logger
 	ebsSnapRate            float64
 	dateFilter             string
@@ -374,6 +382,35 @@ type Expedition struct {
 	outfileNuggets         string
 	outfileBars            string
 	recommendations        []string
+	ltVersions             map[string]int64
+	applyJournal           string
+	outfileScript          string
+	outfileIaCReport       string
+	terraformStateFiles    []string
+	scanTime               time.Time
+	scanDuration           time.Duration
+	stats                  apiStats
+	stateRegion            string
+	outfileGraphDot        string
+	outfileGraphMermaid    string
+	outfileState           string
+	outfileHTML            string
+	outfileMarkdown        string
+	markdownTemplate       string
+	recTemplate            string
+	language               string
+	messages               map[string]string
+	outfileWorkbook        string
+	ownerRules             []OwnerRule
+	outfileOwnerReports    string
+	exemptSnapshots        map[string]bool
+	exemptTags             map[string]string
+	spareLaunchedDays      int
+	lastLaunchUnsupported  bool
+	limiter                rateLimiter
+	hooks                  Hooks
+	hookMu                 sync.Mutex
+	phase                  string
 }
 
 // ExportRecommendations takes the current deletion plan and writes it to
@@ -428,7 +465,7 @@ func (exp *Expedition) ExportNuggets() (err error) {
 		"OwnerId", "SnapshotId", "ImageIds", "LaunchConfigNames",
 		"LaunchTemplateNames-LATEST_VERSION_ONLY!",
 		"ASGNames", "AMISharedWith", "HasVolume", "StartTime",
-		"Tags", "VolumeSize", "Description"}
+		"Tags", "VolumeSize", "Description", "Owner", "Associations"}
 	csvwriter.Write(header)
 	for _, nug := range exp.Nuggets {
 		row := nug.dumpString()
@@ -470,6 +507,19 @@ func (b *Bar) dumpString() (s []string) {
 	return s
 }
 
+// Reasons recorded in Nugget.SpareReason when a snapshot is left out of
+// the deletion plan.
+const (
+	SpareHasVolume        = "EBS volume still exists"
+	SpareInASG            = "associated with an autoscaling group"
+	SpareShared           = "shared to another account"
+	SpareRecentlyLaunched = "AMI launched recently"
+	SpareExempt           = "exempted by policy"
+)
+
+// spareReasons lists the spare reasons in the order they are reported.
+var spareReasons = []string{SpareHasVolume, SpareInASG, SpareShared, SpareRecentlyLaunched, SpareExempt}
+
 // Nugget is intended to hold additional metadata about a snapshot such as:
 //   * whether or not it's original volume still exists
 //   * if the snapshot has any create volume permissions in other accounts
@@ -489,6 +539,9 @@ type Nugget struct {
 	// AMI ID's associated with snapshot
 	AMIIDs []string
 
+	// Details of the AMIs in AMIIDs
+	AMIs []*AMI
+
 	// Account Numbers to which associated AMI ID's are shared
 	AMISharedWith []string
 
@@ -501,7 +554,23 @@ type Nugget struct {
 	// AutoScaling Groups associated with Launch Configs/Templates
 	ASGs []string
 
-	parentBar *Bar
+	// Every chain of AMI, Launch Config/Template, and AutoScaling
+	// Group through which the snapshot is used
+	Associations []Association
+
+	// Why the snapshot was left out of the deletion plan (one of
+	// SpareHasVolume, SpareInASG, SpareShared, SpareRecentlyLaunched, or
+	// SpareExempt). Empty if the snapshot is in the deletion plan.
+	SpareReason string
+
+	// Owner of the snapshot found by the first matching OwnerRule
+	// and the Source of that rule. Empty if no rule matched.
+	Owner       string
+	OwnerSource string
+
+	parentBar  *Bar
+	volumeTags []*ec2.Tag
+	amiTags    []*ec2.Tag
 }
 
 // dumpString is a method to export the Nugget object as a CSV string
@@ -531,15 +600,17 @@ func (nug *Nugget) dumpString() (s []string) {
 		stags,
 		strconv.FormatInt(*nug.Snap.VolumeSize, 10),
 		*nug.Snap.Description,
+		nug.Owner,
+		strings.Join(nug.AssociationChains(), "|"),
 	}
 	return s
 }
 
-func (exp *Expedition) getAccountNumber() (err error) {
+func (exp *Expedition) getAccountNumber(ctx context.Context) (err error) {
 	exp.log.Debug("getting account number")
 	svcSts := sts.New(exp.session)
 	gcii := sts.GetCallerIdentityInput{}
-	gci, err := svcSts.GetCallerIdentity(&gcii)
+	gci, err := svcSts.GetCallerIdentityWithContext(ctx, &gcii)
 	if err != nil {
 		return err
 	}
@@ -547,6 +618,40 @@ func (exp *Expedition) getAccountNumber() (err error) {
 	return err
 }
 
+// VerifyIdentity checks that the credentials and region of the Session
+// belong to the account and region the results were scanned in, e.g.
+// before applying a plan loaded from a saved state with LoadState.
+func (exp *Expedition) VerifyIdentity(ctx context.Context) (err error) {
+	svcSts := sts.New(exp.session)
+	gci, err := svcSts.GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return fmt.Errorf("verifying the account of the session: %s", err)
+	}
+	if aws.StringValue(gci.Account) != exp.account {
+		return fmt.Errorf(
+			"the session is for account %s but the results are for account %s",
+			aws.StringValue(gci.Account), exp.account,
+		)
+	}
+	sessionRegion := aws.StringValue(exp.session.Config.Region)
+	if sessionRegion != exp.region() {
+		return fmt.Errorf(
+			"the session is for region %s but the results are for region %s",
+			sessionRegion, exp.region(),
+		)
+	}
+	return nil
+}
+
+// region returns the AWS region the Expedition scanned. For an
+// Expedition loaded from a saved state it is the region of that scan.
+func (exp *Expedition) region() string {
+	if exp.stateRegion != "" {
+		return exp.stateRegion
+	}
+	return aws.StringValue(exp.session.Config.Region)
+}
+
 func (exp *Expedition) setDateFilter(datestring string) (err error) {
 	// parse date filter from flags
 	layout := "2006-01-02"
@@ -554,7 +659,36 @@ func (exp *Expedition) setDateFilter(datestring string) (err error) {
 	return err
 }
 
-func (exp *Expedition) getSnapshots() (err error) {
+// snapshotPage is a page of snapshots that passed the date filter.
+// Its volumes are described in numBatches batches.
+type snapshotPage struct {
+	num        int
+	nuggets    []*Nugget
+	numBatches int
+}
+
+// volumeBatch is a batch of volume IDs from one snapshotPage.
+type volumeBatch struct {
+	page    int
+	volumes []*string
+}
+
+// volumeBatchResult holds the volumes of a volumeBatch that exist.
+type volumeBatchResult struct {
+	page      int
+	batchSize int
+	volumes   []*ec2.Volume
+	err       error
+}
+
+// getSnapshots pages through the snapshots of the account and builds
+// a Nugget for every snapshot created before the date filter. Each
+// page's volumes are split into batches that a pool of VolumeWorkers
+// goroutines describe while the next pages are fetched. A single
+// collector goroutine gathers the volumes found into a map per page so
+// no state is shared between the goroutines. Once every batch is done
+// the Nuggets are added to exp.Nuggets in page order.
+func (exp *Expedition) getSnapshots(ctx context.Context) (err error) {
 	var accounts []*string
 	accounts = append(accounts, &exp.account)
 	svc := ec2.New(exp.session)
@@ -564,15 +698,83 @@ func (exp *Expedition) getSnapshots() (err error) {
 		OwnerIds:   accounts,
 		MaxResults: &maxResults,
 	}
-	exp.queue = make(chan []*ec2.Snapshot, 10)
+
+	batches := make(chan volumeBatch)
+	results := make(chan volumeBatchResult)
+	var workers sync.WaitGroup
+	for i := 0; i < exp.volWorkers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for batch := range batches {
+				vols, err := exp.describeVolumes(ctx, batch.volumes)
+				results <- volumeBatchResult{
+					page: batch.page, batchSize: len(batch.volumes), volumes: vols, err: err,
+				}
+			}
+		}()
+	}
+	// the collector owns pageVolumes, pageDone, and batchErr until
+	// collected is closed
+	pageVolumes := make(map[int]map[string]*ec2.Volume)
+	pageDone := make(map[int]int)
+	var batchErr error
+	var batchesQueued int64
+	collected := make(chan struct{})
+	go func() {
+		defer close(collected)
+		var batchesDone int
+		for result := range results {
+			batchesDone++
+			exp.emit(exp.hooks.OnVolumeBatchDone != nil, func() {
+				exp.hooks.OnVolumeBatchDone(VolumeBatchEvent{
+					Page:          result.page,
+					Volumes:       result.batchSize,
+					Found:         len(result.volumes),
+					Err:           result.err,
+					BatchesDone:   batchesDone,
+					BatchesQueued: int(atomic.LoadInt64(&batchesQueued)),
+				})
+			})
+			if result.err != nil {
+				if batchErr == nil {
+					batchErr = result.err
+				}
+				continue
+			}
+			vols, ok := pageVolumes[result.page]
+			if !ok {
+				vols = make(map[string]*ec2.Volume)
+				pageVolumes[result.page] = vols
+			}
+			for _, vol := range result.volumes {
+				vols[*vol.VolumeId] = vol
+			}
+			pageDone[result.page]++
+		}
+	}()
+
+	var pages []*snapshotPage
 	pageNum := 0
 	totalSnaps := 0
-	err = svc.DescribeSnapshotsPages(&dsi,
+	totalInScope := 0
+	err = svc.DescribeSnapshotsPagesWithContext(ctx, &dsi,
 		func(page *ec2.DescribeSnapshotsOutput, lastPage bool) bool {
+			if ctx.Err() != nil {
+				// stop paging, the batches already queued still finish
+				return false
+			}
 			pageNum++
 			exp.log.Debug("processing page..", "page", pageNum)
-			var filteredSnapshots []*ec2.Snapshot
-			for _, snap := range page.Snapshots {
+			p := &snapshotPage{num: pageNum}
+			snaps := page.Snapshots
+			capped := false
+			if exp.maxSnapshots > 0 && totalSnaps+len(snaps) >= exp.maxSnapshots {
+				capped = totalSnaps+len(snaps) > exp.maxSnapshots || !lastPage
+				snaps = snaps[:exp.maxSnapshots-totalSnaps]
+			}
+			var inVols []*string
+			for _, snap := range snaps {
 				exp.log.Debug(
 					"checking date on snapshot",
 					"date", snap.StartTime.Format("2006-01-02"),
@@ -582,122 +784,206 @@ func (exp *Expedition) getSnapshots() (err error) {
 						"snapshot meets filter criteria", "cutoffdate",
 						exp.cutoffDate.Format("2006-01-02"),
 					)
-					filteredSnapshots = append(filteredSnapshots, snap)
+					p.nuggets = append(p.nuggets, &Nugget{Snap: snap})
+					inVols = append(inVols, snap.VolumeId)
 				}
 			}
-			totalSnaps += len(page.Snapshots)
+			totalSnaps += len(snaps)
 			exp.log.Info(
 				"Filtered snapshots page by date", "pre-filter",
-				len(page.Snapshots), "post-filter", len(filteredSnapshots),
+				len(snaps), "post-filter", len(p.nuggets),
 				"pageNum", pageNum,
 			)
-			if len(filteredSnapshots) > 0 {
-				exp.queue <- filteredSnapshots
-				exp.wgq.Add(1)
-				go exp.buildNuggets()
+			totalInScope += len(p.nuggets)
+			var volBatches [][]*string
+			if len(p.nuggets) > 0 {
+				// make volume search batches so we can parallelize searches
+				volBatches = makeBatchesStringPointer(dedupeStringPointer(inVols), exp.volBatchSize)
+				p.numBatches = len(volBatches)
+				pages = append(pages, p)
+				atomic.AddInt64(&batchesQueued, int64(len(volBatches)))
+			}
+			exp.emit(exp.hooks.OnPageFetched != nil, func() {
+				exp.hooks.OnPageFetched(PageEvent{
+					Page:           pageNum,
+					Snapshots:      len(snaps),
+					InScope:        len(p.nuggets),
+					TotalSnapshots: totalSnaps,
+					TotalInScope:   totalInScope,
+					Batches:        len(volBatches),
+				})
+			})
+			if len(p.nuggets) > 0 {
+				for _, batch := range volBatches {
+					exp.log.Debug("queueing batch of volumes", "page", pageNum, "size", len(batch))
+					batches <- volumeBatch{page: pageNum, volumes: batch}
+				}
+			}
+			if capped {
+				exp.truncate(ListingSnapshots, pageNum, totalSnaps)
+				return false
 			}
-			return pageNum <= exp.maxPages
+			return exp.morePages(ListingSnapshots, pageNum, totalSnaps, lastPage)
 		})
+	close(batches)
+	exp.log.Info("Waiting for describeVolume batches to finish")
+	workers.Wait()
+	close(results)
+	<-collected
+
+	for _, p := range pages {
+		if pageDone[p.num] < p.numBatches {
+			// the volume lookups are incomplete so HasVol can't be
+			// trusted, leave the page out of the partial results
+			exp.log.Warn("dropping page of nuggets with incomplete volume lookups", "page", p.num, "nuggets", len(p.nuggets))
+			continue
+		}
+		vols := pageVolumes[p.num]
+		for _, nug := range p.nuggets {
+			if vol, ok := vols[*nug.Snap.VolumeId]; ok {
+				nug.HasVol = true
+				nug.volumeTags = vol.Tags
+			}
+			exp.emit(exp.hooks.OnNuggetBuilt != nil, func() {
+				exp.hooks.OnNuggetBuilt(nug)
+			})
+		}
+		exp.Nuggets = append(exp.Nuggets, p.nuggets...)
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err == nil {
+		err = batchErr
+	}
 	if err != nil {
 		return err
 	}
-	go exp.monitorQueue()
-	exp.log.Debug("collecting results from channel")
-	exp.wgq.Wait() // wait for queue to finish
 	exp.log.Info("Total snapshots post date filter", "snapshots_in_scope", len(exp.Nuggets))
 	exp.log.Info("Total snapshots analyzed", "total-analyzed", totalSnaps)
 	return err
 }
 
-func (exp *Expedition) monitorQueue() {
-	exp.wgq.Wait()
-	close(exp.queue)
-	exp.log.Debug("closed queue channel")
-}
-
-func (exp *Expedition) describeVolumes(inVols []*string) {
-	defer exp.wgv.Done()
+// describeVolumes returns the volumes in inVols that still exist. The
+// volumes are looked up with a volume-id filter rather than VolumeIds
+// so that missing volumes are simply left out of the results instead of
+// failing the whole request, which lets a whole batch be described in
+// one paginated call. Any error is returned since a throttled or denied
+// call must never be mistaken for a missing volume.
+func (exp *Expedition) describeVolumes(ctx context.Context, inVols []*string) (vols []*ec2.Volume, err error) {
 	svc := ec2.New(exp.session)
-	for _, vol := range inVols {
-		dvi := ec2.DescribeVolumesInput{
-			VolumeIds: []*string{vol},
-		}
-		// we don't care about "volume not found" errors
-		// we just care about what does come back
-		r, _ := svc.DescribeVolumes(&dvi)
-		// we have to do each invidual volume in its own
-		// describe because AWS will fail the bulk
-		// request if even one volume is missing
-		for _, vol := range r.Volumes {
-			exp.realVols = append(exp.realVols, vol)
-		}
-	}
-}
-
-//func (exp *Expedition) buildNuggets(snaps []*ec2.Snapshot) {
-func (exp *Expedition) buildNuggets() {
-	defer exp.wgq.Done()
-	exp.log.Debug("inside a buildNuggets gofunc")
-	var nuggets []*Nugget
-	var inVols []*string
-	for _, snap := range <-exp.queue {
-		inVols = append(inVols, snap.VolumeId)
-		// make a new nugget
-		n := Nugget{
-			Snap: snap,
-		}
-		nuggets = append(nuggets, &n)
-	}
-	exp.log.Debug("build page of nuggets", "nuggets", len(nuggets))
-	inVolsD := dedupeStringPointer(inVols)
-	// make volume search batches so we can parallelize searches
-	batchVols := makeBatchesStringPointer(inVolsD, exp.volBatchSize)
-	for _, batch := range batchVols {
-		exp.log.Info("searching for batch of volumes", "size", len(batch))
-		exp.wgv.Add(1)
-		go exp.describeVolumes(batch)
+	dvi := ec2.DescribeVolumesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("volume-id"),
+				Values: inVols,
+			},
+		},
 	}
-	exp.log.Info("Waiting for describeVolume batches to finish")
-	exp.wgv.Wait()
-	exp.log.Debug("describeVolumes complete", "volumesFound", len(exp.realVols))
-	for _, vol := range exp.realVols {
-		for _, nug := range nuggets {
-			if *vol.VolumeId == *nug.Snap.VolumeId {
-				nug.HasVol = true
-			}
-		}
+	err = svc.DescribeVolumesPagesWithContext(ctx, &dvi,
+		func(page *ec2.DescribeVolumesOutput, lastPage bool) bool {
+			vols = append(vols, page.Volumes...)
+			return true
+		})
+	if err != nil {
+		return nil, fmt.Errorf("describing volumes: %s", err)
 	}
-	exp.Nuggets = append(exp.Nuggets, nuggets...)
+	return vols, err
 }
 
 // Start kicks off the expedition. After this completes
 // the data can be exported. 
 func (exp *Expedition) Start() (err error) {
+	return exp.StartWithContext(context.Background())
+}
+
+// CanceledError is returned by StartWithContext when its context is
+// canceled or its deadline passes before the Expedition completes.
+type CanceledError struct {
+	// What the Expedition was doing when it was canceled
+	Phase string
+
+	// Number of Nuggets in the partial results
+	Nuggets int
+
+	// The error of the context
+	Err error
+}
+
+func (e *CanceledError) Error() string {
+	return fmt.Sprintf(
+		"expedition canceled while %s with %d snapshots analyzed: %s",
+		e.Phase, e.Nuggets, e.Err,
+	)
+}
+
+// Unwrap returns the error of the context so errors.Is(err,
+// context.DeadlineExceeded) can be used.
+func (e *CanceledError) Unwrap() error {
+	return e.Err
+}
+
+// canceled returns a CanceledError keeping the Nuggets analyzed so far
+// if ctx is done, or err otherwise.
+func (exp *Expedition) canceled(ctx context.Context, phase string, err error) error {
+	if ctx.Err() == nil {
+		return err
+	}
+	exp.addBars()
+	cerr := &CanceledError{Phase: phase, Nuggets: len(exp.Nuggets), Err: ctx.Err()}
+	exp.log.Warn("expedition canceled, no deletion plan was built", "phase", phase, "nuggets", cerr.Nuggets)
+	return cerr
+}
+
+// StartWithContext is Start with a context that is passed to every AWS
+// call so the Expedition can be stopped, e.g. before the time limit of
+// a Lambda function. When ctx is done StartWithContext stops as soon as
+// the calls in flight return and returns a *CanceledError. Nuggets and
+// Bars then hold the snapshots analyzed so far, but no deletion plan is
+// built since the AMIs, Launch Templates/Configs, and ASGs using them
+// may not be known yet.
+func (exp *Expedition) StartWithContext(ctx context.Context) (err error) {
+	exp.scanTime = time.Now().UTC()
+	defer func() {
+		exp.scanDuration = time.Since(exp.scanTime)
+	}()
 	err = exp.setDateFilter(exp.dateFilter)
 	if err != nil {
 		exp.log.Error("error parsing desired date filter, exiting", "error", err.Error())
 		return err
 	}
 	exp.log.Debug("set datefilter", "exp.cutoffDate", exp.cutoffDate)
-	err = exp.getAccountNumber()
+	defer exp.setPhase(PhaseDone)
+	exp.setPhase(PhaseAccount)
+	err = exp.getAccountNumber(ctx)
 	if err != nil {
-		return err
+		return exp.canceled(ctx, "getting the account number", err)
 	}
-	err = exp.getSnapshots()
+	exp.setPhase(PhaseSnapshots)
+	err = exp.getSnapshots(ctx)
 	if err != nil {
-		return err
+		if ctx.Err() == nil && strings.Contains(err.Error(), "RequestLimitExceeded") {
+			exp.log.Warn("detected RequestLimitExceeded. Try lowering RateLimits or raising MaxRetries")
+		}
+		return exp.canceled(ctx, "describing snapshots", err)
 	}
 	// now find out if snapshots are used in images, launch configs/templates, or ASGs
-	err = exp.populateNuggets()
+	exp.setPhase(PhaseAssociations)
+	err = exp.populateNuggets(ctx)
 	if err != nil {
+		if ctx.Err() != nil {
+			return exp.canceled(ctx, "finding snapshot associations", err)
+		}
 		if strings.Contains(err.Error(), "RequestLimitExceeded") {
-			exp.log.Warn("detected RequestLimitExceeded. Try adjusting VolumeBatchSize higher")
+			exp.log.Warn("detected RequestLimitExceeded. Try lowering RateLimits or raising MaxRetries")
 		}
 		return err
 	}
+	exp.setPhase(PhasePlan)
+	exp.attributeOwners()
 	// build bars
 	exp.addBars()
-	exp.setRecommendations()
+	err = exp.setRecommendations()
 	return err
 }
 
@@ -705,7 +991,7 @@ func (exp *Expedition) Start() (err error) {
 // properties of that snapshot. It returns a slice of account number strings where
 // the image is shared and any error. If the image is "public" it returns a slice of length
 // 1 with the only item being "all".
-func (exp *Expedition) snapshotSharedTo(snap string) (accts []string, err error) {
+func (exp *Expedition) snapshotSharedTo(ctx context.Context, snap string) (accts []string, err error) {
 	exp.log.Debug("describing snapshot attributes for sharing", "snapshot", snap)
 	svc := ec2.New(exp.session)
 	createVolumePermissionAttr := "createVolumePermission"
@@ -713,7 +999,7 @@ func (exp *Expedition) snapshotSharedTo(snap string) (accts []string, err error)
 		Attribute:  &createVolumePermissionAttr,
 		SnapshotId: &snap,
 	}
-	results, err := svc.DescribeSnapshotAttribute(&input)
+	results, err := svc.DescribeSnapshotAttributeWithContext(ctx, &input)
 	if err != nil {
 		return accts, err
 	}
@@ -751,30 +1037,54 @@ type ExpeditionInput struct {
 	// Session is a required field
 	Session *session.Session
 
-	// Maximum number of pages of snapshots to process
-	// from the describeSnapshots operation
-	// Default: 25
+	// Maximum number of pages fetched from each listing
+	// (snapshots, launch templates, launch configurations,
+	// autoscaling groups, and images). Every listing is read to
+	// its last page unless MaxPages is set above zero, in which
+	// case listings that are cut short are recorded in
+	// Truncations and flagged in the recommendations. Apply's
+	// pre-flight checks always read every page.
+	// Default: 0 (no limit)
 	MaxPages *int
 
+	// Maximum number of snapshots to fetch before the date
+	// filter is applied. The snapshot listing stops once it is
+	// reached and is recorded in Truncations.
+	// Default: 0 (no limit)
+	MaxSnapshots *int
+
 	// Maximum number of snapshots per page
 	// Default: 500
 	PageSize *int
 
-	// When describing volumes to see if they exist
-	// for a given snapshot each volume must be described
-	// individually. This is fanned out to multiple
-	// goroutines each with a batch of volumes to describe.
+	// When describing volumes to see if they exist the
+	// volumes of each page of snapshots are split into batches
+	// of VolumeBatchSize IDs that are each described with a
+	// single (paginated) DescribeVolumes call. The batches are
+	// fanned out to VolumeWorkers goroutines.
 	//
-	// Adjust this up if you hit throttling errors and down
-	// if you want to improve speed.
-	// Default: 30
+	// Adjust this down if requests fail for having too many
+	// filter values.
+	// Default: 200
 	VolumeBatchSize *int
 
+	// Number of goroutines describing batches of volumes at
+	// the same time. Adjust this down if you hit throttling
+	// errors.
+	// Default: 10
+	VolumeWorkers *int
+
 	// All snapshots created after DateFilter will be
 	// ignored in the analysis. Format "YYYY-MM-DD"
 	// Default: "2019-01-01"
 	DateFilter *string
 
+	// Directory in which the Outfile* files and the ApplyJournal
+	// that are not set are written. It is created by New if it
+	// doesn't exist. Files that are set are used as given.
+	// Default: "" (the current directory)
+	OutputDirectory *string
+
 	// If the ExportRecommendations method is called on the returned
 	// Expedition it will write an analysis summary to the
 	// OutfileRecommendations filename in text format.
@@ -793,6 +1103,120 @@ type ExpeditionInput struct {
 	// Default: "outfile-bars.csv"
 	OutfileBars *string
 
+	// If the ExportScript method is called on the returned
+	// Expedition it will write the deletion plan as an ordered,
+	// idempotent bash script of aws cli commands to the
+	// OutfileScript filename.
+	// Default: "out-plan.sh"
+	OutfileScript *string
+
+	// If the ExportIaCReport method is called on the returned
+	// Expedition it will write a csv report to the OutfileIaCReport
+	// filename listing which deletion plan items are managed by
+	// Terraform (see TerraformStateFiles) or CloudFormation and must
+	// be removed through IaC rather than deleted directly.
+	// Default: "out-iac-report.csv"
+	OutfileIaCReport *string
+
+	// Local Terraform state files (format version 4) to search
+	// when mapping deletion plan items to the Terraform resource
+	// addresses that manage them for ExportIaCReport.
+	TerraformStateFiles []string
+
+	// If the ExportGraphDot method is called on the returned
+	// Expedition it will write the dependency graph between
+	// snapshots, AMIs, Launch Templates/Configs, and AutoScaling
+	// groups to the OutfileGraphDot filename in Graphviz DOT format.
+	// Default: "out-graph.dot"
+	OutfileGraphDot *string
+
+	// If the ExportGraphMermaid method is called on the returned
+	// Expedition it will write the dependency graph to the
+	// OutfileGraphMermaid filename in Mermaid format.
+	// Default: "out-graph.mmd"
+	OutfileGraphMermaid *string
+
+	// If the ExportState method is called on the returned
+	// Expedition it will save the results of the analysis to
+	// the OutfileState filename in json format. A saved state can
+	// be loaded later with LoadState to produce reports without
+	// re-scanning the account.
+	// Default: "out-state.json"
+	OutfileState *string
+
+	// If the ExportHTML method is called on the returned
+	// Expedition it will write a self-contained HTML report
+	// with the deletion plan, spared snapshots, and cost charts
+	// to the OutfileHTML filename.
+	// Default: "out-report.html"
+	OutfileHTML *string
+
+	// If the ExportMarkdown method is called on the returned
+	// Expedition it will write a Markdown summary suitable for
+	// pull requests and tickets to the OutfileMarkdown filename.
+	// Default: "out-summary.md"
+	OutfileMarkdown *string
+
+	// If the ExportWorkbook method is called on the returned
+	// Expedition it will write an .xlsx workbook with sheets for
+	// the summary, Nuggets, Bars, the ordered plan, and spared
+	// snapshots to the OutfileWorkbook filename.
+	// Default: "out-workbook.xlsx"
+	OutfileWorkbook *string
+
+	// If the ExportOwnerReports method is called on the returned
+	// Expedition it will write one Markdown report per snapshot
+	// owner (see OwnerRules) and an owners.csv with the totals per
+	// owner to the OutfileOwnerReports directory.
+	// Default: "out-owners"
+	OutfileOwnerReports *string
+
+	// Rules used to assign an Owner to each Nugget. They are tried
+	// in order and the first one to find an owner wins.
+	// Default: DefaultOwnerRules()
+	OwnerRules []OwnerRule
+
+	// Snapshots that are never put in the deletion plan. They are
+	// reported as spared with SpareExempt.
+	ExemptSnapshots []string
+
+	// Snapshots with any of these tags are never put in the deletion
+	// plan. An empty value exempts every snapshot with the tag key.
+	// Keys and values are matched case-sensitively.
+	ExemptTags map[string]string
+
+	// Snapshots used by an AMI that was launched within this many
+	// days of the scan are never put in the deletion plan. They are
+	// reported as spared with SpareRecentlyLaunched.
+	// Default: 0 (disabled)
+	SpareLaunchedWithinDays *int
+
+	// A text/template used by WriteMarkdown and ExportMarkdown
+	// to render the Markdown summary. It is executed with a *Summary
+	// so teams can customize the wording of the report. See
+	// DefaultMarkdownTemplate for an example.
+	// Default: DefaultMarkdownTemplate
+	MarkdownTemplate *string
+
+	// A text/template used to build the recommendations returned by
+	// GetRecommendations and written by ExportRecommendations. It is
+	// executed with a *Summary and each line of output becomes one
+	// recommendation. See DefaultRecommendationsTemplate for an example.
+	// Default: DefaultRecommendationsTemplate
+	RecommendationsTemplate *string
+
+	// Language of the message catalog used by the "t" template
+	// function in the recommendations and Markdown templates. See
+	// Languages for the built-in catalogs.
+	// Default: "en"
+	Language *string
+
+	// Messages overrides individual messages of the catalog by key
+	// (e.g., "intro", "savings") so teams can customize the wording
+	// or supply a catalog for a language that isn't built in. Messages
+	// missing from both fall back to the "en" catalog.
+	Messages map[string]string
+
 	// Expedition uses log15 (https://github.com/inconshreveable/log15)
 	// as an opinioned logging framework. If no Logger is provided
 	// Expedition will set up its own handler to stdout.
@@ -802,6 +1226,29 @@ type ExpeditionInput struct {
 	// savings estimate.
 	// Default: 0.05
 	EbsSnapRate *float64
+
+	// Functions called as the Expedition makes progress, see
+	// Hooks and NewProgressReporter.
+	Hooks Hooks
+
+	// Requests per second allowed for each API family, e.g.
+	// {"ec2:read": 10}. Families that aren't set use
+	// DefaultRateLimits. A family is slowed down further whenever
+	// AWS throttles one of its requests and speeds back up as
+	// requests succeed. Set a family to 0 to turn off its limit.
+	RateLimits map[string]float64
+
+	// Number of times a failed or throttled AWS request is retried
+	// with jittered exponential backoff.
+	// Default: 8
+	MaxRetries *int
+
+	// When the Apply method is called every completed or failed
+	// step is appended to the ApplyJournal file. If Apply is
+	// interrupted then calling it again will resume from the
+	// journal without re-attempting completed deletions.
+	// Default: "out-apply-journal.jsonl"
+	ApplyJournal *string
 }
 
 // New returns a Expedition object whose methods can be called to perform
@@ -823,43 +1270,166 @@ func New(input *ExpeditionInput) (exp *Expedition, err error) {
 		return &e, err
 	}
 	e.session = input.Session
+	e.instrumentSession()
 
-	DefaultMaxPages := 25
+	DefaultMaxPages := 0
 	if input.MaxPages == nil {
 		input.MaxPages = &DefaultMaxPages
 	}
 	e.maxPages = *input.MaxPages
 
+	DefaultMaxSnapshots := 0
+	if input.MaxSnapshots == nil {
+		input.MaxSnapshots = &DefaultMaxSnapshots
+	}
+	e.maxSnapshots = *input.MaxSnapshots
+
 	DefaultPageSize := 500
 	if input.PageSize == nil {
 		input.PageSize = &DefaultPageSize
 	}
 	e.pageSize = *input.PageSize
 
-	DefaultVolumeBatchSize := 30
+	DefaultVolumeBatchSize := 200
 	if input.VolumeBatchSize == nil {
 		input.VolumeBatchSize = &DefaultVolumeBatchSize
 	}
+	if *input.VolumeBatchSize < 1 {
+		err = errors.New("VolumeBatchSize must be at least 1")
+		return &e, err
+	}
 	e.volBatchSize = *input.VolumeBatchSize
 
-	DefaultOutfileRecommendations := "out-summary.txt"
+	DefaultVolumeWorkers := 10
+	if input.VolumeWorkers == nil {
+		input.VolumeWorkers = &DefaultVolumeWorkers
+	}
+	if *input.VolumeWorkers < 1 {
+		err = errors.New("VolumeWorkers must be at least 1")
+		return &e, err
+	}
+	e.volWorkers = *input.VolumeWorkers
+
+	var outdir string
+	if input.OutputDirectory != nil && *input.OutputDirectory != "" {
+		outdir = *input.OutputDirectory
+		err = os.MkdirAll(outdir, 0755)
+		if err != nil {
+			return &e, err
+		}
+	}
+
+	DefaultOutfileRecommendations := filepath.Join(outdir, "out-summary.txt")
 	if input.OutfileRecommendations == nil {
 		input.OutfileRecommendations = &DefaultOutfileRecommendations
 	}
 	e.outfileRecommendations = *input.OutfileRecommendations
 
-	DefaultOutfileNuggets := "out-nuggets.csv"
+	DefaultOutfileNuggets := filepath.Join(outdir, "out-nuggets.csv")
 	if input.OutfileNuggets == nil {
 		input.OutfileNuggets = &DefaultOutfileNuggets
 	}
 	e.outfileNuggets = *input.OutfileNuggets
 
-	DefaultOutfileBars := "out-bars.csv"
+	DefaultOutfileBars := filepath.Join(outdir, "out-bars.csv")
 	if input.OutfileBars == nil {
 		input.OutfileBars = &DefaultOutfileBars
 	}
 	e.outfileBars = *input.OutfileBars
 
+	DefaultOutfileScript := filepath.Join(outdir, "out-plan.sh")
+	if input.OutfileScript == nil {
+		input.OutfileScript = &DefaultOutfileScript
+	}
+	e.outfileScript = *input.OutfileScript
+
+	DefaultOutfileIaCReport := filepath.Join(outdir, "out-iac-report.csv")
+	if input.OutfileIaCReport == nil {
+		input.OutfileIaCReport = &DefaultOutfileIaCReport
+	}
+	e.outfileIaCReport = *input.OutfileIaCReport
+	e.terraformStateFiles = input.TerraformStateFiles
+
+	DefaultOutfileGraphDot := filepath.Join(outdir, "out-graph.dot")
+	if input.OutfileGraphDot == nil {
+		input.OutfileGraphDot = &DefaultOutfileGraphDot
+	}
+	e.outfileGraphDot = *input.OutfileGraphDot
+
+	DefaultOutfileGraphMermaid := filepath.Join(outdir, "out-graph.mmd")
+	if input.OutfileGraphMermaid == nil {
+		input.OutfileGraphMermaid = &DefaultOutfileGraphMermaid
+	}
+	e.outfileGraphMermaid = *input.OutfileGraphMermaid
+
+	DefaultOutfileState := filepath.Join(outdir, "out-state.json")
+	if input.OutfileState == nil {
+		input.OutfileState = &DefaultOutfileState
+	}
+	e.outfileState = *input.OutfileState
+
+	DefaultOutfileHTML := filepath.Join(outdir, "out-report.html")
+	if input.OutfileHTML == nil {
+		input.OutfileHTML = &DefaultOutfileHTML
+	}
+	e.outfileHTML = *input.OutfileHTML
+
+	DefaultOutfileMarkdown := filepath.Join(outdir, "out-summary.md")
+	if input.OutfileMarkdown == nil {
+		input.OutfileMarkdown = &DefaultOutfileMarkdown
+	}
+	e.outfileMarkdown = *input.OutfileMarkdown
+
+	DefaultOutfileWorkbook := filepath.Join(outdir, "out-workbook.xlsx")
+	if input.OutfileWorkbook == nil {
+		input.OutfileWorkbook = &DefaultOutfileWorkbook
+	}
+	e.outfileWorkbook = *input.OutfileWorkbook
+
+	DefaultOutfileOwnerReports := filepath.Join(outdir, "out-owners")
+	if input.OutfileOwnerReports == nil {
+		input.OutfileOwnerReports = &DefaultOutfileOwnerReports
+	}
+	e.outfileOwnerReports = *input.OutfileOwnerReports
+
+	if input.OwnerRules == nil {
+		input.OwnerRules = DefaultOwnerRules()
+	}
+	e.ownerRules, err = compileOwnerRules(input.OwnerRules)
+	if err != nil {
+		return &e, err
+	}
+
+	DefaultMarkdown := DefaultMarkdownTemplate
+	if input.MarkdownTemplate == nil {
+		input.MarkdownTemplate = &DefaultMarkdown
+	}
+	e.markdownTemplate = *input.MarkdownTemplate
+
+	DefaultRecommendations := DefaultRecommendationsTemplate
+	if input.RecommendationsTemplate == nil {
+		input.RecommendationsTemplate = &DefaultRecommendations
+	}
+	e.recTemplate = *input.RecommendationsTemplate
+
+	DefaultLang := DefaultLanguage
+	if input.Language == nil {
+		input.Language = &DefaultLang
+	}
+	e.language = *input.Language
+	if _, ok := catalogs[e.language]; !ok && input.Messages == nil {
+		err = fmt.Errorf("no message catalog for language %q", e.language)
+		return &e, err
+	}
+	e.messages = input.Messages
+	// catch template mistakes now rather than after a long scan
+	for _, text := range []string{e.recTemplate, e.markdownTemplate} {
+		_, err = template.New("").Funcs(e.summaryFuncs()).Parse(text)
+		if err != nil {
+			return &e, err
+		}
+	}
+
 	if input.Logger == nil {
 		err = errors.New("log15 logger is required")
 		return &e, err
@@ -871,5 +1441,30 @@ func New(input *ExpeditionInput) (exp *Expedition, err error) {
 		input.EbsSnapRate = &DefaultEbsSnapRate
 	}
 	e.ebsSnapRate = *input.EbsSnapRate
+
+	DefaultApplyJournal := filepath.Join(outdir, "out-apply-journal.jsonl")
+	if input.ApplyJournal == nil {
+		input.ApplyJournal = &DefaultApplyJournal
+	}
+	e.applyJournal = *input.ApplyJournal
+
+	e.exemptSnapshots = make(map[string]bool)
+	for _, id := range input.ExemptSnapshots {
+		e.exemptSnapshots[id] = true
+	}
+	e.exemptTags = input.ExemptTags
+
+	DefaultSpareLaunchedWithinDays := 0
+	if input.SpareLaunchedWithinDays == nil {
+		input.SpareLaunchedWithinDays = &DefaultSpareLaunchedWithinDays
+	}
+	e.spareLaunchedDays = *input.SpareLaunchedWithinDays
+	e.hooks = input.Hooks
+
+	DefaultMaxRetries := 8
+	if input.MaxRetries == nil {
+		input.MaxRetries = &DefaultMaxRetries
+	}
+	e.limitSession(input.RateLimits, *input.MaxRetries)
 	return &e, err
 }
@@ -0,0 +1,826 @@
+package dustcollector
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/inconshreveable/log15"
+)
+
+// fakeAWS is an in-process stand-in for the EC2, AutoScaling, and STS
+// query APIs. Snapshot snap-N belongs to volume vol-N/2 and a volume
+// vol-M still exists when M%3 != 0. Image ami-N has snap-7N in its
+// block device mappings, is disabled when N%3 == 0, and was last
+// launched two days ago when N is even.
+type fakeAWS struct {
+	snaps    int
+	pageSize int
+	images   int
+
+	// if set, DescribeVolumes fails with this error code
+	volFail string
+	// if set, every throttleEvery'th DescribeVolumes call is throttled
+	throttleEvery int64
+
+	// if set, ASGs are listed one per page, asg-N using lc-N
+	asgPages int
+
+	// canned responses by Action, e.g. launch templates and ASGs
+	override map[string]string
+
+	mu       sync.Mutex
+	calls    map[string]int
+	lastForm map[string]url.Values
+	volCalls int64
+}
+
+func (f *fakeAWS) count(action string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[action]
+}
+
+func (f *fakeAWS) form(action string) url.Values {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lastForm[action]
+}
+
+func (f *fakeAWS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	action := r.Form.Get("Action")
+	key := action
+	if action == "DescribeImageAttribute" {
+		key += "/" + r.Form.Get("Attribute")
+	}
+	f.mu.Lock()
+	if f.calls == nil {
+		f.calls = make(map[string]int)
+		f.lastForm = make(map[string]url.Values)
+	}
+	f.calls[key]++
+	f.lastForm[key] = r.Form
+	f.mu.Unlock()
+	if body, ok := f.override[action]; ok {
+		fmt.Fprint(w, body)
+		return
+	}
+	switch action {
+	case "GetCallerIdentity":
+		fmt.Fprint(w, `<GetCallerIdentityResponse><GetCallerIdentityResult><Account>123456789012</Account></GetCallerIdentityResult></GetCallerIdentityResponse>`)
+	case "DescribeSnapshots":
+		start, _ := strconv.Atoi(r.Form.Get("NextToken"))
+		end := start + f.pageSize
+		if end > f.snaps {
+			end = f.snaps
+		}
+		fmt.Fprint(w, `<DescribeSnapshotsResponse><snapshotSet>`)
+		for i := start; i < end; i++ {
+			fmt.Fprintf(w, `<item><snapshotId>snap-%d</snapshotId><volumeId>vol-%d</volumeId><startTime>2018-01-01T00:00:00.000Z</startTime><volumeSize>%d</volumeSize><ownerId>123456789012</ownerId><description>d</description></item>`, i, i/2, 1+i%5)
+		}
+		fmt.Fprint(w, `</snapshotSet>`)
+		if end < f.snaps {
+			fmt.Fprintf(w, `<nextToken>%d</nextToken>`, end)
+		}
+		fmt.Fprint(w, `</DescribeSnapshotsResponse>`)
+	case "DescribeVolumes":
+		n := atomic.AddInt64(&f.volCalls, 1)
+		if f.throttleEvery > 0 && n%f.throttleEvery == 0 {
+			fakeError(w, "RequestLimitExceeded")
+			return
+		}
+		if f.volFail != "" {
+			fakeError(w, f.volFail)
+			return
+		}
+		fmt.Fprint(w, `<DescribeVolumesResponse><volumeSet>`)
+		for k, values := range r.Form {
+			if !strings.HasPrefix(k, "Filter.") || !strings.Contains(k, ".Value.") {
+				continue
+			}
+			for _, id := range values {
+				n, _ := strconv.Atoi(strings.TrimPrefix(id, "vol-"))
+				if n%3 != 0 {
+					fmt.Fprintf(w, `<item><volumeId>%s</volumeId><tagSet><item><key>Team</key><value>t%d</value></item></tagSet></item>`, id, n%4)
+				}
+			}
+		}
+		fmt.Fprint(w, `</volumeSet></DescribeVolumesResponse>`)
+	case "DescribeImages":
+		start, _ := strconv.Atoi(r.Form.Get("NextToken"))
+		max, _ := strconv.Atoi(r.Form.Get("MaxResults"))
+		id := r.Form.Get("ImageId.1")
+		if max == 0 {
+			max = f.images
+		}
+		fmt.Fprint(w, `<DescribeImagesResponse><imagesSet>`)
+		end := start
+		for i := start; i < f.images && i < start+max; i++ {
+			end = i + 1
+			if id != "" && id != fmt.Sprintf("ami-%d", i) {
+				continue
+			}
+			state := "available"
+			if i%3 == 0 {
+				state = "disabled"
+			}
+			fmt.Fprintf(w, `<item><imageId>ami-%d</imageId><name>img%d</name><imageState>%s</imageState><creationDate>2017-05-01T10:00:00.000Z</creationDate><deprecationTime>2021-01-01T00:00:00Z</deprecationTime><blockDeviceMapping><item><deviceName>/dev/xvda</deviceName><ebs><snapshotId>snap-%d</snapshotId></ebs></item></blockDeviceMapping></item>`, i, i, state, i*7)
+		}
+		fmt.Fprint(w, `</imagesSet>`)
+		if end < f.images && id == "" {
+			fmt.Fprintf(w, `<nextToken>%d</nextToken>`, end)
+		}
+		fmt.Fprint(w, `</DescribeImagesResponse>`)
+	case "DescribeImageAttribute":
+		if r.Form.Get("Attribute") == "lastLaunchedTime" {
+			n, _ := strconv.Atoi(strings.TrimPrefix(r.Form.Get("ImageId"), "ami-"))
+			when := "2015-01-01T00:00:00Z"
+			if n%2 == 0 {
+				when = time.Now().Add(-48 * time.Hour).UTC().Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, `<DescribeImageAttributeResponse><imageId>ami-%d</imageId><lastLaunchedTime><value>%s</value></lastLaunchedTime></DescribeImageAttributeResponse>`, n, when)
+			return
+		}
+		if r.Form.Get("ImageId") == "ami-1" {
+			fmt.Fprint(w, `<DescribeImageAttributeResponse><launchPermission><item><userId>999988887777</userId></item></launchPermission></DescribeImageAttributeResponse>`)
+			return
+		}
+		fmt.Fprint(w, `<DescribeImageAttributeResponse><launchPermission/></DescribeImageAttributeResponse>`)
+	case "DescribeLaunchTemplateVersions":
+		fmt.Fprint(w, `<DescribeLaunchTemplateVersionsResponse><launchTemplateVersionSet/></DescribeLaunchTemplateVersionsResponse>`)
+	case "DescribeAutoScalingGroups":
+		page, _ := strconv.Atoi(r.Form.Get("NextToken"))
+		f.mu.Lock()
+		asgPages := f.asgPages
+		f.mu.Unlock()
+		fmt.Fprint(w, `<DescribeAutoScalingGroupsResponse><DescribeAutoScalingGroupsResult><AutoScalingGroups>`)
+		if page < asgPages {
+			fmt.Fprintf(w, `<member><AutoScalingGroupName>asg-%d</AutoScalingGroupName><LaunchConfigurationName>lc-%d</LaunchConfigurationName></member>`, page, page)
+		}
+		fmt.Fprint(w, `</AutoScalingGroups>`)
+		if page+1 < asgPages {
+			fmt.Fprintf(w, `<NextToken>%d</NextToken>`, page+1)
+		}
+		fmt.Fprint(w, `</DescribeAutoScalingGroupsResult></DescribeAutoScalingGroupsResponse>`)
+	case "DescribeLaunchConfigurations", "DeleteLaunchConfiguration":
+		fmt.Fprintf(w, `<%sResponse><%sResult></%sResult></%sResponse>`, action, action, action, action)
+	default:
+		fakeError(w, "InvalidAction")
+	}
+}
+
+func fakeError(w http.ResponseWriter, code string) {
+	w.WriteHeader(http.StatusBadRequest)
+	fmt.Fprintf(w, `<Response><Errors><Error><Code>%s</Code><Message>%s</Message></Error></Errors></Response>`, code, code)
+}
+
+// newFakeExpedition starts f and returns an Expedition that talks to it
+// with the input fields set by configure.
+func newFakeExpedition(t testing.TB, f *fakeAWS, configure func(in *ExpeditionInput)) *Expedition {
+	ts := httptest.NewServer(f)
+	t.Cleanup(ts.Close)
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(ts.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	}))
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	dir, err := ioutil.TempDir("", "dustcollector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	in := &ExpeditionInput{
+		Session:         sess,
+		Logger:          &logger,
+		OutputDirectory: &dir,
+		RateLimits:      map[string]float64{"ec2:read": 0, "autoscaling:read": 0, "sts:read": 0},
+	}
+	if configure != nil {
+		configure(in)
+	}
+	exp, err := New(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return exp
+}
+
+func snapshotIds(nuggets []*Nugget) (ids []string) {
+	for _, nug := range nuggets {
+		ids = append(ids, *nug.Snap.SnapshotId)
+	}
+	return ids
+}
+
+// TestGetSnapshots runs the snapshot pipeline with many small volume
+// batches so the workers and the collector overlap. Run it with -race.
+func TestGetSnapshots(t *testing.T) {
+	f := &fakeAWS{snaps: 2000, pageSize: 100}
+	var want []string
+	wantHasVol := 0
+	for i := 0; i < f.snaps; i++ {
+		want = append(want, fmt.Sprintf("snap-%d", i))
+		if (i/2)%3 != 0 {
+			wantHasVol++
+		}
+	}
+	for run := 0; run < 3; run++ {
+		exp := newFakeExpedition(t, f, func(in *ExpeditionInput) {
+			in.PageSize = aws.Int(100)
+			in.VolumeBatchSize = aws.Int(7)
+			in.VolumeWorkers = aws.Int(16)
+		})
+		if err := exp.setDateFilter(exp.dateFilter); err != nil {
+			t.Fatal(err)
+		}
+		if err := exp.getSnapshots(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if got := snapshotIds(exp.Nuggets); !reflect.DeepEqual(got, want) {
+			t.Fatalf("run %d: got %d nuggets out of order, want %d in page order", run, len(got), len(want))
+		}
+		hasVol := 0
+		for _, nug := range exp.Nuggets {
+			if nug.HasVol {
+				hasVol++
+				if len(nug.volumeTags) != 1 {
+					t.Fatalf("%s: volume tags not kept", *nug.Snap.SnapshotId)
+				}
+			}
+		}
+		if hasVol != wantHasVol {
+			t.Fatalf("run %d: %d nuggets with a volume, want %d", run, hasVol, wantHasVol)
+		}
+	}
+}
+
+// TestVolumeBatches checks that volumes are described in batches with a
+// volume-id filter instead of one call per snapshot.
+func TestVolumeBatches(t *testing.T) {
+	f := &fakeAWS{snaps: 1000, pageSize: 100}
+	exp := newFakeExpedition(t, f, func(in *ExpeditionInput) {
+		in.PageSize = aws.Int(100)
+	})
+	if err := exp.Start(); err != nil {
+		t.Fatal(err)
+	}
+	// 50 volumes per page fit in one batch of the default size
+	if got := f.count("DescribeVolumes"); got != 10 {
+		t.Fatalf("DescribeVolumes called %d times, want 10", got)
+	}
+	if name := f.form("DescribeVolumes").Get("Filter.1.Name"); name != "volume-id" {
+		t.Fatalf("DescribeVolumes filter %q, want volume-id", name)
+	}
+}
+
+// TestVolumeErrorsPropagate makes sure a failed volume lookup fails
+// Start instead of the volumes being taken for deleted.
+func TestVolumeErrorsPropagate(t *testing.T) {
+	f := &fakeAWS{snaps: 300, pageSize: 100, volFail: "UnauthorizedOperation"}
+	exp := newFakeExpedition(t, f, nil)
+	err := exp.Start()
+	if err == nil || !strings.Contains(err.Error(), "UnauthorizedOperation") {
+		t.Fatalf("Start returned %v, want the UnauthorizedOperation error", err)
+	}
+	if len(exp.Plan) != 0 {
+		t.Fatalf("built a plan of %d steps from incomplete volume lookups", len(exp.Plan))
+	}
+}
+
+func TestThrottlingIsRetried(t *testing.T) {
+	f := &fakeAWS{snaps: 600, pageSize: 100, throttleEvery: 3}
+	exp := newFakeExpedition(t, f, func(in *ExpeditionInput) {
+		in.PageSize = aws.Int(100)
+		in.VolumeBatchSize = aws.Int(20)
+		in.RateLimits = map[string]float64{"ec2:read": 50}
+	})
+	if err := exp.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if len(exp.Nuggets) != f.snaps {
+		t.Fatalf("got %d nuggets, want %d", len(exp.Nuggets), f.snaps)
+	}
+	var stats ThrottleStats
+	for _, s := range exp.GetThrottleStats() {
+		if s.Family == "ec2:read" {
+			stats = s
+		}
+	}
+	if stats.Throttled == 0 || stats.Retries == 0 {
+		t.Fatalf("throttling not recorded: %+v", stats)
+	}
+	if stats.Rate >= stats.Budget {
+		t.Fatalf("rate %v not lowered below the budget %v after throttling", stats.Rate, stats.Budget)
+	}
+	if summary := exp.GetSummary(); summary.Throttled != stats.Throttled {
+		t.Fatalf("summary reports %d throttled requests, want %d", summary.Throttled, stats.Throttled)
+	}
+}
+
+func TestHooksAndProgress(t *testing.T) {
+	f := &fakeAWS{snaps: 1000, pageSize: 100}
+	var phases []string
+	built := 0
+	reporter := NewProgressReporter(nil, time.Second)
+	exp := newFakeExpedition(t, f, func(in *ExpeditionInput) {
+		in.PageSize = aws.Int(100)
+		in.VolumeBatchSize = aws.Int(10)
+		in.Hooks = reporter.Hooks(Hooks{
+			OnPhaseChange: func(e PhaseEvent) { phases = append(phases, e.Phase) },
+			OnNuggetBuilt: func(nug *Nugget) { built++ },
+		})
+	})
+	if err := exp.Start(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{PhaseAccount, PhaseSnapshots, PhaseAssociations, PhasePlan, PhaseDone}
+	if !reflect.DeepEqual(phases, want) {
+		t.Fatalf("phases %v, want %v", phases, want)
+	}
+	if built != len(exp.Nuggets) {
+		t.Fatalf("OnNuggetBuilt called %d times for %d nuggets", built, len(exp.Nuggets))
+	}
+	p := reporter.Progress()
+	if p.Phase != PhaseDone || p.Pages != 10 || p.TotalSnapshots != f.snaps || p.BatchesDone != p.BatchesQueued || p.BatchesDone != 50 {
+		t.Fatalf("unexpected progress %+v", p)
+	}
+}
+
+func TestTruncation(t *testing.T) {
+	for _, c := range []struct {
+		maxPages, maxSnapshots int
+		nuggets                int
+		truncation             *Truncation
+	}{
+		{0, 0, 1000, nil},
+		{3, 0, 300, &Truncation{Listing: ListingSnapshots, Pages: 3, Items: 300}},
+		{0, 250, 250, &Truncation{Listing: ListingSnapshots, Pages: 3, Items: 250}},
+		{0, 1000, 1000, nil},
+		{10, 0, 1000, nil},
+	} {
+		f := &fakeAWS{snaps: 1000, pageSize: 100}
+		exp := newFakeExpedition(t, f, func(in *ExpeditionInput) {
+			in.PageSize = aws.Int(100)
+			in.MaxPages = aws.Int(c.maxPages)
+			in.MaxSnapshots = aws.Int(c.maxSnapshots)
+		})
+		if err := exp.Start(); err != nil {
+			t.Fatal(err)
+		}
+		if len(exp.Nuggets) != c.nuggets {
+			t.Errorf("MaxPages %d MaxSnapshots %d: got %d nuggets, want %d", c.maxPages, c.maxSnapshots, len(exp.Nuggets), c.nuggets)
+		}
+		if c.truncation == nil {
+			if exp.Truncated || len(exp.Truncations) > 0 {
+				t.Errorf("MaxPages %d MaxSnapshots %d: unexpected truncations %v", c.maxPages, c.maxSnapshots, exp.Truncations)
+			}
+			continue
+		}
+		if !exp.Truncated || !reflect.DeepEqual(exp.Truncations, []Truncation{*c.truncation}) {
+			t.Errorf("MaxPages %d MaxSnapshots %d: truncations %v, want %v", c.maxPages, c.maxSnapshots, exp.Truncations, *c.truncation)
+		}
+		if recs := exp.GetRecommendations(); len(recs) == 0 || !strings.Contains(recs[0], "cut short") {
+			t.Errorf("recommendations don't start with a truncation warning: %q", recs)
+		}
+		warning := exp.message("truncated", exp.message("listing.snapshots"), c.truncation.Pages, c.truncation.Items)
+		var html strings.Builder
+		if err := exp.WriteHTML(&html); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(html.String(), warning) {
+			t.Errorf("HTML report doesn't warn %q", warning)
+		}
+		var inWorkbook bool
+		for _, row := range exp.buildWorkbook()[0].rows {
+			for _, cell := range row {
+				inWorkbook = inWorkbook || cell.value == warning
+			}
+		}
+		if !inWorkbook {
+			t.Errorf("workbook summary doesn't warn %q", warning)
+		}
+	}
+}
+
+func TestImages(t *testing.T) {
+	for _, days := range []int{0, 30} {
+		f := &fakeAWS{snaps: 300, pageSize: 100, images: 30}
+		exp := newFakeExpedition(t, f, func(in *ExpeditionInput) {
+			in.PageSize = aws.Int(10)
+			in.SpareLaunchedWithinDays = aws.Int(days)
+		})
+		if err := exp.Start(); err != nil {
+			t.Fatal(err)
+		}
+		if got := f.count("DescribeImages"); got != 3 {
+			t.Fatalf("DescribeImages called %d times, want 3 pages", got)
+		}
+		form := f.form("DescribeImages")
+		if form.Get("IncludeDeprecated") != "true" || form.Get("IncludeDisabled") != "true" || form.Get("Owner.1") != "123456789012" {
+			t.Fatalf("unexpected DescribeImages parameters %v", form)
+		}
+		if got := f.count("GetCallerIdentity"); got != 1 {
+			t.Fatalf("GetCallerIdentity called %d times, want 1", got)
+		}
+		var wantAMIs []string
+		launched := 0
+		for n := 0; n < f.images; n++ {
+			// ami-1 is shared with another account
+			vol := 7 * n / 2
+			if vol%3 != 0 || n == 1 {
+				continue
+			}
+			if days == 0 || n%2 == 1 {
+				wantAMIs = append(wantAMIs, fmt.Sprintf("ami-%d", n))
+			} else {
+				launched++
+			}
+		}
+		if !reflect.DeepEqual(exp.AmiToDelete, wantAMIs) {
+			t.Fatalf("days %d: AMIs to delete %v, want %v", days, exp.AmiToDelete, wantAMIs)
+		}
+		if got := exp.GetSummary().SparedRecentlyLaunched; got != launched {
+			t.Fatalf("days %d: %d spared as recently launched, want %d", days, got, launched)
+		}
+		wantLookups := 0
+		if days > 0 {
+			wantLookups = f.images
+		}
+		if got := f.count("DescribeImageAttribute/lastLaunchedTime"); got != wantLookups {
+			t.Fatalf("days %d: last launched time looked up %d times, want %d", days, got, wantLookups)
+		}
+		ami := exp.Nuggets[0].AMIs[0]
+		if ami.ImageId != "ami-0" || ami.State != "disabled" || ami.CreationDate == nil || ami.DeprecationTime == nil {
+			t.Fatalf("image details not captured: %+v", ami)
+		}
+	}
+}
+
+// TestAssociations covers a snapshot whose AMI is used by two launch
+// templates of which only the first, referenced by ID from a mixed
+// instances policy, is used by an ASG.
+func TestAssociations(t *testing.T) {
+	f := &fakeAWS{snaps: 30, pageSize: 100, images: 2, override: map[string]string{
+		"DescribeLaunchTemplateVersions": `<DescribeLaunchTemplateVersionsResponse><launchTemplateVersionSet>
+<item><launchTemplateId>lt-0aaa</launchTemplateId><launchTemplateName>lt-a</launchTemplateName><versionNumber>3</versionNumber><launchTemplateData><imageId>ami-0</imageId></launchTemplateData></item>
+<item><launchTemplateId>lt-0bbb</launchTemplateId><launchTemplateName>lt-b</launchTemplateName><versionNumber>1</versionNumber><launchTemplateData><imageId>ami-0</imageId></launchTemplateData></item>
+</launchTemplateVersionSet></DescribeLaunchTemplateVersionsResponse>`,
+		"DescribeAutoScalingGroups": `<DescribeAutoScalingGroupsResponse><DescribeAutoScalingGroupsResult><AutoScalingGroups>
+<member><AutoScalingGroupName>asg-A</AutoScalingGroupName><MixedInstancesPolicy><LaunchTemplate><LaunchTemplateSpecification><LaunchTemplateId>lt-0aaa</LaunchTemplateId></LaunchTemplateSpecification></LaunchTemplate></MixedInstancesPolicy></member>
+</AutoScalingGroups></DescribeAutoScalingGroupsResult></DescribeAutoScalingGroupsResponse>`,
+		"DescribeLaunchConfigurations": `<DescribeLaunchConfigurationsResponse><DescribeLaunchConfigurationsResult><LaunchConfigurations>
+<member><LaunchConfigurationName>lc-1</LaunchConfigurationName><ImageId>ami-1</ImageId></member>
+</LaunchConfigurations></DescribeLaunchConfigurationsResult></DescribeLaunchConfigurationsResponse>`,
+	}}
+	exp := newFakeExpedition(t, f, nil)
+	if err := exp.Start(); err != nil {
+		t.Fatal(err)
+	}
+	nuggets := make(map[string]*Nugget)
+	for _, nug := range exp.Nuggets {
+		nuggets[*nug.Snap.SnapshotId] = nug
+	}
+	snap0 := nuggets["snap-0"]
+	if !reflect.DeepEqual(snap0.ASGs, []string{"asg-A"}) || snap0.SpareReason != SpareInASG {
+		t.Fatalf("snap-0: ASGs %v spare reason %q, want asg-A", snap0.ASGs, snap0.SpareReason)
+	}
+	wantChains := []string{
+		"AMI ami-0 -> launch template lt-a version 3 -> autoscaling group asg-A",
+		"AMI ami-0 -> launch template lt-b version 1",
+	}
+	if got := snap0.AssociationChains(); !reflect.DeepEqual(got, wantChains) {
+		t.Fatalf("snap-0 chains %q, want %q", got, wantChains)
+	}
+	snap7 := nuggets["snap-7"]
+	if snap7.SpareReason != SpareShared {
+		t.Fatalf("snap-7 spare reason %q, want %q", snap7.SpareReason, SpareShared)
+	}
+	wantChains = []string{"AMI ami-1 (shared with 999988887777) -> launch configuration lc-1"}
+	if got := snap7.AssociationChains(); !reflect.DeepEqual(got, wantChains) {
+		t.Fatalf("snap-7 chains %q, want %q", got, wantChains)
+	}
+	if len(exp.AmiToDelete)+len(exp.LtsToDelete)+len(exp.LcsToDelete) != 0 {
+		t.Fatalf("in-use resources in the plan: %v %v %v", exp.AmiToDelete, exp.LtsToDelete, exp.LcsToDelete)
+	}
+}
+
+// writeTestJournal writes a journal with a pending step for ami-0.
+func writeTestJournal(t *testing.T, exp *Expedition, account, region string) {
+	line := fmt.Sprintf(`{"account":%q,"region":%q,"resourceType":%q,"resourceId":"ami-0","status":%q}`+"\n", account, region, ResourceAMI, StepPending)
+	err := ioutil.WriteFile(exp.applyJournal, []byte(line), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJournalAccountAndRegion(t *testing.T) {
+	for _, c := range []struct{ account, region string }{
+		{"210987654321", "us-east-1"},
+		{"123456789012", "eu-west-1"},
+		{"", ""},
+	} {
+		exp := newFakeExpedition(t, &fakeAWS{}, nil)
+		writeTestJournal(t, exp, c.account, c.region)
+		err := exp.Apply()
+		if err == nil || !strings.HasPrefix(err.Error(), "apply journal") {
+			t.Errorf("journal for account %q region %q: got error %v, want it rejected", c.account, c.region, err)
+		}
+	}
+}
+
+// TestJournalRebuiltPlan checks that the preflight checks of a plan
+// rebuilt from the journal only look at images owned by the account.
+func TestJournalRebuiltPlan(t *testing.T) {
+	f := &fakeAWS{images: 1}
+	exp := newFakeExpedition(t, f, nil)
+	writeTestJournal(t, exp, "123456789012", "us-east-1")
+	// the fake doesn't implement the instance and deletion calls so
+	// the step fails after describing the image
+	exp.Apply()
+	if len(exp.Plan) != 1 {
+		t.Fatalf("plan of %d steps rebuilt from the journal, want 1", len(exp.Plan))
+	}
+	if owner := f.form("DescribeImages").Get("Owner.1"); owner != "123456789012" {
+		t.Fatalf("images described for owner %q, want 123456789012", owner)
+	}
+	entries, err := readJournal(exp.applyJournal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := entries[len(entries)-1]
+	if last.Account != "123456789012" || last.Region != "us-east-1" {
+		t.Fatalf("journal entry written for account %q region %q", last.Account, last.Region)
+	}
+}
+
+// writeTestFile writes content to name in a temporary directory and
+// returns its path.
+func writeTestFile(t *testing.T, name, content string) string {
+	dir, err := ioutil.TempDir("", "dustcollector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	filename := filepath.Join(dir, name)
+	err = ioutil.WriteFile(filename, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// TestReadJournalMalformed makes sure only a malformed last line, as
+// left by an interrupted run, is ignored.
+func TestReadJournalMalformed(t *testing.T) {
+	good := `{"resourceType":"Snapshot","resourceId":"snap-1","status":"Pending"}`
+	for _, c := range []struct {
+		name, content string
+		entries       int
+		err           string
+	}{
+		{"last line", good + "\n" + `{"resourceType":"Snap`, 1, ""},
+		{"last line and blank lines", good + "\n" + `{"resourceType":"Snap` + "\n\n", 1, ""},
+		{"middle line", good + "\n" + `{"resourceType":"Snap` + "\n" + good + "\n", 0, "line 2:"},
+		{"two bad lines", "x\ny\n", 0, "line 1:"},
+	} {
+		filename := writeTestFile(t, "journal.jsonl", c.content)
+		entries, err := readJournal(filename)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s: got error %v, want %q", c.name, err, c.err)
+			}
+			continue
+		}
+		if err != nil || len(entries) != c.entries {
+			t.Errorf("%s: got %d entries and error %v, want %d entries", c.name, len(entries), err, c.entries)
+		}
+	}
+}
+
+// TestJournalLaunchTemplateVersion checks that a plan rebuilt from the
+// journal still skips a launch template that has new versions since
+// the analysis.
+func TestJournalLaunchTemplateVersion(t *testing.T) {
+	f := &fakeAWS{override: map[string]string{
+		"DescribeLaunchTemplates": `<DescribeLaunchTemplatesResponse><launchTemplates><item>
+<launchTemplateName>lt-a</launchTemplateName><launchTemplateId>lt-0a</launchTemplateId><latestVersionNumber>4</latestVersionNumber>
+</item></launchTemplates></DescribeLaunchTemplatesResponse>`,
+	}}
+	exp := newFakeExpedition(t, f, nil)
+	exp.account = "123456789012"
+	exp.ltVersions = map[string]int64{"lt-a": 3}
+	exp.Plan = []*PlanStep{{ResourceType: ResourceLaunchTemplate, ResourceId: "lt-a", Status: StepPending}}
+	// record the plan without applying it
+	journal, err := exp.openJournal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = exp.writeJournal(journal, exp.Plan[0])
+	journal.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resumed := newFakeExpedition(t, f, nil)
+	resumed.applyJournal = exp.applyJournal
+	resumed.Apply()
+	if len(resumed.Plan) != 1 {
+		t.Fatalf("plan of %d steps rebuilt from the journal, want 1", len(resumed.Plan))
+	}
+	step := resumed.Plan[0]
+	want := "launch template has new versions since analysis (was 3, now 4)"
+	if step.Status != StepSkipped || step.Reason != want {
+		t.Errorf("got %s %q, want %s %q", step.Status, step.Reason, StepSkipped, want)
+	}
+	if f.count("DeleteLaunchTemplate") != 0 {
+		t.Error("launch template with new versions was deleted")
+	}
+}
+
+// lcListing is a DescribeLaunchConfigurations response with lc-2 using
+// ami-0.
+const lcListing = `<DescribeLaunchConfigurationsResponse><DescribeLaunchConfigurationsResult><LaunchConfigurations>
+<member><LaunchConfigurationName>lc-2</LaunchConfigurationName><ImageId>ami-0</ImageId></member>
+</LaunchConfigurations></DescribeLaunchConfigurationsResult></DescribeLaunchConfigurationsResponse>`
+
+// TestApplyListings checks that every step of Apply describes all of the
+// ASGs, unlike the analysis past MaxPages.
+func TestApplyListings(t *testing.T) {
+	f := &fakeAWS{asgPages: 3, override: map[string]string{"DescribeLaunchConfigurations": lcListing}}
+	exp := newFakeExpedition(t, f, func(in *ExpeditionInput) {
+		in.MaxPages = aws.Int(1)
+	})
+	exp.account = "123456789012"
+	for _, lc := range []string{"lc-2", "lc-1"} {
+		exp.Plan = append(exp.Plan, &PlanStep{
+			ResourceType: ResourceLaunchConfiguration,
+			ResourceId:   lc,
+			Status:       StepPending,
+		})
+	}
+	exp.Apply()
+	for i, asg := range []string{"asg-2", "asg-1"} {
+		want := fmt.Sprintf("launch configuration is used by autoscaling groups [%s]", asg)
+		if step := exp.Plan[i]; step.Status != StepSkipped || step.Reason != want {
+			t.Errorf("%s: %s %q, want skipped with %q", step.ResourceId, step.Status, step.Reason, want)
+		}
+	}
+	if got := f.count("DescribeAutoScalingGroups"); got != 2*f.asgPages {
+		t.Errorf("DescribeAutoScalingGroups called %d times, want every page for each step", got)
+	}
+	if exp.Truncated || len(exp.Truncations) > 0 {
+		t.Errorf("Apply recorded truncations %v", exp.Truncations)
+	}
+}
+
+// TestApplySeesNewASGs creates an ASG using the launch configuration of
+// the second step while the first one is applied.
+func TestApplySeesNewASGs(t *testing.T) {
+	f := &fakeAWS{override: map[string]string{"DescribeLaunchConfigurations": lcListing}}
+	exp := newFakeExpedition(t, f, func(in *ExpeditionInput) {
+		in.Hooks.OnPlanStep = func(PlanStepEvent) {
+			f.mu.Lock()
+			f.asgPages = 2
+			f.mu.Unlock()
+		}
+	})
+	exp.account = "123456789012"
+	for _, lc := range []string{"lc-0", "lc-1"} {
+		exp.Plan = append(exp.Plan, &PlanStep{
+			ResourceType: ResourceLaunchConfiguration,
+			ResourceId:   lc,
+			Status:       StepPending,
+		})
+	}
+	exp.Apply()
+	if step := exp.Plan[0]; step.Status != StepDeleted {
+		t.Errorf("lc-0: %s %q, want deleted", step.Status, step.Reason)
+	}
+	want := "launch configuration is used by autoscaling groups [asg-1]"
+	if step := exp.Plan[1]; step.Status != StepSkipped || step.Reason != want {
+		t.Errorf("lc-1: %s %q, want skipped with %q", step.Status, step.Reason, want)
+	}
+}
+
+// TestApplyCanceled makes sure a canceled Apply leaves the remaining
+// steps pending.
+func TestApplyCanceled(t *testing.T) {
+	exp := newFakeExpedition(t, &fakeAWS{}, nil)
+	exp.account = "123456789012"
+	exp.Plan = []*PlanStep{{ResourceType: ResourceSnapshot, ResourceId: "snap-0", Status: StepPending}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := exp.ApplyWithContext(ctx); err == nil {
+		t.Fatal("canceled Apply returned no error")
+	}
+	if exp.Plan[0].Status != StepPending {
+		t.Fatalf("step %s after cancel, want it left pending", exp.Plan[0].Status)
+	}
+}
+
+// TestScriptExists runs the generated script against a fake aws cli
+// whose describe call fails with the given error.
+func TestScriptExists(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash is not available")
+	}
+	exp := newFakeExpedition(t, &fakeAWS{}, nil)
+	exp.Plan = []*PlanStep{{ResourceType: ResourceSnapshot, ResourceId: "snap-0", Status: StepPending}}
+	dir := filepath.Dir(exp.applyJournal)
+	script := filepath.Join(dir, "plan.sh")
+	file, err := os.Create(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = exp.WriteScript(file); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	fake := "#!/bin/sh\nif [ -n \"$FAKE_ERROR\" ]; then echo \"$FAKE_ERROR\" >&2; exit 254; fi\necho 1\n"
+	if err = ioutil.WriteFile(filepath.Join(dir, "aws"), []byte(fake), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		error   string
+		fails   bool
+		deletes bool
+	}{
+		{"", false, true},
+		{"An error occurred (InvalidSnapshot.NotFound) when calling the DescribeSnapshots operation", false, false},
+		{"An error occurred (InvalidLaunchTemplateName.NotFoundException) when calling the DescribeLaunchTemplates operation", false, false},
+		{"An error occurred (ValidationError) when calling the DescribeLaunchConfigurations operation", true, false},
+		{"An error occurred (RequestLimitExceeded) when calling the DescribeSnapshots operation", true, false},
+		{"An error occurred (UnauthorizedOperation) when calling the DescribeSnapshots operation", true, false},
+	} {
+		cmd := exec.Command("bash", script, "--dry-run")
+		cmd.Env = append(os.Environ(), "PATH="+dir+":"+os.Getenv("PATH"), "FAKE_ERROR="+c.error)
+		out, err := cmd.CombinedOutput()
+		if (err != nil) != c.fails {
+			t.Errorf("%q: script returned %v, want failure %v\n%s", c.error, err, c.fails, out)
+		}
+		if deletes := strings.Contains(string(out), "delete-snapshot"); deletes != c.deletes {
+			t.Errorf("%q: deleted %v, want %v\n%s", c.error, deletes, c.deletes, out)
+		}
+	}
+}
+
+func TestVerifyIdentity(t *testing.T) {
+	for _, c := range []struct {
+		account, region string
+		err             string
+	}{
+		{"123456789012", "us-east-1", ""},
+		{"210987654321", "us-east-1", "the session is for account 123456789012 but the results are for account 210987654321"},
+		{"123456789012", "eu-west-1", "the session is for region us-east-1 but the results are for region eu-west-1"},
+	} {
+		exp := newFakeExpedition(t, &fakeAWS{}, nil)
+		exp.account = c.account
+		exp.stateRegion = c.region
+		err := exp.VerifyIdentity(context.Background())
+		if c.err == "" && err != nil || c.err != "" && (err == nil || err.Error() != c.err) {
+			t.Errorf("account %s region %s: got error %v, want %q", c.account, c.region, err, c.err)
+		}
+	}
+}
+
+func TestVolumeSettingsValidated(t *testing.T) {
+	for _, c := range []struct {
+		batchSize, workers int
+		err                string
+	}{
+		{0, 10, "VolumeBatchSize must be at least 1"},
+		{-5, 10, "VolumeBatchSize must be at least 1"},
+		{200, 0, "VolumeWorkers must be at least 1"},
+	} {
+		logger := log15.New()
+		logger.SetHandler(log15.DiscardHandler())
+		_, err := New(&ExpeditionInput{
+			Session:         session.Must(session.NewSession()),
+			Logger:          &logger,
+			VolumeBatchSize: aws.Int(c.batchSize),
+			VolumeWorkers:   aws.Int(c.workers),
+		})
+		if err == nil || err.Error() != c.err {
+			t.Errorf("VolumeBatchSize %d VolumeWorkers %d: got error %v, want %q", c.batchSize, c.workers, err, c.err)
+		}
+	}
+}
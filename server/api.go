@@ -0,0 +1,225 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/GESkunkworks/dustcollector"
+)
+
+// routes registers the API handlers.
+func (srv *Server) routes() {
+	srv.mux = http.NewServeMux()
+	srv.mux.HandleFunc("/runs", srv.handleRuns)
+	srv.mux.HandleFunc("/runs/", srv.handleRun)
+	srv.mux.HandleFunc("/trends", srv.handleTrends)
+	srv.mux.HandleFunc("/notify/test", srv.handleNotifyTest)
+	srv.mux.Handle("/metrics", srv.metrics)
+}
+
+// ServeHTTP serves the REST API.
+func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	srv.mux.ServeHTTP(w, r)
+}
+
+// writeJSON writes v as the json response body.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// writeError writes err as a json error response.
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// errorStatus picks the response status for an error from the Server.
+func errorStatus(err error) int {
+	switch {
+	case err == errBusy:
+		return http.StatusConflict
+	case strings.HasSuffix(err.Error(), "not found"):
+		return http.StatusNotFound
+	}
+	return http.StatusConflict
+}
+
+// handleRuns lists the runs or starts an ad-hoc scan.
+func (srv *Server) handleRuns(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, srv.store.list())
+	case http.MethodPost:
+		if !srv.requireAuth(w, r) {
+			return
+		}
+		run, err := srv.Scan(TriggerManual)
+		if err != nil {
+			writeError(w, errorStatus(err), err)
+			return
+		}
+		w.Header().Set("Location", "/runs/"+run.ID)
+		writeJSON(w, http.StatusAccepted, run)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleRun serves /runs/{id} and its sub-resources.
+func (srv *Server) handleRun(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/runs/"), "/"), "/")
+	id := parts[0]
+	var action string
+	if len(parts) > 1 {
+		action = parts[1]
+	}
+	if len(parts) > 2 {
+		http.NotFound(w, r)
+		return
+	}
+	allow := http.MethodGet
+	if action == "approve" || action == "apply" {
+		allow = http.MethodPost
+	}
+	if r.Method != allow {
+		w.Header().Set("Allow", allow)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if allow == http.MethodPost && !srv.requireAuth(w, r) {
+		return
+	}
+	run, ok := srv.store.get(id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	switch action {
+	case "":
+		writeJSON(w, http.StatusOK, run)
+	case "nuggets", "bars", "plan", "owners":
+		if run.Status == StatusRunning || run.Status == StatusFailed {
+			writeError(w, http.StatusConflict, fmt.Errorf("run %s has no results", id))
+			return
+		}
+		exp, err := srv.store.expedition(id, srv.newExpedition)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		switch action {
+		case "nuggets":
+			writeJSON(w, http.StatusOK, exp.Nuggets)
+		case "bars":
+			writeJSON(w, http.StatusOK, exp.Bars)
+		case "plan":
+			writeJSON(w, http.StatusOK, exp.Plan)
+		case "owners":
+			writeJSON(w, http.StatusOK, exp.GetOwnerReports())
+		}
+	case "approve":
+		// the body is optional: {"approvedBy": "name"}
+		var body struct {
+			ApprovedBy string `json:"approvedBy"`
+		}
+		data, _ := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<16))
+		if len(data) > 0 {
+			err := json.Unmarshal(data, &body)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+		run, err := srv.Approve(id, body.ApprovedBy)
+		if err != nil {
+			writeError(w, errorStatus(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, run)
+	case "apply":
+		run, err := srv.Apply(id)
+		if err != nil {
+			writeError(w, errorStatus(err), err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, run)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleTrends serves the waste and realized savings over time.
+func (srv *Server) handleTrends(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	params := r.URL.Query()
+	q := dustcollector.TrendQuery{
+		Account:  params.Get("account"),
+		Region:   params.Get("region"),
+		Interval: params.Get("interval"),
+	}
+	var err error
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{{"since", &q.Since}, {"until", &q.Until}} {
+		if params.Get(p.name) == "" {
+			continue
+		}
+		*p.t, err = time.Parse("2006-01-02", params.Get(p.name))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid %s: %s", p.name, err))
+			return
+		}
+	}
+	points, err := srv.trends.Query(q)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	switch params.Get("format") {
+	case "", "json":
+		if points == nil {
+			points = []dustcollector.TrendPoint{}
+		}
+		writeJSON(w, http.StatusOK, points)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		dustcollector.WriteTrendsCSV(w, points)
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown format %q", params.Get("format")))
+	}
+}
+
+// handleNotifyTest sends a test notification to every webhook.
+func (srv *Server) handleNotifyTest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !srv.requireAuth(w, r) {
+		return
+	}
+	if srv.notifier == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no notifier configured"))
+		return
+	}
+	err := srv.notifier.Test()
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "sent"})
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// setTLS configures TLS and client certificate authentication from the
+// Input.
+func (srv *Server) setTLS(input *Input) (err error) {
+	value := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	}
+	srv.certFile = value(input.TLSCertFile)
+	srv.keyFile = value(input.TLSKeyFile)
+	caFile := value(input.ClientCAFile)
+	if (srv.certFile == "") != (srv.keyFile == "") {
+		return errors.New("TLSCertFile and TLSKeyFile must be set together")
+	}
+	if srv.certFile == "" {
+		if caFile != "" {
+			return errors.New("ClientCAFile requires TLSCertFile and TLSKeyFile")
+		}
+		return nil
+	}
+	srv.tls = &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile == "" {
+		return nil
+	}
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return err
+	}
+	srv.tls.ClientCAs = x509.NewCertPool()
+	if !srv.tls.ClientCAs.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no certificates found in %s", caFile)
+	}
+	// read-only clients don't need a certificate
+	srv.tls.ClientAuth = tls.VerifyClientCertIfGiven
+	return nil
+}
+
+// authorized reports whether the request may use the mutating routes:
+// it carries the AuthToken as a bearer token or was made with a client
+// certificate verified against the ClientCAFile.
+func (srv *Server) authorized(r *http.Request) bool {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return true
+	}
+	if srv.token == "" {
+		return false
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token := strings.TrimPrefix(auth, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(srv.token)) == 1
+}
+
+// requireAuth writes a 401 response and returns false if the request
+// isn't authorized.
+func (srv *Server) requireAuth(w http.ResponseWriter, r *http.Request) bool {
+	if srv.authorized(r) {
+		return true
+	}
+	srv.log.Warn("refused unauthenticated request", "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr)
+	w.Header().Set("WWW-Authenticate", `Bearer realm="dustcollector"`)
+	writeError(w, http.StatusUnauthorized, errors.New("a bearer token or client certificate is required"))
+	return false
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronDescriptors are the shorthand schedules accepted in place of the
+// five cron fields.
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronField is the allowed range of one field of a cron expression.
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = []cronField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 6},
+}
+
+// schedule is a parsed cron expression. Each field is the set of
+// allowed values indexed by value.
+type schedule struct {
+	minute, hour, dom, month, dow []bool
+
+	// per cron convention if both day of month and day of week are
+	// restricted then a day matching either one is scheduled
+	domStar, dowStar bool
+}
+
+// parseSchedule parses a standard five field cron expression
+// ("minute hour day-of-month month day-of-week") supporting *, lists,
+// ranges, and steps (e.g., "*/15 2-5 * * 1,3") or one of the @hourly,
+// @daily, @weekly, @monthly, or @yearly shorthands. Day of week 7 is
+// accepted as Sunday.
+func parseSchedule(expr string) (s *schedule, err error) {
+	expr = strings.TrimSpace(expr)
+	if d, ok := cronDescriptors[expr]; ok {
+		expr = d
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf(
+			"cron expression %q must have %d fields", expr, len(cronFields),
+		)
+	}
+	sets := make([][]bool, len(cronFields))
+	for i, f := range cronFields {
+		max := f.max
+		if f.name == "day of week" {
+			max = 7
+		}
+		sets[i], err = parseCronField(fields[i], f.min, max)
+		if err != nil {
+			return nil, fmt.Errorf("cron %s: %s", f.name, err)
+		}
+	}
+	if sets[4][7] {
+		sets[4][0] = true
+	}
+	s = &schedule{
+		minute:  sets[0],
+		hour:    sets[1],
+		dom:     sets[2],
+		month:   sets[3],
+		dow:     sets[4],
+		domStar: strings.HasPrefix(fields[2], "*"),
+		dowStar: strings.HasPrefix(fields[4], "*"),
+	}
+	return s, err
+}
+
+// parseCronField parses a comma separated list of values, ranges, and
+// steps into the set of allowed values between min and max.
+func parseCronField(field string, min, max int) (set []bool, err error) {
+	set = make([]bool, max+1)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step in %q", part)
+			}
+			part = part[:i]
+		}
+		lo, hi := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			lo, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid range %q", part)
+			}
+			hi, err = strconv.Atoi(bounds[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid range %q", part)
+			}
+		default:
+			lo, err = strconv.Atoi(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q", part)
+			}
+			hi = lo
+			if step > 1 {
+				// "5/15" means every 15 starting at 5
+				hi = max
+			}
+		}
+		if lo > hi {
+			return nil, fmt.Errorf("invalid range %q", part)
+		}
+		if lo < min || hi > max {
+			return nil, fmt.Errorf("%q is outside %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return set, err
+}
+
+// dayMatches reports whether the day of t is allowed by the schedule.
+func (s *schedule) dayMatches(t time.Time) bool {
+	dom := s.dom[t.Day()]
+	dow := s.dow[int(t.Weekday())]
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// next returns the first time after t that matches the schedule, or the
+// zero time if there is none within five years (e.g., "0 0 30 2 *").
+func (s *schedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !s.month[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.hour[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.minute[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
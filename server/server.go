@@ -0,0 +1,492 @@
+// Package server runs dustcollector Expeditions as a long-running
+// service. Scans are started on a cron schedule or on demand, the
+// results of every run are kept on disk, and a REST API lets clients
+// browse the runs and approve and apply a run's deletion plan.
+//
+// API
+//
+//	GET  /runs                  list all runs, newest first
+//	POST /runs                  start an ad-hoc scan
+//	GET  /runs/{id}             a single run
+//	GET  /runs/{id}/nuggets     the run's Nuggets as json
+//	GET  /runs/{id}/bars        the run's Bars as json
+//	GET  /runs/{id}/plan        the run's deletion plan as json
+//	GET  /runs/{id}/owners      the run's plan and savings split by owner
+//	POST /runs/{id}/approve     approve the run's plan for Apply
+//	POST /runs/{id}/apply       apply an approved plan
+//	GET  /trends                waste and realized savings over time
+//	POST /notify/test           send a test notification to every webhook
+//	GET  /metrics               Prometheus metrics for the latest run
+//
+// The POST routes start scans and delete resources so they are refused
+// unless the client authenticates with the AuthToken as a bearer token
+// ("Authorization: Bearer <token>") or, when the Server serves TLS with
+// a ClientCAFile, with a client certificate signed by one of its CAs.
+// Without either of them configured the API is read-only.
+//
+// The /trends endpoint accepts the account, region, since and until
+// (YYYY-MM-DD), interval (run, day, week, or month), and format (json
+// or csv) query parameters.
+//
+// Only one scan or apply runs at a time; requests that would start
+// another while one is in progress get a 409 Conflict.
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/GESkunkworks/dustcollector"
+	"github.com/inconshreveable/log15"
+)
+
+// errBusy is returned when a scan or apply is already in progress.
+var errBusy = errors.New("a scan or apply is already in progress")
+
+// Input provides configuration inputs for a new Server.
+type Input struct {
+	// ExpeditionInput is used as the template for the Expedition
+	// of every run. The OutfileState and ApplyJournal of each run
+	// are placed in the run's directory under DataDir.
+	//
+	// ExpeditionInput is a required field
+	ExpeditionInput *dustcollector.ExpeditionInput
+
+	// Cron expression ("minute hour day-of-month month day-of-week")
+	// on which scans are started, evaluated in UTC. The @hourly,
+	// @daily, @weekly, @monthly, and @yearly shorthands are accepted.
+	// Set to "" to only scan when requested through the API.
+	// Default: "@daily"
+	Schedule *string
+
+	// Directory in which the history of runs is stored. The
+	// summary of every scan and apply is also recorded in the
+	// trends.jsonl TrendStore in this directory.
+	// Default: "dustcollector-runs"
+	DataDir *string
+
+	// Address on which ListenAndServe listens. Only bind to other
+	// interfaces with an AuthToken or ClientCAFile set and behind TLS.
+	// Default: "127.0.0.1:8080"
+	Addr *string
+
+	// Bearer token that clients must present to use the POST routes.
+	// Default: "" (no token accepted)
+	AuthToken *string
+
+	// PEM certificate and key files with which ListenAndServe serves
+	// TLS. Both must be set to enable TLS.
+	// Default: "" (plain http)
+	TLSCertFile *string
+	TLSKeyFile  *string
+
+	// PEM file of the CAs whose client certificates are accepted for
+	// the POST routes. Requires TLSCertFile and TLSKeyFile.
+	// Default: "" (no client certificates accepted)
+	ClientCAFile *string
+
+	// If set, every completed scan is passed to NotifyScan along
+	// with the previous scan of the same account and region, and
+	// every finished apply is passed to NotifyApply.
+	Notifier *dustcollector.Notifier
+
+	// If no Logger is provided the Logger of the ExpeditionInput
+	// is used.
+	Logger *log15.Logger
+}
+
+// Server schedules Expeditions, keeps the history of their results,
+// and serves the REST API described in the package documentation.
+// Server is an http.Handler so it can also be mounted in an existing
+// http server.
+type Server struct {
+	expInput *dustcollector.ExpeditionInput
+	schedule *schedule
+	addr     string
+	token    string
+	tls      *tls.Config
+	certFile string
+	keyFile  string
+	log      log15.Logger
+	store    *store
+	trends   *dustcollector.TrendStore
+	notifier *dustcollector.Notifier
+	metrics  *dustcollector.MetricsCollector
+	mux      *http.ServeMux
+	mu       sync.Mutex
+	busy     bool
+	stop     chan struct{}
+	http     *http.Server
+}
+
+// New returns a Server for the given Input, loading the history of
+// previous runs from its DataDir.
+func New(input *Input) (srv *Server, err error) {
+	var s Server
+
+	if input.ExpeditionInput == nil {
+		return &s, errors.New("ExpeditionInput is required")
+	}
+	s.expInput = input.ExpeditionInput
+
+	DefaultSchedule := "@daily"
+	if input.Schedule == nil {
+		input.Schedule = &DefaultSchedule
+	}
+	if *input.Schedule != "" {
+		s.schedule, err = parseSchedule(*input.Schedule)
+		if err != nil {
+			return &s, err
+		}
+	}
+
+	DefaultDataDir := "dustcollector-runs"
+	if input.DataDir == nil {
+		input.DataDir = &DefaultDataDir
+	}
+	s.store, err = openStore(*input.DataDir)
+	if err != nil {
+		return &s, err
+	}
+	s.trends, err = dustcollector.OpenTrendStore(filepath.Join(*input.DataDir, trendsFile))
+	if err != nil {
+		return &s, err
+	}
+
+	DefaultAddr := "127.0.0.1:8080"
+	if input.Addr == nil {
+		input.Addr = &DefaultAddr
+	}
+	s.addr = *input.Addr
+	s.notifier = input.Notifier
+
+	if input.Logger == nil {
+		input.Logger = input.ExpeditionInput.Logger
+	}
+	if input.Logger == nil {
+		return &s, errors.New("log15 logger is required")
+	}
+	s.log = *input.Logger
+
+	if input.AuthToken != nil {
+		s.token = *input.AuthToken
+	}
+	err = s.setTLS(input)
+	if err != nil {
+		return &s, err
+	}
+	if s.token == "" && (s.tls == nil || s.tls.ClientCAs == nil) {
+		s.log.Warn("no AuthToken or ClientCAFile set, the api is read-only")
+	}
+
+	s.metrics = dustcollector.NewMetricsCollector()
+	if id := s.latestResults(); id != "" {
+		exp, err := s.store.expedition(id, s.newExpedition)
+		if err != nil {
+			s.log.Warn("unable to load latest run for metrics", "run", id, "error", err.Error())
+		} else {
+			s.metrics.Set(exp)
+		}
+	}
+	s.routes()
+	s.stop = make(chan struct{})
+	return &s, nil
+}
+
+// newExpedition returns a new Expedition for the run with the given ID
+// that saves its state and apply journal in the run's directory.
+func (srv *Server) newExpedition(id string) (exp *dustcollector.Expedition, err error) {
+	input := *srv.expInput
+	state := srv.store.path(id, stateFile)
+	journal := srv.store.path(id, journalFile)
+	input.OutfileState = &state
+	input.ApplyJournal = &journal
+	if input.Logger == nil {
+		input.Logger = &srv.log
+	}
+	return dustcollector.New(&input)
+}
+
+// latestResults returns the ID of the newest run that has results, i.e.
+// the run exposed as metrics, or "" if there is none.
+func (srv *Server) latestResults() string {
+	for _, run := range srv.store.list() {
+		if run.Status != StatusRunning && run.Status != StatusFailed {
+			return run.ID
+		}
+	}
+	return ""
+}
+
+// acquire marks the Server busy, failing with errBusy if it already is.
+func (srv *Server) acquire() (err error) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.busy {
+		return errBusy
+	}
+	srv.busy = true
+	return nil
+}
+
+func (srv *Server) release() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.busy = false
+}
+
+// Scan starts a new run in the background and returns it immediately.
+// It fails if a scan or apply is already in progress.
+func (srv *Server) Scan(trigger string) (run Run, err error) {
+	err = srv.acquire()
+	if err != nil {
+		return run, err
+	}
+	r, err := srv.store.create(trigger)
+	if err != nil {
+		srv.release()
+		return run, err
+	}
+	run = *r
+	go func() {
+		defer srv.release()
+		srv.scan(run.ID)
+	}()
+	return run, nil
+}
+
+// scan runs the Expedition for a run and records its results.
+func (srv *Server) scan(id string) {
+	log := srv.log.New("run", id)
+	log.Info("starting scan")
+	exp, err := srv.newExpedition(id)
+	if err == nil {
+		err = exp.Start()
+	}
+	if err == nil {
+		err = exp.ExportState()
+	}
+	now := time.Now().UTC()
+	if err != nil {
+		log.Error("scan failed", "error", err.Error())
+		srv.store.update(id, func(run *Run) {
+			run.Status = StatusFailed
+			run.Error = err.Error()
+			run.EndTime = &now
+		})
+		return
+	}
+	srv.store.setExpedition(id, exp)
+	srv.metrics.Set(exp)
+	err = srv.trends.Record(exp.TrendRecord(dustcollector.TrendScan, id))
+	if err != nil {
+		log.Error("unable to record trend", "error", err.Error())
+	}
+	if srv.notifier != nil {
+		err = srv.notifier.NotifyScan(exp, srv.previousScan(exp, id), id)
+		if err != nil {
+			log.Error("unable to send scan notification", "error", err.Error())
+		}
+	}
+	summary := exp.GetSummary()
+	err = srv.store.update(id, func(run *Run) {
+		run.Status = StatusCompleted
+		run.EndTime = &now
+		run.Account = summary.Account
+		run.Region = summary.Region
+		run.SnapshotsInScope = summary.SnapshotCount
+		run.SnapshotsToDelete = len(summary.Snapshots)
+		run.SnapshotsSpared = summary.SparedCount
+		run.PlanSteps = len(exp.Plan)
+		run.TotalGbs = summary.TotalGbs
+		run.Savings = summary.Savings
+	})
+	if err != nil {
+		log.Error("unable to save run", "error", err.Error())
+		return
+	}
+	log.Info("scan complete", "plan_steps", len(exp.Plan), "savings", summary.Savings)
+}
+
+// previousScan returns the results of the latest successful run before
+// the given one for the same account and region, or nil if there is none.
+func (srv *Server) previousScan(exp *dustcollector.Expedition, id string) *dustcollector.Expedition {
+	summary := exp.GetSummary()
+	for _, run := range srv.store.list() {
+		if run.ID >= id || run.Status == StatusRunning || run.Status == StatusFailed {
+			continue
+		}
+		if run.Account != summary.Account || run.Region != summary.Region {
+			continue
+		}
+		prev, err := srv.store.expedition(run.ID, srv.newExpedition)
+		if err != nil {
+			srv.log.Warn("unable to load previous run", "run", run.ID, "error", err.Error())
+			return nil
+		}
+		return prev
+	}
+	return nil
+}
+
+// Approve marks the plan of a completed run as approved so that it can
+// be applied.
+func (srv *Server) Approve(id, approvedBy string) (run Run, err error) {
+	now := time.Now().UTC()
+	var notCompleted error
+	err = srv.store.update(id, func(r *Run) {
+		if r.Status != StatusCompleted {
+			notCompleted = fmt.Errorf(
+				"run %s is %s, only completed runs can be approved", id, r.Status,
+			)
+			return
+		}
+		r.Approved = true
+		r.ApprovedBy = approvedBy
+		r.ApprovedAt = &now
+	})
+	if err != nil {
+		return run, err
+	}
+	run, _ = srv.store.get(id)
+	return run, notCompleted
+}
+
+// Apply starts applying the approved plan of a run in the background
+// and returns the run immediately. A run whose apply failed can be
+// applied again; it resumes from the run's apply journal.
+func (srv *Server) Apply(id string) (run Run, err error) {
+	run, ok := srv.store.get(id)
+	if !ok {
+		return run, fmt.Errorf("run %s not found", id)
+	}
+	if !run.Approved {
+		return run, fmt.Errorf("the plan of run %s has not been approved", id)
+	}
+	if run.Status != StatusCompleted && run.Status != StatusApplyFailed {
+		return run, fmt.Errorf("run %s is %s and can't be applied", id, run.Status)
+	}
+	err = srv.acquire()
+	if err != nil {
+		return run, err
+	}
+	// the cached Expedition is being read by other requests so the
+	// plan is applied on a private copy that replaces it when done
+	exp, err := srv.store.load(id, srv.newExpedition)
+	if err != nil {
+		srv.release()
+		return run, err
+	}
+	err = srv.store.update(id, func(r *Run) {
+		r.Status = StatusApplying
+		r.Error = ""
+	})
+	if err != nil {
+		srv.release()
+		return run, err
+	}
+	run, _ = srv.store.get(id)
+	go func() {
+		defer srv.release()
+		srv.apply(id, exp)
+	}()
+	return run, nil
+}
+
+// apply runs the plan of a run and records the outcome.
+func (srv *Server) apply(id string, exp *dustcollector.Expedition) {
+	log := srv.log.New("run", id)
+	log.Info("applying plan")
+	err := exp.Apply()
+	// keep the step outcomes with the rest of the results
+	if serr := exp.ExportState(); serr != nil {
+		log.Error("unable to save state after apply", "error", serr.Error())
+	}
+	srv.store.setExpedition(id, exp)
+	if srv.latestResults() == id {
+		srv.metrics.Set(exp)
+	}
+	if terr := srv.trends.Record(exp.TrendRecord(dustcollector.TrendApply, id)); terr != nil {
+		log.Error("unable to record trend", "error", terr.Error())
+	}
+	if srv.notifier != nil {
+		if nerr := srv.notifier.NotifyApply(exp, id, err); nerr != nil {
+			log.Error("unable to send apply notification", "error", nerr.Error())
+		}
+	}
+	now := time.Now().UTC()
+	srv.store.update(id, func(run *Run) {
+		run.AppliedAt = &now
+		if err != nil {
+			run.Status = StatusApplyFailed
+			run.Error = err.Error()
+			return
+		}
+		run.Status = StatusApplied
+	})
+	if err != nil {
+		log.Error("apply failed", "error", err.Error())
+		return
+	}
+	log.Info("apply complete")
+}
+
+// runScheduler starts a scan every time the schedule comes due until
+// the Server is closed.
+func (srv *Server) runScheduler() {
+	if srv.schedule == nil {
+		srv.log.Info("no schedule set, scans will only start through the API")
+		return
+	}
+	for {
+		next := srv.schedule.next(time.Now().UTC())
+		if next.IsZero() {
+			srv.log.Warn("schedule never comes due, no scans will be scheduled")
+			return
+		}
+		srv.log.Info("next scheduled scan", "time", next.Format(time.RFC3339))
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+			_, err := srv.Scan(TriggerSchedule)
+			if err != nil {
+				srv.log.Warn("skipping scheduled scan", "error", err.Error())
+			}
+		case <-srv.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// ListenAndServe starts the scheduler and serves the API on the
+// configured Addr until Close is called.
+func (srv *Server) ListenAndServe() (err error) {
+	go srv.runScheduler()
+	srv.http = &http.Server{Addr: srv.addr, Handler: srv, TLSConfig: srv.tls}
+	srv.log.Info("serving dustcollector api", "addr", srv.addr, "tls", srv.tls != nil)
+	if srv.tls != nil {
+		err = srv.http.ListenAndServeTLS(srv.certFile, srv.keyFile)
+	} else {
+		err = srv.http.ListenAndServe()
+	}
+	if err == http.ErrServerClosed {
+		err = nil
+	}
+	return err
+}
+
+// Close stops the scheduler and the http server. Scans and applies that
+// are in progress are not interrupted.
+func (srv *Server) Close() (err error) {
+	close(srv.stop)
+	if srv.http != nil {
+		err = srv.http.Close()
+	}
+	return err
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/GESkunkworks/dustcollector"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/inconshreveable/log15"
+)
+
+// testInput fills in an Input for a Server without a schedule whose
+// history is kept in a temporary directory.
+func testInput(t *testing.T, input *Input) *Input {
+	dir, err := ioutil.TempDir("", "dustcollector-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	schedule := ""
+	input.ExpeditionInput = &dustcollector.ExpeditionInput{Logger: &logger}
+	input.Schedule = &schedule
+	input.DataDir = &dir
+	return input
+}
+
+func newTestServer(t *testing.T, input *Input) *Server {
+	srv, err := New(testInput(t, input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv
+}
+
+func TestDefaultAddrIsLoopback(t *testing.T) {
+	srv := newTestServer(t, &Input{})
+	if srv.addr != "127.0.0.1:8080" {
+		t.Fatalf("default addr %q, want 127.0.0.1:8080", srv.addr)
+	}
+}
+
+func TestMutatingRoutesRequireAuth(t *testing.T) {
+	token := "s3cret"
+	for _, c := range []struct {
+		name   string
+		token  *string
+		method string
+		path   string
+		auth   string
+		status int
+	}{
+		{"read without token", &token, http.MethodGet, "/runs", "", http.StatusOK},
+		{"scan without token", &token, http.MethodPost, "/runs", "", http.StatusUnauthorized},
+		{"scan with wrong token", &token, http.MethodPost, "/runs", "Bearer nope", http.StatusUnauthorized},
+		{"approve without token", &token, http.MethodPost, "/runs/x/approve", "", http.StatusUnauthorized},
+		{"apply without token", &token, http.MethodPost, "/runs/x/apply", "", http.StatusUnauthorized},
+		{"apply with basic auth", &token, http.MethodPost, "/runs/x/apply", "Basic czNjcmV0", http.StatusUnauthorized},
+		{"notify without token", &token, http.MethodPost, "/notify/test", "", http.StatusUnauthorized},
+		{"apply with token", &token, http.MethodPost, "/runs/x/apply", "Bearer s3cret", http.StatusNotFound},
+		{"notify with token", &token, http.MethodPost, "/notify/test", "Bearer s3cret", http.StatusNotFound},
+		{"read-only server", nil, http.MethodPost, "/runs/x/approve", "Bearer ", http.StatusUnauthorized},
+	} {
+		srv := newTestServer(t, &Input{AuthToken: c.token})
+		r := httptest.NewRequest(c.method, c.path, nil)
+		if c.auth != "" {
+			r.Header.Set("Authorization", c.auth)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		if w.Code != c.status {
+			t.Errorf("%s: %s %s returned %d, want %d", c.name, c.method, c.path, w.Code, c.status)
+		}
+	}
+}
+
+func TestClientCARequiresTLS(t *testing.T) {
+	ca := "ca.pem"
+	_, err := New(testInput(t, &Input{ClientCAFile: &ca}))
+	if err == nil || err.Error() != "ClientCAFile requires TLSCertFile and TLSKeyFile" {
+		t.Fatal("ClientCAFile accepted without TLSCertFile and TLSKeyFile")
+	}
+}
+
+// TestApplyWhileReading applies a plan while its results are being
+// served. The account has no launch configurations, templates, or
+// ASGs and every other AWS call fails so each step of the plan fails.
+// Run it with -race.
+func TestApplyWhileReading(t *testing.T) {
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		switch action := r.Form.Get("Action"); action {
+		case "DescribeLaunchConfigurations", "DescribeAutoScalingGroups":
+			fmt.Fprintf(w, `<%sResponse><%sResult></%sResult></%sResponse>`, action, action, action, action)
+			return
+		case "DescribeLaunchTemplateVersions":
+			fmt.Fprint(w, `<DescribeLaunchTemplateVersionsResponse><launchTemplateVersionSet/></DescribeLaunchTemplateVersionsResponse>`)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `<Response><Errors><Error><Code>UnauthorizedOperation</Code><Message>denied</Message></Error></Errors></Response>`)
+	}))
+	defer endpoint.Close()
+	token := "s3cret"
+	input := testInput(t, &Input{AuthToken: &token})
+	input.ExpeditionInput.Session = session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(endpoint.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	}))
+	input.ExpeditionInput.RateLimits = map[string]float64{"ec2:read": 0, "ec2:write": 0, "autoscaling:read": 0, "autoscaling:write": 0, "sts:read": 0}
+	srv, err := New(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run, err := srv.store.create(TriggerManual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var plan []*dustcollector.PlanStep
+	for i := 0; i < 50; i++ {
+		plan = append(plan, &dustcollector.PlanStep{
+			ResourceType: dustcollector.ResourceSnapshot,
+			ResourceId:   fmt.Sprintf("snap-%d", i),
+			Status:       dustcollector.StepPending,
+		})
+	}
+	state, _ := json.Marshal(map[string]interface{}{
+		"version": 1, "account": "123456789012", "region": "us-east-1",
+		"dateFilter": "2019-01-01", "plan": plan,
+	})
+	err = ioutil.WriteFile(srv.store.path(run.ID, stateFile), state, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.store.update(run.ID, func(r *Run) { r.Status = StatusCompleted })
+	if _, err = srv.Approve(run.ID, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+	if w := get("/runs/" + run.ID + "/plan"); w.Code != http.StatusOK {
+		t.Fatalf("plan returned %d: %s", w.Code, w.Body)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/runs/"+run.ID+"/apply", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("apply returned %d: %s", w.Code, w.Body)
+	}
+	deadline := time.Now().Add(time.Minute)
+	for {
+		get("/runs/" + run.ID + "/plan")
+		get("/runs/" + run.ID + "/owners")
+		get("/metrics")
+		current, _ := srv.store.get(run.ID)
+		if current.Status != StatusApplying {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("apply didn't finish")
+		}
+	}
+	// wait for the busy flag so the next test starts clean
+	for srv.acquire() != nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+	srv.release()
+	var applied []*dustcollector.PlanStep
+	json.Unmarshal(get("/runs/"+run.ID+"/plan").Body.Bytes(), &applied)
+	if len(applied) != len(plan) || applied[0].Status != dustcollector.StepFailed {
+		t.Fatalf("applied plan not published: %+v", applied[0])
+	}
+}
@@ -0,0 +1,253 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/GESkunkworks/dustcollector"
+)
+
+// Run statuses
+const (
+	StatusRunning     = "running"
+	StatusCompleted   = "completed"
+	StatusFailed      = "failed"
+	StatusApplying    = "applying"
+	StatusApplied     = "applied"
+	StatusApplyFailed = "apply-failed"
+)
+
+// What started a Run
+const (
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+)
+
+// Files kept in the directory of each Run
+const (
+	runFile     = "run.json"
+	stateFile   = "state.json"
+	journalFile = "apply-journal.jsonl"
+)
+
+// trendsFile is the TrendStore in the data directory.
+const trendsFile = "trends.jsonl"
+
+// maxCachedExpeditions is the number of Run results kept in memory.
+const maxCachedExpeditions = 5
+
+// Run is the record of a single scheduled or ad-hoc Expedition along
+// with the headline numbers of its results.
+type Run struct {
+	ID        string     `json:"id"`
+	Trigger   string     `json:"trigger"`
+	Status    string     `json:"status"`
+	Error     string     `json:"error,omitempty"`
+	StartTime time.Time  `json:"startTime"`
+	EndTime   *time.Time `json:"endTime,omitempty"`
+
+	Account           string  `json:"account,omitempty"`
+	Region            string  `json:"region,omitempty"`
+	SnapshotsInScope  int     `json:"snapshotsInScope"`
+	SnapshotsToDelete int     `json:"snapshotsToDelete"`
+	SnapshotsSpared   int     `json:"snapshotsSpared"`
+	PlanSteps         int     `json:"planSteps"`
+	TotalGbs          int64   `json:"totalGbs"`
+	Savings           float64 `json:"savings"`
+
+	// A plan must be approved before it can be applied
+	Approved   bool       `json:"approved"`
+	ApprovedBy string     `json:"approvedBy,omitempty"`
+	ApprovedAt *time.Time `json:"approvedAt,omitempty"`
+	AppliedAt  *time.Time `json:"appliedAt,omitempty"`
+}
+
+// store keeps every Run in its own directory under dir so the history
+// survives restarts. The results of each Run are saved with
+// Expedition.ExportState and loaded back on demand.
+type store struct {
+	dir  string
+	mu   sync.Mutex
+	runs map[string]*Run
+	exps map[string]*dustcollector.Expedition
+}
+
+// openStore loads the Runs saved under dir, creating it if needed. Runs
+// that were still in progress when the server stopped are marked failed.
+func openStore(dir string) (st *store, err error) {
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	st = &store{
+		dir:  dir,
+		runs: make(map[string]*Run),
+		exps: make(map[string]*dustcollector.Expedition),
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, entry.Name(), runFile))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var run Run
+		err = json.Unmarshal(data, &run)
+		if err != nil {
+			return nil, fmt.Errorf("parsing run %s: %s", entry.Name(), err)
+		}
+		switch run.Status {
+		case StatusRunning:
+			run.Status = StatusFailed
+			run.Error = "interrupted by server restart"
+		case StatusApplying:
+			run.Status = StatusApplyFailed
+			run.Error = "interrupted by server restart"
+		}
+		st.runs[run.ID] = &run
+	}
+	return st, nil
+}
+
+// path returns the path of a file in the directory of a Run.
+func (st *store) path(id, name string) string {
+	return filepath.Join(st.dir, id, name)
+}
+
+// create starts a new Run with an ID based on the current time.
+func (st *store) create(trigger string) (run *Run, err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	now := time.Now().UTC()
+	id := now.Format("20060102T150405Z")
+	for i := 2; st.runs[id] != nil; i++ {
+		id = fmt.Sprintf("%s-%d", now.Format("20060102T150405Z"), i)
+	}
+	run = &Run{
+		ID:        id,
+		Trigger:   trigger,
+		Status:    StatusRunning,
+		StartTime: now,
+	}
+	err = os.MkdirAll(filepath.Join(st.dir, id), 0755)
+	if err != nil {
+		return nil, err
+	}
+	st.runs[id] = run
+	return run, st.saveLocked(run)
+}
+
+// update applies fn to the Run under the store lock and saves it.
+func (st *store) update(id string, fn func(run *Run)) (err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	run, ok := st.runs[id]
+	if !ok {
+		return fmt.Errorf("run %s not found", id)
+	}
+	fn(run)
+	return st.saveLocked(run)
+}
+
+func (st *store) saveLocked(run *Run) (err error) {
+	data, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(st.path(run.ID, runFile), data, 0644)
+}
+
+// get returns a copy of the Run with the given ID.
+func (st *store) get(id string) (run Run, ok bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	r, ok := st.runs[id]
+	if !ok {
+		return run, false
+	}
+	return *r, true
+}
+
+// list returns copies of all Runs, newest first.
+func (st *store) list() (runs []Run) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	runs = make([]Run, 0, len(st.runs))
+	for _, run := range st.runs {
+		runs = append(runs, *run)
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].StartTime.After(runs[j].StartTime)
+	})
+	return runs
+}
+
+// setExpedition caches the Expedition holding the results of a Run.
+// Only the most recent Expeditions are kept in memory; older ones are
+// loaded again from their state file when needed.
+func (st *store) setExpedition(id string, exp *dustcollector.Expedition) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.exps[id] = exp
+	for len(st.exps) > maxCachedExpeditions {
+		var oldest string
+		for cached := range st.exps {
+			if oldest == "" || cached < oldest {
+				oldest = cached
+			}
+		}
+		delete(st.exps, oldest)
+	}
+}
+
+// expedition returns the Expedition holding the results of a Run,
+// loading it from its saved state with a new Expedition from newExp if
+// it isn't cached. The Expedition is shared and is only read.
+func (st *store) expedition(
+	id string, newExp func(id string) (*dustcollector.Expedition, error),
+) (exp *dustcollector.Expedition, err error) {
+	st.mu.Lock()
+	exp, ok := st.exps[id]
+	st.mu.Unlock()
+	if ok {
+		return exp, nil
+	}
+	exp, err = st.load(id, newExp)
+	if err != nil {
+		return nil, err
+	}
+	st.setExpedition(id, exp)
+	return exp, nil
+}
+
+// load returns a new Expedition from newExp holding the results of a Run
+// loaded from its saved state, without caching it. The Expeditions
+// handed out by expedition are shared with concurrent requests and must
+// not be modified, so a run is applied on one returned by load.
+func (st *store) load(
+	id string, newExp func(id string) (*dustcollector.Expedition, error),
+) (exp *dustcollector.Expedition, err error) {
+	exp, err = newExp(id)
+	if err != nil {
+		return nil, err
+	}
+	err = exp.LoadState(st.path(id, stateFile))
+	if err != nil {
+		return nil, err
+	}
+	return exp, nil
+}